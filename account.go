@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	anonymize := false
+	switch r.URL.Query().Get("chirps") {
+	case "", "delete":
+	case "anonymize":
+		anonymize = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "chirps must be either delete or anonymize")
+		return
+	}
+
+	user, err := cfg.DB.ScheduleUserDeletion(userId, time.Now().Add(cfg.deletionGracePeriod), anonymize)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to schedule account deletion")
+		return
+	}
+
+	type response struct {
+		Id                  int       `json:"id"`
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+		AnonymizeChirps     bool      `json:"anonymize_chirps"`
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		Id:                  user.Id,
+		DeletionScheduledAt: *user.DeletionScheduledAt,
+		AnonymizeChirps:     user.AnonymizeOnDelete,
+	})
+}
+
+type userDataExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile    struct {
+		Id            int       `json:"id"`
+		Email         string    `json:"email"`
+		Handle        string    `json:"handle"`
+		DisplayName   string    `json:"display_name"`
+		Bio           string    `json:"bio"`
+		AvatarURL     string    `json:"avatar_url"`
+		Is_chirpy_red bool      `json:"is_chirpy_red"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+	} `json:"profile"`
+	Chirps            []jsonDB.Chirp           `json:"chirps"`
+	MembershipHistory []jsonDB.MembershipEvent `json:"membership_history"`
+}
+
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		respondWithError(w, http.StatusBadRequest, "format must be either json or zip")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpsByAuthor(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
+		return
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].Id < chirps[j].Id
+	})
+
+	events, err := cfg.DB.GetMembershipEvents(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch membership history")
+		return
+	}
+
+	export := userDataExport{
+		ExportedAt:        time.Now().UTC(),
+		Chirps:            chirps,
+		MembershipHistory: events,
+	}
+	export.Profile.Id = user.Id
+	export.Profile.Email = user.Email
+	export.Profile.Handle = user.Handle
+	export.Profile.DisplayName = user.DisplayName
+	export.Profile.Bio = user.Bio
+	export.Profile.AvatarURL = user.AvatarURL
+	export.Profile.Is_chirpy_red = user.IsChirpyRed(time.Now())
+	export.Profile.CreatedAt = user.CreatedAt
+	export.Profile.UpdatedAt = user.UpdatedAt
+
+	filename := fmt.Sprintf("chirpy-export-%d", user.Id)
+
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		respondWithJSON(w, http.StatusOK, export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	w.WriteHeader(http.StatusOK)
+
+	err = writeExportZip(w, export)
+	if err != nil {
+		log.Printf("Error writing export archive: %s", err)
+	}
+}
+
+// writeExportZip writes each part of the export as a separate JSON file in
+// a zip archive
+func writeExportZip(w http.ResponseWriter, export userDataExport) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"chirps.json", export.Chirps},
+		{"membership_history.json", export.MembershipHistory},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(fw)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// purgeDeletedUsersLoop removes accounts whose deletion grace period has run
+// out
+func (cfg *apiConfig) purgeDeletedUsersLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := cfg.DB.PurgeDeletedUsers(time.Now().UTC())
+		if err != nil {
+			log.Printf("Error purging deleted users: %s", err)
+			continue
+		}
+		cfg.deleteMediaBlobs(result.MediaIds)
+		if result.Users > 0 {
+			log.Printf("Purged %d deleted users", result.Users)
+			err = cfg.rebuildSearchIndex()
+			if err != nil {
+				log.Printf("Error rebuilding search index: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/activitypub"
+	"github.com/emilmalmsten/chirpy/internal/events"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+const (
+	federationTimeout    = 10 * time.Second
+	signatureTolerance   = 5 * time.Minute
+	remoteActorMaxAge    = 24 * time.Hour
+	maxInboxBodySize     = 1 << 20
+	outboxPageSize       = 20
+	activityPollInterval = 30 * time.Second
+
+	// actorFetchesPerHost and actorFetchesTotal limit how often signatures
+	// with keys we don't know yet make us fetch actors, per host of the key
+	// and overall, in each ratelimit window
+	actorFetchesPerHost = 60
+	actorFetchesTotal   = 1000
+)
+
+var errActorFetchLimited = errors.New("too many actor lookups")
+
+// federationURL returns the base of the ids of our ActivityPub objects.
+// Unlike links in API responses they can't depend on the request, since
+// other servers store them, so PUBLIC_URL should be set on a federating
+// server
+func (cfg *apiConfig) federationURL() string {
+	if cfg.publicURL != "" {
+		return cfg.publicURL
+	}
+	return "http://" + serverAddr
+}
+
+func (cfg *apiConfig) actorURL(userId int) string {
+	return fmt.Sprintf("%s/ap/users/%d", cfg.federationURL(), userId)
+}
+
+func (cfg *apiConfig) actorKeyId(userId int) string {
+	return cfg.actorURL(userId) + "#main-key"
+}
+
+func (cfg *apiConfig) noteURL(chirpId int) string {
+	return fmt.Sprintf("%s/ap/chirps/%d", cfg.federationURL(), chirpId)
+}
+
+// localId returns the number at the end of one of our object URLs, such as
+// the user ID of an actor URL
+func (cfg *apiConfig) localId(objectURL, collection string) (int, bool) {
+	idString, ok := strings.CutPrefix(objectURL, cfg.federationURL()+"/ap/"+collection+"/")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func respondWithActivity(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(code)
+	w.Write(dat)
+}
+
+// actorFromParam looks up the user named by the {userID} URL parameter and
+// writes an error response if there is none. Users scheduled for deletion
+// are no longer federated
+func (cfg *apiConfig) actorFromParam(w http.ResponseWriter, r *http.Request) (jsonDB.User, bool) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id")
+		return jsonDB.User{}, false
+	}
+
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return jsonDB.User{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return jsonDB.User{}, false
+	}
+	if user.DeletionScheduledAt != nil {
+		respondWithError(w, http.StatusGone, "user is being deleted")
+		return jsonDB.User{}, false
+	}
+	return user, true
+}
+
+// actorKey returns the key pair of a user, generating one the first time
+// it is needed
+func (cfg *apiConfig) actorKey(userId int) (jsonDB.ActorKey, error) {
+	key, err := cfg.DB.GetActorKey(userId)
+	if err == nil || !errors.Is(err, jsonDB.ErrDoesNotExists) {
+		return key, err
+	}
+
+	privatePEM, publicPEM, err := activitypub.GenerateKey()
+	if err != nil {
+		return jsonDB.ActorKey{}, err
+	}
+	return cfg.DB.CreateActorKey(userId, privatePEM, publicPEM)
+}
+
+func (cfg *apiConfig) newActor(user jsonDB.User, key jsonDB.ActorKey) activitypub.Actor {
+	base := cfg.federationURL()
+	id := cfg.actorURL(user.Id)
+	published := user.CreatedAt
+
+	actor := activitypub.Actor{
+		Context:           []string{activitypub.ActivityStreamsContext, activitypub.SecurityContext},
+		Id:                id,
+		Type:              activitypub.TypePerson,
+		PreferredUsername: user.Handle,
+		Name:              user.DisplayName,
+		URL:               base + "/api/users/" + user.Handle,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		Endpoints:         &activitypub.Endpoints{SharedInbox: base + "/ap/inbox"},
+		PublicKey: activitypub.PublicKey{
+			Id:           cfg.actorKeyId(user.Id),
+			Owner:        id,
+			PublicKeyPem: key.PublicKeyPEM,
+		},
+		Published: &published,
+	}
+	if user.Bio != "" {
+		actor.Summary = noteContent(user.Bio)
+	}
+	if user.AvatarURL != "" {
+		actor.Icon = &activitypub.Image{Type: activitypub.TypeImage, URL: user.AvatarURL}
+	}
+	return actor
+}
+
+// noteContent turns the plain text of a chirp into the HTML content of a
+// note
+func noteContent(text string) string {
+	return "<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</p>"
+}
+
+// newNote turns a chirp into a note addressed to the public and the
+// author's followers. attachments holds the media of the chirp
+func (cfg *apiConfig) newNote(chirp jsonDB.Chirp, attachments map[string]attachmentResponse) activitypub.Note {
+	base := cfg.federationURL()
+	published := chirp.CreatedAt
+
+	note := activitypub.Note{
+		Id:           cfg.noteURL(chirp.Id),
+		Type:         activitypub.TypeNote,
+		AttributedTo: cfg.actorURL(chirp.AuthorId),
+		Content:      noteContent(chirp.Body),
+		URL:          fmt.Sprintf("%s/api/chirps/%d", base, chirp.Id),
+		Published:    &published,
+		To:           []string{activitypub.Public},
+		Cc:           []string{cfg.actorURL(chirp.AuthorId) + "/followers"},
+	}
+	if chirp.Edited {
+		updated := chirp.UpdatedAt
+		note.Updated = &updated
+	}
+	if chirp.InReplyTo != 0 {
+		note.InReplyTo = cfg.noteURL(chirp.InReplyTo)
+	}
+	for _, tag := range chirpHashtags(chirp) {
+		note.Tag = append(note.Tag, activitypub.Tag{
+			Type: activitypub.TypeHashtag,
+			Href: base + "/api/tags/" + url.PathEscape(tag),
+			Name: "#" + tag,
+		})
+	}
+	for _, id := range chirp.MediaIds {
+		attachment, ok := attachments[id]
+		if !ok {
+			continue
+		}
+		attachmentType := activitypub.TypeDocument
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			attachmentType = activitypub.TypeImage
+		}
+		note.Attachment = append(note.Attachment, activitypub.Attachment{
+			Type:      attachmentType,
+			MediaType: attachment.ContentType,
+			URL:       base + attachment.URL,
+		})
+	}
+	return note
+}
+
+// newCreate wraps a note in the activity that announces it
+func newCreate(note activitypub.Note) activitypub.Activity {
+	return activitypub.Activity{
+		Context:   activitypub.ActivityStreamsContext,
+		Id:        note.Id + "#create",
+		Type:      activitypub.TypeCreate,
+		Actor:     note.AttributedTo,
+		Object:    note,
+		Published: note.Published,
+		To:        note.To,
+		Cc:        note.Cc,
+	}
+}
+
+// handlerWebFinger answers WebFinger queries for acct:handle@host, which is
+// how other servers find the actor behind an address
+func (cfg *apiConfig) handlerWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		respondWithError(w, http.StatusBadRequest, "resource is required")
+		return
+	}
+
+	base, err := url.Parse(cfg.federationURL())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "invalid federation url")
+		return
+	}
+
+	var user jsonDB.User
+	if userId, ok := cfg.localId(resource, "users"); ok {
+		user, err = cfg.DB.GetUser(userId)
+	} else {
+		account := strings.TrimPrefix(resource, "acct:")
+		handle, host, ok := strings.Cut(account, "@")
+		if !ok || !strings.EqualFold(host, base.Host) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		user, err = cfg.DB.GetUserByHandle(handle)
+	}
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+	if user.DeletionScheduledAt != nil {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	actorURL := cfg.actorURL(user.Id)
+	profileURL := cfg.federationURL() + "/api/users/" + user.Handle
+	dat, err := json.Marshal(activitypub.WebFinger{
+		Subject: fmt.Sprintf("acct:%s@%s", user.Handle, base.Host),
+		Aliases: []string{actorURL, profileURL},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actorURL},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: profileURL},
+		},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to encode webfinger")
+		return
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerGetActor(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.actorFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := cfg.actorKey(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to retrieve actor key")
+		return
+	}
+
+	respondWithActivity(w, http.StatusOK, cfg.newActor(user, key))
+}
+
+// handlerGetOutbox serves the Create activities of a user's chirps. Without
+// ?page=true only the size of the collection and a link to its first page
+// are returned; pages go back in time through ?max_id
+func (cfg *apiConfig) handlerGetOutbox(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.actorFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	outboxURL := cfg.actorURL(user.Id) + "/outbox"
+	if r.URL.Query().Get("page") != "true" {
+		chirps, err := cfg.DB.GetChirpsByAuthor(user.Id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to retrieve chirps")
+			return
+		}
+		total := 0
+		for _, chirp := range chirps {
+			if chirp.Visible() {
+				total++
+			}
+		}
+
+		respondWithActivity(w, http.StatusOK, activitypub.OrderedCollection{
+			Context:    activitypub.ActivityStreamsContext,
+			Id:         outboxURL,
+			Type:       activitypub.TypeOrderedCollection,
+			TotalItems: total,
+			First:      outboxURL + "?page=true",
+		})
+		return
+	}
+
+	maxId := 0
+	if maxIdString := r.URL.Query().Get("max_id"); maxIdString != "" {
+		var err error
+		maxId, err = strconv.Atoi(maxIdString)
+		if err != nil || maxId < 1 {
+			respondWithError(w, http.StatusBadRequest, "invalid max_id")
+			return
+		}
+	}
+
+	chirps, err := cfg.DB.GetChirpsPage(jsonDB.ChirpQuery{
+		AuthorId:   user.Id,
+		AfterId:    maxId,
+		Descending: true,
+		Limit:      outboxPageSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to retrieve chirps")
+		return
+	}
+
+	attachments, err := cfg.attachmentResponses(chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp media")
+		return
+	}
+
+	page := activitypub.OrderedCollection{
+		Context:    activitypub.ActivityStreamsContext,
+		Id:         outboxURL + "?" + r.URL.RawQuery,
+		Type:       activitypub.TypeOrderedCollectionPage,
+		TotalItems: len(chirps),
+		PartOf:     outboxURL,
+		Items:      make([]interface{}, 0, len(chirps)),
+	}
+	for _, chirp := range chirps {
+		create := newCreate(cfg.newNote(chirp, attachments))
+		create.Context = nil
+		page.Items = append(page.Items, create)
+	}
+	if len(chirps) == outboxPageSize {
+		page.Next = fmt.Sprintf("%s?page=true&max_id=%d", outboxURL, chirps[len(chirps)-1].Id)
+	}
+
+	respondWithActivity(w, http.StatusOK, page)
+}
+
+// handlerGetFollowersCollection serves the number of followers of a user,
+// local and remote. The followers themselves are not listed
+func (cfg *apiConfig) handlerGetFollowersCollection(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.actorFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	localFollowers, _, err := cfg.DB.CountFollows(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count followers")
+		return
+	}
+	remoteFollowers, err := cfg.DB.GetRemoteFollowers(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count followers")
+		return
+	}
+
+	respondWithActivity(w, http.StatusOK, activitypub.OrderedCollection{
+		Context:    activitypub.ActivityStreamsContext,
+		Id:         cfg.actorURL(user.Id) + "/followers",
+		Type:       activitypub.TypeOrderedCollection,
+		TotalItems: localFollowers + len(remoteFollowers),
+	})
+}
+
+func (cfg *apiConfig) handlerGetNote(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpId)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to retrieve chirp")
+		return
+	}
+	if !chirp.Visible() || chirp.AuthorId == 0 {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+
+	attachments, err := cfg.attachmentResponses([]jsonDB.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp media")
+		return
+	}
+
+	note := cfg.newNote(chirp, attachments)
+	note.Context = activitypub.ActivityStreamsContext
+	respondWithActivity(w, http.StatusOK, note)
+}
+
+// fetchRemoteActor fetches a remote actor from its server. Fetches are rate
+// limited per host and overall, since anyone can make us fetch an actor by
+// signing a request with a made-up key
+func (cfg *apiConfig) fetchRemoteActor(ctx context.Context, actorId string) (jsonDB.RemoteActor, error) {
+	actorURL, err := url.Parse(actorId)
+	if err != nil || (actorURL.Scheme != "https" && actorURL.Scheme != "http") || actorURL.Host == "" {
+		return jsonDB.RemoteActor{}, fmt.Errorf("invalid actor id %q", actorId)
+	}
+	now := time.Now()
+	if !cfg.rateLimiter.Allow("actor-fetch:"+strings.ToLower(actorURL.Host), actorFetchesPerHost, now).Allowed ||
+		!cfg.rateLimiter.Allow("actor-fetch", actorFetchesTotal, now).Allowed {
+		return jsonDB.RemoteActor{}, errActorFetchLimited
+	}
+
+	fetched, err := cfg.federation.FetchActor(ctx, actorId)
+	if err != nil {
+		return jsonDB.RemoteActor{}, err
+	}
+	if fetched.Id != actorId {
+		return jsonDB.RemoteActor{}, fmt.Errorf("actor fetched from %s has id %s", actorId, fetched.Id)
+	}
+
+	actor := jsonDB.RemoteActor{
+		Id:           fetched.Id,
+		Handle:       fetched.PreferredUsername,
+		Inbox:        fetched.Inbox,
+		PublicKeyId:  fetched.PublicKey.Id,
+		PublicKeyPEM: fetched.PublicKey.PublicKeyPem,
+		FetchedAt:    time.Now().UTC(),
+	}
+	if fetched.Endpoints != nil {
+		actor.SharedInbox = fetched.Endpoints.SharedInbox
+	}
+	return actor, nil
+}
+
+// verifyInboxRequest checks the HTTP signature of a delivery and returns
+// the actor that signed it. The key is looked up through the actor named
+// by the keyId, from the cache when it has a recent copy. If the cached key
+// doesn't verify the actor is fetched again in case it changed its key.
+// Fetched actors are only cached once they have verified a signature, so
+// unsigned junk can't fill the cache
+func (cfg *apiConfig) verifyInboxRequest(r *http.Request, body []byte) (jsonDB.RemoteActor, error) {
+	keyId, err := activitypub.SignatureKeyId(r)
+	if err != nil {
+		return jsonDB.RemoteActor{}, err
+	}
+	actorId, _, _ := strings.Cut(keyId, "#")
+
+	verify := func(actor jsonDB.RemoteActor) error {
+		if actor.PublicKeyId != keyId {
+			return fmt.Errorf("%w: unknown key %s", activitypub.ErrInvalidSignature, keyId)
+		}
+		key, err := activitypub.ParsePublicKey(actor.PublicKeyPEM)
+		if err != nil {
+			return err
+		}
+		return activitypub.Verify(r, body, key, signatureTolerance, time.Now())
+	}
+
+	cached, err := cfg.DB.GetRemoteActor(actorId)
+	if err == nil && time.Since(cached.FetchedAt) < remoteActorMaxAge {
+		err = verify(cached)
+		if err == nil {
+			return cached, nil
+		}
+		if !errors.Is(err, activitypub.ErrInvalidSignature) || time.Since(cached.FetchedAt) < time.Minute {
+			return jsonDB.RemoteActor{}, err
+		}
+	}
+
+	actor, err := cfg.fetchRemoteActor(r.Context(), actorId)
+	if err != nil {
+		return jsonDB.RemoteActor{}, err
+	}
+	err = verify(actor)
+	if err != nil {
+		return jsonDB.RemoteActor{}, err
+	}
+
+	err = cfg.DB.SaveRemoteActor(actor)
+	if err != nil {
+		return jsonDB.RemoteActor{}, err
+	}
+	return actor, nil
+}
+
+// handlerInbox receives activities from other servers, both at the inbox of
+// each user and at the shared inbox. Follow, Like and the Undo of either
+// are handled; other activities are accepted and ignored
+func (cfg *apiConfig) handlerInbox(w http.ResponseWriter, r *http.Request) {
+	if !activitypub.IsActivityPubMediaType(r.Header.Get("Content-Type")) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "expected an activitypub document")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboxBodySize))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "activity too large")
+		return
+	}
+
+	activity := activitypub.Activity{}
+	err = json.Unmarshal(body, &activity)
+	if err != nil || activity.Type == "" || activity.Actor == "" {
+		respondWithError(w, http.StatusBadRequest, "invalid activity")
+		return
+	}
+
+	actor, err := cfg.verifyInboxRequest(r, body)
+	if errors.Is(err, errActorFetchLimited) {
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("rejected inbox delivery from %s: %s", activity.Actor, err)
+		respondWithError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	if activity.Actor != actor.Id {
+		respondWithError(w, http.StatusUnauthorized, "actor does not match signature")
+		return
+	}
+
+	switch activity.Type {
+	case activitypub.TypeFollow:
+		cfg.handleFollowActivity(w, activity, actor)
+	case activitypub.TypeLike:
+		cfg.handleLikeActivity(w, activity, actor)
+	case activitypub.TypeUndo:
+		cfg.handleUndoActivity(w, activity, actor)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (cfg *apiConfig) handleFollowActivity(w http.ResponseWriter, activity activitypub.Activity, actor jsonDB.RemoteActor) {
+	userId, ok := cfg.localId(activity.ObjectId(), "users")
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	inbox := actor.SharedInbox
+	if inbox == "" {
+		inbox = actor.Inbox
+	}
+	_, err := cfg.DB.AddRemoteFollower(jsonDB.RemoteFollower{
+		UserId:           userId,
+		ActorId:          actor.Id,
+		Inbox:            inbox,
+		FollowActivityId: activity.Id,
+	})
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to save follower")
+		return
+	}
+
+	// Follows are accepted automatically. The Accept is queued and sent in
+	// the background, since some servers only expect it after the Follow
+	// has been answered
+	accept := activitypub.Activity{
+		Context: activitypub.ActivityStreamsContext,
+		Id:      fmt.Sprintf("%s#accepts/follows/%d", cfg.actorURL(userId), time.Now().UnixNano()),
+		Type:    activitypub.TypeAccept,
+		Actor:   cfg.actorURL(userId),
+		Object:  activity,
+	}
+	err = cfg.deliverActivity(userId, accept, []string{actor.Inbox})
+	if err != nil {
+		log.Printf("failed to accept follow from %s: %s", actor.Id, err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handleLikeActivity(w http.ResponseWriter, activity activitypub.Activity, actor jsonDB.RemoteActor) {
+	chirpId, ok := cfg.localId(activity.ObjectId(), "chirps")
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+
+	_, err := cfg.DB.AddRemoteLike(chirpId, actor.Id, activity.Id)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to save like")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleUndoActivity takes back a follow or like. Most servers embed the
+// activity being undone, but some only send its id, in which case it is
+// looked up among the follows and likes of the actor
+func (cfg *apiConfig) handleUndoActivity(w http.ResponseWriter, activity activitypub.Activity, actor jsonDB.RemoteActor) {
+	undoneId := activity.ObjectId()
+	target := activity.ObjectField("object")
+
+	var err error
+	switch activity.ObjectType() {
+	case activitypub.TypeFollow:
+		userId, _ := cfg.localId(target, "users")
+		err = cfg.DB.RemoveRemoteFollower(actor.Id, userId, undoneId)
+	case activitypub.TypeLike:
+		chirpId, _ := cfg.localId(target, "chirps")
+		err = cfg.DB.RemoveRemoteLike(actor.Id, chirpId, undoneId)
+	case "":
+		err = errors.Join(
+			cfg.DB.RemoveRemoteFollower(actor.Id, 0, undoneId),
+			cfg.DB.RemoveRemoteLike(actor.Id, 0, undoneId),
+		)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to undo activity")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// followerInboxes returns the inboxes to deliver a user's activities to,
+// with each shared inbox listed once
+func (cfg *apiConfig) followerInboxes(userId int) ([]string, error) {
+	followers, err := cfg.DB.GetRemoteFollowers(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	inboxes := []string{}
+	for _, follower := range followers {
+		if !seen[follower.Inbox] {
+			seen[follower.Inbox] = true
+			inboxes = append(inboxes, follower.Inbox)
+		}
+	}
+	return inboxes, nil
+}
+
+// deliverActivity queues an activity signed by a user for each inbox and
+// wakes the deliverer, which sends it in the background
+func (cfg *apiConfig) deliverActivity(userId int, activity interface{}, inboxes []string) error {
+	dat, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := cfg.DB.EnqueueActivityDeliveries(userId, dat, inboxes)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) > 0 {
+		cfg.activityDeliverer.Wake()
+	}
+	return nil
+}
+
+// activitySigner returns the key a user's deliveries are signed with
+func (cfg *apiConfig) activitySigner(userId int) (string, *rsa.PrivateKey, error) {
+	key, err := cfg.actorKey(userId)
+	if err != nil {
+		return "", nil, err
+	}
+	privateKey, err := activitypub.ParsePrivateKey(key.PrivateKeyPEM)
+	if err != nil {
+		return "", nil, err
+	}
+	return cfg.actorKeyId(userId), privateKey, nil
+}
+
+// federateChirpCreated sends a new chirp to the remote followers of its
+// author
+func (cfg *apiConfig) federateChirpCreated(e events.ChirpCreated) error {
+	chirp := e.Chirp
+	if chirp.AuthorId == 0 || !chirp.Visible() {
+		return nil
+	}
+	inboxes, err := cfg.followerInboxes(chirp.AuthorId)
+	if err != nil || len(inboxes) == 0 {
+		return err
+	}
+
+	attachments, err := cfg.attachmentResponses([]jsonDB.Chirp{chirp})
+	if err != nil {
+		return err
+	}
+
+	return cfg.deliverActivity(chirp.AuthorId, newCreate(cfg.newNote(chirp, attachments)), inboxes)
+}
+
+// federateChirpDeleted tells the remote followers of a chirp's author that
+// it is gone
+func (cfg *apiConfig) federateChirpDeleted(e events.ChirpDeleted) error {
+	chirp := e.Chirp
+	if chirp.AuthorId == 0 {
+		return nil
+	}
+	inboxes, err := cfg.followerInboxes(chirp.AuthorId)
+	if err != nil || len(inboxes) == 0 {
+		return err
+	}
+
+	noteId := cfg.noteURL(chirp.Id)
+	return cfg.deliverActivity(chirp.AuthorId, activitypub.Activity{
+		Context: activitypub.ActivityStreamsContext,
+		Id:      noteId + "#delete",
+		Type:    activitypub.TypeDelete,
+		Actor:   cfg.actorURL(chirp.AuthorId),
+		Object:  activitypub.Note{Id: noteId, Type: activitypub.TypeTombstone},
+		To:      []string{activitypub.Public},
+	}, inboxes)
+}
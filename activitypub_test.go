@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/activitypub"
+)
+
+func TestWebFinger(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "alice@example.com", "alice")
+
+	tests := []struct {
+		resource string
+		want     int
+	}{
+		{"acct:alice@chirpy.example", http.StatusOK},
+		{"acct:ALICE@CHIRPY.EXAMPLE", http.StatusOK},
+		{cfg.actorURL(user.Id), http.StatusOK},
+		{"acct:alice@elsewhere.example", http.StatusNotFound},
+		{"acct:bob@chirpy.example", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/.well-known/webfinger?resource="+url.QueryEscape(tt.resource), nil)
+			rec := httptest.NewRecorder()
+			cfg.handlerWebFinger(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			finger := activitypub.WebFinger{}
+			err := json.Unmarshal(rec.Body.Bytes(), &finger)
+			if err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			if finger.Subject != "acct:alice@chirpy.example" {
+				t.Errorf("subject = %q", finger.Subject)
+			}
+			if len(finger.Links) == 0 || finger.Links[0].Href != cfg.actorURL(user.Id) {
+				t.Errorf("links = %+v, want the actor first", finger.Links)
+			}
+		})
+	}
+}
+
+// remoteActorServer serves the actor document of a remote user whose key is
+// returned, and counts how often it was fetched
+func remoteActorServer(t *testing.T) (*httptest.Server, *rsa.PrivateKey, *int) {
+	t.Helper()
+	privatePEM, publicPEM, err := activitypub.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	key, err := activitypub.ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %s", err)
+	}
+
+	fetches := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		id := srv.URL + "/users/bob"
+		json.NewEncoder(w).Encode(activitypub.Actor{
+			Id:                id,
+			Type:              activitypub.TypePerson,
+			PreferredUsername: "bob",
+			Inbox:             id + "/inbox",
+			PublicKey: activitypub.PublicKey{
+				Id:           id + "#main-key",
+				Owner:        id,
+				PublicKeyPem: publicPEM,
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, key, &fetches
+}
+
+func inboxRequest(t *testing.T, activity activitypub.Activity, keyId string, key *rsa.PrivateKey, date time.Time) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(activity)
+	if err != nil {
+		t.Fatalf("encoding activity: %s", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/ap/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	err = activitypub.Sign(req, body, keyId, key, date)
+	if err != nil {
+		t.Fatalf("Sign: %s", err)
+	}
+	return req
+}
+
+func TestInboxFollow(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "alice@example.com", "alice")
+	remote, key, fetches := remoteActorServer(t)
+	actorId := remote.URL + "/users/bob"
+	follow := activitypub.Activity{
+		Id:     actorId + "/follows/1",
+		Type:   activitypub.TypeFollow,
+		Actor:  actorId,
+		Object: cfg.actorURL(user.Id),
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerInbox(rec, inboxRequest(t, follow, actorId+"#main-key", key, time.Now()))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	followers, err := cfg.DB.GetRemoteFollowers(user.Id)
+	if err != nil || len(followers) != 1 || followers[0].ActorId != actorId {
+		t.Fatalf("followers = %+v, %v, want %s", followers, err, actorId)
+	}
+	if _, err := cfg.DB.GetRemoteActor(actorId); err != nil {
+		t.Errorf("verified actor wasn't cached: %s", err)
+	}
+	queued, _ := cfg.DB.GetDueActivityDeliveries(time.Now(), 10)
+	if len(queued) != 1 || queued[0].Inbox != actorId+"/inbox" {
+		t.Errorf("queued deliveries = %+v, want the Accept for %s", queued, actorId)
+	}
+
+	// a second delivery is verified with the cached actor
+	rec = httptest.NewRecorder()
+	cfg.handlerInbox(rec, inboxRequest(t, follow, actorId+"#main-key", key, time.Now()))
+	if rec.Code != http.StatusAccepted || *fetches != 1 {
+		t.Errorf("second delivery: status %d after %d fetches, want %d after 1", rec.Code, *fetches, http.StatusAccepted)
+	}
+}
+
+func TestInboxRejects(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "alice@example.com", "alice")
+	remote, key, _ := remoteActorServer(t)
+	actorId := remote.URL + "/users/bob"
+	otherPrivate, _, _ := activitypub.GenerateKey()
+	otherKey, _ := activitypub.ParsePrivateKey(otherPrivate)
+
+	follow := func(actor string) activitypub.Activity {
+		return activitypub.Activity{
+			Id:     actor + "/follows/1",
+			Type:   activitypub.TypeFollow,
+			Actor:  actor,
+			Object: cfg.actorURL(user.Id),
+		}
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"wrong key", inboxRequest(t, follow(actorId), actorId+"#main-key", otherKey, time.Now())},
+		{"expired date", inboxRequest(t, follow(actorId), actorId+"#main-key", key, time.Now().Add(-time.Hour))},
+		{"actor does not match keyId", inboxRequest(t, follow("https://other.example/users/eve"), actorId+"#main-key", key, time.Now())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cfg.handlerInbox(rec, tt.req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+
+		// only the last request has a valid signature
+		if _, err := cfg.DB.GetRemoteActor(actorId); err == nil && tt.name != "actor does not match keyId" {
+			t.Errorf("%s: actor was cached without a verified signature", tt.name)
+		}
+	}
+
+	followers, _ := cfg.DB.GetRemoteFollowers(user.Id)
+	if len(followers) != 0 {
+		t.Errorf("rejected deliveries added followers: %+v", followers)
+	}
+}
+
+func TestInboxLimitsActorFetches(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "alice@example.com", "alice")
+	remote, _, fetches := remoteActorServer(t)
+	otherPrivate, _, _ := activitypub.GenerateKey()
+	otherKey, _ := activitypub.ParsePrivateKey(otherPrivate)
+
+	limited := 0
+	for i := 0; i < actorFetchesPerHost+5; i++ {
+		actorId := remote.URL + "/users/bob"
+		req := inboxRequest(t, activitypub.Activity{
+			Id:     actorId + "/follows/1",
+			Type:   activitypub.TypeFollow,
+			Actor:  actorId,
+			Object: cfg.actorURL(user.Id),
+		}, actorId+"#main-key", otherKey, time.Now())
+		rec := httptest.NewRecorder()
+		cfg.handlerInbox(rec, req)
+		if rec.Code == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	if *fetches != actorFetchesPerHost || limited != 5 {
+		t.Errorf("%d fetches and %d limited requests, want %d and 5", *fetches, limited, actorFetchesPerHost)
+	}
+}
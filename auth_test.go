@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+)
+
+func mustCreateToken(t *testing.T, cfg *apiConfig, userId int, tokenType auth.TokenType) string {
+	t.Helper()
+	token, err := auth.CreateJWT(userId, []byte(cfg.jwtSecret), time.Hour, tokenType)
+	if err != nil {
+		t.Fatalf("CreateJWT: %s", err)
+	}
+	return token
+}
+
+func sendWithToken(cfg *apiConfig, handler http.HandlerFunc, token string) int {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec.Code
+}
+
+func TestRefreshAndRevoke(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "a@example.com", "alice")
+	access := mustCreateToken(t, cfg, user.Id, auth.TokenTypeAccess)
+	refresh := mustCreateToken(t, cfg, user.Id, auth.TokenTypeRefresh)
+
+	if code := sendWithToken(cfg, cfg.handlerRefresh, access); code != http.StatusUnauthorized {
+		t.Errorf("refresh with access token: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := sendWithToken(cfg, cfg.handlerRefresh, refresh); code != http.StatusOK {
+		t.Fatalf("refresh: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := sendWithToken(cfg, cfg.handlerRevoke, refresh); code != http.StatusOK {
+		t.Fatalf("revoke: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := sendWithToken(cfg, cfg.handlerRefresh, refresh); code != http.StatusUnauthorized {
+		t.Errorf("refresh after revoke: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestDeletionRequestEndsSessions(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "a@example.com", "alice")
+	access := mustCreateToken(t, cfg, user.Id, auth.TokenTypeAccess)
+	refresh := mustCreateToken(t, cfg, user.Id, auth.TokenTypeRefresh)
+
+	_, err := cfg.DB.ScheduleUserDeletion(user.Id, time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatalf("ScheduleUserDeletion: %s", err)
+	}
+	if code := sendWithToken(cfg, cfg.handlerRefresh, refresh); code != http.StatusUnauthorized {
+		t.Errorf("refresh while deletion is pending: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// cancelling the deletion doesn't bring the old sessions back
+	_, err = cfg.DB.CancelUserDeletion(user.Id)
+	if err != nil {
+		t.Fatalf("CancelUserDeletion: %s", err)
+	}
+	if code := sendWithToken(cfg, cfg.handlerRefresh, refresh); code != http.StatusUnauthorized {
+		t.Errorf("refresh after cancelling: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	_, err = cfg.userForToken(access)
+	if !errors.Is(err, errSessionRevoked) {
+		t.Errorf("userForToken after cancelling: err = %v, want %v", err, errSessionRevoked)
+	}
+}
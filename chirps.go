@@ -4,39 +4,290 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"sort"
 	"strconv"
+	"time"
 
-	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/chirptext"
+	"github.com/emilmalmsten/chirpy/internal/entities"
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
 	"github.com/emilmalmsten/chirpy/internal/jsonDB"
 	"github.com/go-chi/chi"
 )
 
-func (cfg apiConfig) handlerPostChirp(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Body string `json:"body"`
+type authorSummary struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+type chirpResponse struct {
+	Id            int                  `json:"id"`
+	Body          string               `json:"body"`
+	AuthorId      int                  `json:"author_id"`
+	Author        *authorSummary       `json:"author,omitempty"`
+	InReplyTo     int                  `json:"in_reply_to,omitempty"`
+	ReplyCount    int                  `json:"reply_count"`
+	LikeCount     int                  `json:"like_count"`
+	RechirpCount  int                  `json:"rechirp_count"`
+	LikedByMe     bool                 `json:"liked_by_me"`
+	RechirpedByMe bool                 `json:"rechirped_by_me"`
+	Deleted       bool                 `json:"deleted,omitempty"`
+	Hidden        bool                 `json:"hidden,omitempty"`
+	Entities      entities.Entities    `json:"entities"`
+	Edited        bool                 `json:"edited"`
+	Media         []attachmentResponse `json:"media"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
+
+func newChirpResponse(chirp jsonDB.Chirp) chirpResponse {
+	return chirpResponse{
+		Id:           chirp.Id,
+		Body:         chirp.Body,
+		AuthorId:     chirp.AuthorId,
+		InReplyTo:    chirp.InReplyTo,
+		ReplyCount:   chirp.ReplyCount,
+		LikeCount:    chirp.LikeCount,
+		RechirpCount: chirp.RechirpCount,
+		Deleted:      chirp.Deleted,
+		Hidden:       chirp.Hidden,
+		Entities:     chirp.Entities,
+		Edited:       chirp.Edited,
+		Media:        []attachmentResponse{},
+		CreatedAt:    chirp.CreatedAt,
+		UpdatedAt:    chirp.UpdatedAt,
 	}
+}
 
-	token, err := auth.GetBearerToken(r.Header)
+// codeProhibitedLanguage is the validation error code of chirps rejected by
+// the profanity filter
+const codeProhibitedLanguage = "prohibited_language"
+
+// cleanChirpBody normalizes a chirp body, checks that it is valid and at
+// most maxLength characters, and filters profanity out of it. It also
+// returns the words that flag the chirp for review. Rejected bodies return a
+// *chirptext.ValidationError
+func (cfg *apiConfig) cleanChirpBody(body string, maxLength int) (string, []string, error) {
+	body, err := chirptext.Validate(body, maxLength)
 	if err != nil {
-		if errors.Is(err, jsonDB.ErrAlreadyExists) {
-			respondWithError(w, http.StatusInternalServerError, "auth header missing")
-			return
+		return "", nil, err
+	}
+
+	result := cfg.profanityFilter.Check(body)
+	if result.Rejected() {
+		return "", nil, &chirptext.ValidationError{
+			Code:      codeProhibitedLanguage,
+			Message:   "chirp contains prohibited language",
+			Length:    chirptext.Length(body),
+			MaxLength: maxLength,
 		}
-		respondWithError(w, http.StatusInternalServerError, "malformed auth header")
+	}
+
+	return result.Text, result.FlaggedWords(), nil
+}
+
+// respondWithChirpError responds to a body rejected by cleanChirpBody,
+// including the computed length so clients can show what went wrong
+func respondWithChirpError(w http.ResponseWriter, err error) {
+	var validationErr *chirptext.ValidationError
+	if !errors.As(err, &validationErr) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	type errorResponse struct {
+		Error     string `json:"error"`
+		Code      string `json:"code"`
+		Length    int    `json:"length"`
+		MaxLength int    `json:"max_length"`
+	}
+
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error:     validationErr.Message,
+		Code:      validationErr.Code,
+		Length:    validationErr.Length,
+		MaxLength: validationErr.MaxLength,
+	})
+}
+
+// parseTimeParam reads an RFC 3339 timestamp from a query parameter. A
+// missing parameter returns the zero time
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+
+	return t, nil
+}
+
+// publicChirpResponse converts a chirp into the response anyone would see,
+// for pushing it to other systems and clients outside of a request
+func (cfg *apiConfig) publicChirpResponse(chirp jsonDB.Chirp) chirpResponse {
+	response := newChirpResponse(chirp)
+	attachments, err := cfg.attachmentResponses([]jsonDB.Chirp{chirp})
+	if err != nil {
+		log.Printf("Error fetching media of chirp %d: %s", chirp.Id, err)
+	}
+	for _, id := range chirp.MediaIds {
+		if attachment, ok := attachments[id]; ok {
+			response.Media = append(response.Media, attachment)
+		}
+	}
+	return response
+}
+
+// chirpResponses converts chirps into API responses. Attached media is
+// looked up, engagement flags are filled in for authenticated viewers, and
+// when the request asks for ?expand=author the authors are looked up in a
+// single batch and embedded
+func (cfg *apiConfig) chirpResponses(r *http.Request, chirps []jsonDB.Chirp) ([]chirpResponse, error) {
+	return cfg.chirpResponsesFor(cfg.viewerID(r), r.URL.Query().Get("expand") == "author", chirps)
+}
+
+// chirpResponsesFor is chirpResponses for a viewer that is already known,
+// where 0 is an anonymous viewer
+func (cfg *apiConfig) chirpResponsesFor(viewerId int, expandAuthor bool, chirps []jsonDB.Chirp) ([]chirpResponse, error) {
+	responses := make([]chirpResponse, 0, len(chirps))
+	chirpIds := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		responses = append(responses, newChirpResponse(chirp))
+		chirpIds = append(chirpIds, chirp.Id)
+	}
+
+	attachments, err := cfg.attachmentResponses(chirps)
+	if err != nil {
+		return nil, err
+	}
+	for i, chirp := range chirps {
+		for _, id := range chirp.MediaIds {
+			if attachment, ok := attachments[id]; ok {
+				responses[i].Media = append(responses[i].Media, attachment)
+			}
+		}
+	}
+
+	if viewerId != 0 {
+		liked, rechirped, err := cfg.DB.GetUserEngagements(viewerId, chirpIds)
+		if err != nil {
+			return nil, err
+		}
+		for i := range responses {
+			responses[i].LikedByMe = liked[responses[i].Id]
+			responses[i].RechirpedByMe = rechirped[responses[i].Id]
+		}
+	}
+
+	if !expandAuthor {
+		return responses, nil
+	}
+
+	authorIds := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		authorIds = append(authorIds, chirp.AuthorId)
+	}
+
+	authors, err := cfg.DB.GetUsers(authorIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range responses {
+		author, ok := authors[responses[i].AuthorId]
+		if !ok {
+			continue
+		}
+		responses[i].Author = &authorSummary{
+			Id:          author.Id,
+			Handle:      author.Handle,
+			DisplayName: author.DisplayName,
+			AvatarURL:   author.AvatarURL,
+		}
+	}
+
+	return responses, nil
+}
+
+var errTooManyAttachments = errors.New("too many attachments")
+var errRateLimited = errors.New("rate limit exceeded")
+
+// createChirp cleans up and saves a chirp by a user within the limits of
+// their entitlements. It is shared by every API that posts chirps. allow is
+// called once the chirp is valid, so that rejected chirps don't count
+// against the rate limit the caller checks in it. Besides a
+// *chirptext.ValidationError for a rejected body and the error from allow,
+// the errors caused by the request are errTooManyAttachments,
+// jsonDB.ErrDoesNotExists for a missing parent and jsonDB.ErrInvalidMedia
+func (cfg *apiConfig) createChirp(userId int, ent entitlements.Entitlements, body string, inReplyTo int, mediaIds []string, allow func() error) (jsonDB.Chirp, error) {
+	cleanChirp, flaggedWords, err := cfg.cleanChirpBody(body, ent.MaxChirpLength)
+	if err != nil {
+		return jsonDB.Chirp{}, err
+	}
+
+	if len(mediaIds) > ent.MaxAttachmentsPerChirp {
+		return jsonDB.Chirp{}, errTooManyAttachments
+	}
+
+	ents, err := cfg.extractEntities(cleanChirp)
+	if err != nil {
+		return jsonDB.Chirp{}, fmt.Errorf("failed to resolve mentions: %w", err)
+	}
+
+	err = allow()
+	if err != nil {
+		return jsonDB.Chirp{}, err
+	}
+
+	return cfg.DB.CreateChirp(jsonDB.NewChirp{
+		Body:         cleanChirp,
+		AuthorId:     userId,
+		InReplyTo:    inReplyTo,
+		Entities:     ents,
+		MediaIds:     mediaIds,
+		FlaggedWords: flaggedWords,
+	})
+}
+
+// deleteChirp deletes a chirp by its author along with its media
+func (cfg *apiConfig) deleteChirp(chirpId, userId int) error {
+	chirp, err := cfg.DB.GetChirp(chirpId)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.DeleteChirp(chirpId, userId)
+	if err != nil {
+		return err
+	}
+
+	cfg.deleteMediaBlobs(chirp.MediaIds)
+	return nil
+}
+
+func (cfg apiConfig) handlerPostChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body      string   `json:"body"`
+		InReplyTo int      `json:"in_reply_to"`
+		MediaIds  []string `json:"media_ids"`
+	}
+
+	userIDInt, err := cfg.authenticateUser(r)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(userId)
+	ent, err := cfg.entitlementsFor(userIDInt)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't parse user ID")
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
 		return
 	}
 
@@ -48,38 +299,53 @@ func (cfg apiConfig) handlerPostChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const maxChirpLength = 140
-
-	if len(params.Body) > maxChirpLength {
-		respondWithError(w, http.StatusBadRequest, "chirp is too long")
+	chirp, err := cfg.createChirp(userIDInt, ent, params.Body, params.InReplyTo, params.MediaIds, func() error {
+		if !cfg.checkRateLimit(w, "chirp", userIDInt, ent.ChirpsPerHour) {
+			return errRateLimited
+		}
+		return nil
+	})
+	if err != nil {
+		var validationErr *chirptext.ValidationError
+		switch {
+		case errors.Is(err, errRateLimited):
+			// checkRateLimit has responded
+		case errors.As(err, &validationErr):
+			respondWithChirpError(w, err)
+		case errors.Is(err, errTooManyAttachments):
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("a chirp can have at most %d attachments", ent.MaxAttachmentsPerChirp))
+		case errors.Is(err, jsonDB.ErrDoesNotExists):
+			respondWithError(w, http.StatusBadRequest, "chirp being replied to does not exist")
+		case errors.Is(err, jsonDB.ErrInvalidMedia):
+			respondWithError(w, http.StatusBadRequest, "media must be your own unattached uploads")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "failed to create Chirp")
+		}
 		return
 	}
 
-	cleanChirp := filterProfanity(params.Body)
-
-	chirp, err := cfg.DB.CreateChirp(cleanChirp, userIDInt)
+	responses, err := cfg.chirpResponses(r, []jsonDB.Chirp{chirp})
 	if err != nil {
-		fmt.Printf("err with create chirp: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "failed to create Chirp")
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp media")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, chirp)
+	respondWithJSON(w, http.StatusCreated, responses[0])
 }
 
+// handlerGetChirps lists chirps. Without a limit or cursor every chirp is
+// returned as a plain list, as before pagination was added. Paged requests
+// get the page along with the cursor of the next one
 func (cfg apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	dbChirps, err := cfg.DB.GetChirps()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
-		return
-	}
-
-	authorId := -1
+	var err error
+	authorId := 0
 	authorIdString := r.URL.Query().Get("author_id")
 	if authorIdString != "" {
 		authorId, err = strconv.Atoi(authorIdString)
-		if err != nil {
+		// 0 would mean no author filter
+		if err != nil || authorId < 1 {
 			respondWithError(w, http.StatusBadRequest, "invalid author id")
+			return
 		}
 	}
 
@@ -89,22 +355,78 @@ func (cfg apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 		sortBy = "desc"
 	}
 
-	chirps := []jsonDB.Chirp{}
-	for _, chirp := range dbChirps {
-		if authorId != -1 && chirp.AuthorId != authorId {
-			continue
-		}
-		chirps = append(chirps, chirp)
+	since, err := parseTimeParam(r, "since")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	until, err := parseTimeParam(r, "until")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	sort.Slice(chirps, func(i, j int) bool {
-		if sortBy == "desc" {
-			return chirps[i].Id > chirps[j].Id
+	paged := r.URL.Query().Has("limit") || r.URL.Query().Has("cursor")
+	limit := 0
+	if paged {
+		limit, err = parsePageLimit(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		return chirps[i].Id < chirps[j].Id
-	})
+	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	afterId, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// chirp IDs follow creation order, so paging by ID also sorts by time.
+	// One extra chirp is fetched to find out if there is another page
+	query := jsonDB.ChirpQuery{
+		AuthorId:   authorId,
+		Since:      since,
+		Until:      until,
+		AfterId:    afterId,
+		Descending: sortBy == "desc",
+	}
+	if paged {
+		query.Limit = limit + 1
+	}
+	chirps, err := cfg.DB.GetChirpsPage(query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
+		return
+	}
+
+	nextCursor := ""
+	if paged && len(chirps) > limit {
+		chirps = chirps[:limit]
+		nextCursor = encodeCursor(chirps[len(chirps)-1].Id)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	responses, err := cfg.chirpResponses(r, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp authors")
+		return
+	}
+
+	if !paged {
+		respondWithJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	type response struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Chirps:     responses,
+		NextCursor: nextCursor,
+	})
 }
 
 func (cfg apiConfig) handlerGetChirpById(w http.ResponseWriter, r *http.Request) {
@@ -117,35 +439,24 @@ func (cfg apiConfig) handlerGetChirpById(w http.ResponseWriter, r *http.Request)
 	}
 
 	chirp, err := cfg.DB.GetChirp(chirpID)
-	if err != nil {
+	if err != nil || !cfg.canViewChirp(r, chirp) {
 		respondWithError(w, http.StatusNotFound, "chirp not found")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirp)
-
-}
-
-func (cfg apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	responses, err := cfg.chirpResponses(r, []jsonDB.Chirp{chirp})
 	if err != nil {
-		if errors.Is(err, jsonDB.ErrAlreadyExists) {
-			respondWithError(w, http.StatusInternalServerError, "auth header missing")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "malformed auth header")
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp author")
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
-		return
-	}
+	respondWithJSON(w, http.StatusOK, responses[0])
+}
 
-	userIDInt, err := strconv.Atoi(userId)
+func (cfg apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	userIDInt, err := cfg.authenticateUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't parse user ID")
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
 		return
 	}
 
@@ -156,7 +467,7 @@ func (cfg apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(chirpIDInt, userIDInt)
+	err = cfg.deleteChirp(chirpIDInt, userIDInt)
 	if err != nil {
 		if errors.Is(err, jsonDB.ErrDoesNotExists) {
 			respondWithError(w, http.StatusNotFound, "chirp not found")
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func postChirp(t *testing.T, cfg *apiConfig, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerPostChirp(rec, req)
+	return rec
+}
+
+func TestRejectedChirpsDontCountAgainstRateLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "a@example.com", "alice")
+	token := mustCreateToken(t, cfg, user.Id, auth.TokenTypeAccess)
+	limit := entitlements.Free().ChirpsPerHour
+
+	tooLong := `{"body":"` + strings.Repeat("a", entitlements.Free().MaxChirpLength+1) + `"}`
+	for i := 0; i < limit+1; i++ {
+		if rec := postChirp(t, cfg, token, tooLong); rec.Code != http.StatusBadRequest {
+			t.Fatalf("invalid chirp %d: status = %d, want %d", i, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	for i := 0; i < limit; i++ {
+		if rec := postChirp(t, cfg, token, `{"body":"hello"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("chirp %d: status = %d, want %d: %s", i, rec.Code, http.StatusCreated, rec.Body)
+		}
+	}
+	rec := postChirp(t, cfg, token, `{"body":"hello"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("chirp over the limit: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("rate limited response has no Retry-After header")
+	}
+}
+
+func getChirps(t *testing.T, cfg *apiConfig, query string, page interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps?"+query, nil)
+	rec := httptest.NewRecorder()
+	cfg.handlerGetChirps(rec, req)
+	if rec.Code == http.StatusOK {
+		err := json.Unmarshal(rec.Body.Bytes(), page)
+		if err != nil {
+			t.Fatalf("decoding %s: %s", rec.Body, err)
+		}
+	}
+	return rec
+}
+
+func chirpIds(chirps []chirpResponse) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
+
+func TestGetChirpsPages(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+	bob := mustCreateUser(t, cfg, "b@example.com", "bob")
+	for i := 0; i < 25; i++ {
+		author := alice
+		if i%5 == 0 {
+			author = bob
+		}
+		_, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: author.Id})
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+	}
+
+	// without pagination parameters every chirp comes back as a list
+	all := []chirpResponse{}
+	rec := getChirps(t, cfg, "", &all)
+	if rec.Code != http.StatusOK || len(all) != 25 {
+		t.Fatalf("status = %d, got %d chirps, want all 25", rec.Code, len(all))
+	}
+	if rec.Header().Get("Link") != "" {
+		t.Error("unpaged list has a next page link")
+	}
+
+	type page struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	seen := []int{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging doesn't end")
+		}
+		p := page{}
+		query := "limit=10&sort=desc"
+		if cursor != "" {
+			query += "&cursor=" + cursor
+		}
+		rec := getChirps(t, cfg, query, &p)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if rec.Header().Get("X-Next-Cursor") != p.NextCursor {
+			t.Errorf("X-Next-Cursor = %q, body has %q", rec.Header().Get("X-Next-Cursor"), p.NextCursor)
+		}
+		seen = append(seen, chirpIds(p.Chirps)...)
+		if p.NextCursor == "" {
+			break
+		}
+		cursor = p.NextCursor
+	}
+	if len(seen) != 25 || seen[0] != 25 || seen[24] != 1 {
+		t.Errorf("paged through %v, want 25 down to 1", seen)
+	}
+
+	p := page{}
+	getChirps(t, cfg, "limit=100&author_id="+strconv.Itoa(bob.Id), &p)
+	if len(p.Chirps) != 5 {
+		t.Errorf("got %d chirps by bob, want 5", len(p.Chirps))
+	}
+	for _, query := range []string{"author_id=0", "author_id=-1", "cursor=nope", "limit=0"} {
+		if rec := getChirps(t, cfg, query, &p); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
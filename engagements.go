@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement(w, r, jsonDB.EngagementLike, true)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement(w, r, jsonDB.EngagementLike, false)
+}
+
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement(w, r, jsonDB.EngagementRechirp, true)
+}
+
+func (cfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement(w, r, jsonDB.EngagementRechirp, false)
+}
+
+func (cfg *apiConfig) handlerGetChirpLikes(w http.ResponseWriter, r *http.Request) {
+	cfg.handleListEngagements(w, r, jsonDB.EngagementLike)
+}
+
+func (cfg *apiConfig) handlerGetRechirps(w http.ResponseWriter, r *http.Request) {
+	cfg.handleListEngagements(w, r, jsonDB.EngagementRechirp)
+}
+
+// handleEngagement adds or removes a like or rechirp of the chirp in the URL
+// for the authenticated user and responds with the updated chirp
+func (cfg *apiConfig) handleEngagement(w http.ResponseWriter, r *http.Request, kind jsonDB.EngagementKind, add bool) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	var chirp jsonDB.Chirp
+	if add {
+		chirp, err = cfg.DB.AddEngagement(kind, chirpID, userId)
+	} else {
+		chirp, err = cfg.DB.RemoveEngagement(kind, chirpID, userId)
+	}
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to update chirp")
+		return
+	}
+
+	responses, err := cfg.chirpResponses(r, []jsonDB.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, responses[0])
+}
+
+// handleListEngagements responds with the users who liked or rechirped the
+// chirp in the URL
+func (cfg *apiConfig) handleListEngagements(w http.ResponseWriter, r *http.Request, kind jsonDB.EngagementKind) {
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	engagements, err := cfg.DB.GetEngagements(kind, chirpID)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch users")
+		return
+	}
+
+	userIds := make([]int, 0, len(engagements))
+	for _, engagement := range engagements {
+		userIds = append(userIds, engagement.UserId)
+	}
+
+	cfg.respondWithUserList(w, userIds)
+}
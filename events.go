@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/events"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const eventPollInterval = 10 * time.Second
+
+// subscribeEvents connects the side effects of changes to the event bus.
+// Search and the real-time stream are updated synchronously so a client sees
+// its change reflected as soon as its request is answered; notifications,
+// webhooks and federation can wait for the background loop
+func (cfg *apiConfig) subscribeEvents(bus *events.Bus) {
+	events.Subscribe(bus, func(e events.ChirpCreated) error {
+		cfg.searchIndex.Add(e.Chirp.Id, e.Chirp.AuthorId, e.Chirp.Body)
+		cfg.streamChirpCreated(e.Chirp)
+		return nil
+	})
+	events.Subscribe(bus, func(e events.ChirpDeleted) error {
+		cfg.searchIndex.Remove(e.Chirp.Id)
+		cfg.streamChirpDeleted(e.Chirp)
+		return nil
+	})
+	events.Subscribe(bus, func(e events.ChirpEdited) error {
+		cfg.searchIndex.Add(e.Chirp.Id, e.Chirp.AuthorId, e.Chirp.Body)
+		return nil
+	})
+	events.Subscribe(bus, func(e events.ChirpHidden) error {
+		cfg.searchIndex.Remove(e.Chirp.Id)
+		cfg.streamChirpDeleted(e.Chirp)
+		return nil
+	})
+	events.Subscribe(bus, func(e events.ChirpRestored) error {
+		cfg.searchIndex.Add(e.Chirp.Id, e.Chirp.AuthorId, e.Chirp.Body)
+		return nil
+	})
+
+	events.SubscribeAsync(bus, func(e events.ChirpCreated) error {
+		cfg.notifyChirpCreated(e.Chirp)
+		return nil
+	})
+	events.SubscribeAsync(bus, func(e events.ChirpDeleted) error {
+		cfg.notifyChirpDeleted(e.Chirp.Id)
+		return nil
+	})
+	events.SubscribeAsync(bus, func(e events.ChirpEdited) error {
+		// users who were already notified about the chirp are skipped, so
+		// only newly mentioned users hear about the edit
+		cfg.notifyChirpCreated(e.Chirp)
+		return nil
+	})
+	events.SubscribeAsync(bus, func(e events.ChirpLiked) error {
+		cfg.notifyEngagement(jsonDB.NotificationLike, e.Engagement)
+		return nil
+	})
+	events.SubscribeAsync(bus, func(e events.ChirpRechirped) error {
+		cfg.notifyEngagement(jsonDB.NotificationRechirp, e.Engagement)
+		return nil
+	})
+	events.SubscribeAsync(bus, func(e events.UserFollowed) error {
+		cfg.notify(jsonDB.Notification{
+			UserId:  e.Follow.FolloweeId,
+			Type:    jsonDB.NotificationFollow,
+			ActorId: e.Follow.FollowerId,
+		})
+		return nil
+	})
+
+	events.SubscribeAsync(bus, cfg.webhookChirpCreated)
+	events.SubscribeAsync(bus, cfg.webhookChirpDeleted)
+	events.SubscribeAsync(bus, cfg.webhookUserCreated)
+	events.SubscribeAsync(bus, cfg.webhookUserUpgraded)
+	events.SubscribeAsync(bus, cfg.webhookSessionRevoked)
+
+	events.SubscribeAsync(bus, cfg.federateChirpCreated)
+	events.SubscribeAsync(bus, cfg.federateChirpDeleted)
+}
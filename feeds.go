@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/feed"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+const (
+	feedLength   = 50
+	feedMaxAge   = 5 * time.Minute
+	feedSiteName = "Chirpy"
+)
+
+// feedFormat is a way of rendering a feed, selected by the extension of the
+// feed URL
+type feedFormat struct {
+	extension   string
+	contentType string
+	render      func(feed.Feed) ([]byte, error)
+}
+
+var (
+	atomFeed = feedFormat{"atom", feed.AtomContentType, feed.Atom}
+	rssFeed  = feedFormat{"rss", feed.RSSContentType, feed.RSS}
+	jsonFeed = feedFormat{"json", feed.JSONContentType, feed.JSON}
+)
+
+// baseURL returns the scheme and host the API is reached at, for links that
+// have to be absolute. PUBLIC_URL is used when it is set, since the server
+// may be behind a proxy. Otherwise the URL comes from the request, so
+// responses that use it must not be stored by shared caches
+func (cfg *apiConfig) baseURL(r *http.Request) string {
+	if cfg.publicURL != "" {
+		return cfg.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// userFromIDOrHandleParam looks up the user named by the {handle} URL
+// parameter, which may also be a user ID, and writes an error response if
+// there is none. Numbers are taken as IDs
+func (cfg *apiConfig) userFromIDOrHandleParam(w http.ResponseWriter, r *http.Request) (jsonDB.User, bool) {
+	param := chi.URLParam(r, "handle")
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return cfg.userFromHandleParam(w, r)
+	}
+
+	user, err := cfg.DB.GetUser(id)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return jsonDB.User{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return jsonDB.User{}, false
+	}
+	return user, true
+}
+
+func feedAuthorName(user jsonDB.User) string {
+	switch {
+	case user.DisplayName != "":
+		return user.DisplayName
+	case user.Handle != "":
+		return "@" + user.Handle
+	default:
+		return fmt.Sprintf("user %d", user.Id)
+	}
+}
+
+func (cfg *apiConfig) feedAuthor(base string, user jsonDB.User) *feed.Author {
+	profile := strconv.Itoa(user.Id)
+	if user.Handle != "" {
+		profile = user.Handle
+	}
+	return &feed.Author{
+		Name:   feedAuthorName(user),
+		URL:    base + "/api/users/" + profile,
+		Avatar: user.AvatarURL,
+	}
+}
+
+// handlerUserFeed serves the latest chirps of a user as a feed
+func (cfg *apiConfig) handlerUserFeed(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := cfg.userFromIDOrHandleParam(w, r)
+		if !ok {
+			return
+		}
+
+		base := cfg.baseURL(r)
+		author := cfg.feedAuthor(base, user)
+		f := feed.Feed{
+			Title:       fmt.Sprintf("%s on %s", author.Name, feedSiteName),
+			Description: user.Bio,
+			HomeURL:     author.URL,
+			FeedURL:     base + r.URL.Path,
+			Author:      author,
+			Updated:     user.UpdatedAt,
+		}
+
+		cfg.serveFeed(w, r, format, f, user.Id)
+	}
+}
+
+// handlerGlobalFeed serves the latest public chirps of every user as a feed
+func (cfg *apiConfig) handlerGlobalFeed(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := cfg.baseURL(r)
+		f := feed.Feed{
+			Title:       feedSiteName,
+			Description: "The latest chirps on " + feedSiteName,
+			HomeURL:     base + "/api/chirps",
+			FeedURL:     base + r.URL.Path,
+		}
+
+		cfg.serveFeed(w, r, format, f, 0)
+	}
+}
+
+// serveFeed fills a feed with the latest chirps, of one author or of
+// everyone when authorId is 0, and serves it. The ETag changes whenever a
+// chirp is posted, edited or deleted, and Last-Modified is the time of the
+// latest change, so feed readers can poll with conditional requests
+func (cfg *apiConfig) serveFeed(w http.ResponseWriter, r *http.Request, format feedFormat, f feed.Feed, authorId int) {
+	chirps, err := cfg.DB.GetChirpsPage(jsonDB.ChirpQuery{
+		AuthorId:   authorId,
+		Descending: true,
+		Limit:      feedLength,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to retrieve chirps")
+		return
+	}
+
+	authorIds := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		authorIds = append(authorIds, chirp.AuthorId)
+	}
+	authors, err := cfg.DB.GetUsers(authorIds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to retrieve authors")
+		return
+	}
+
+	attachments, err := cfg.attachmentResponses(chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp media")
+		return
+	}
+
+	base := cfg.baseURL(r)
+	version := sha256.New()
+	fmt.Fprintf(version, "%s %s %d\n", format.extension, f.FeedURL, f.Updated.UnixNano())
+	for _, chirp := range chirps {
+		fmt.Fprintf(version, "%d %d\n", chirp.Id, chirp.UpdatedAt.UnixNano())
+		if chirp.UpdatedAt.After(f.Updated) {
+			f.Updated = chirp.UpdatedAt
+		}
+
+		url := fmt.Sprintf("%s/api/chirps/%d", base, chirp.Id)
+		item := feed.Item{
+			Id:        url,
+			URL:       url,
+			Text:      chirp.Body,
+			Published: chirp.CreatedAt,
+			Updated:   chirp.UpdatedAt,
+			Tags:      chirpHashtags(chirp),
+		}
+		if author, ok := authors[chirp.AuthorId]; ok {
+			item.Author = cfg.feedAuthor(base, author)
+		}
+		for _, id := range chirp.MediaIds {
+			if attachment, ok := attachments[id]; ok {
+				item.Attachments = append(item.Attachments, feed.Attachment{
+					URL:      base + attachment.URL,
+					MimeType: attachment.ContentType,
+					Size:     attachment.Size,
+				})
+			}
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	lastModified := f.Updated
+	if f.Updated.IsZero() {
+		f.Updated = time.Now()
+	}
+
+	dat, err := format.render(f)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to render feed")
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	// without PUBLIC_URL the links come from the Host header, which shared
+	// caches don't key on, so only the client may cache the feed
+	visibility := "public"
+	if cfg.publicURL == "" {
+		visibility = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(feedMaxAge.Seconds())))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(version.Sum(nil))[:32]+`"`)
+	// http.ServeContent answers conditional requests with 304 Not Modified
+	// and skips Last-Modified when the time is zero
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(dat))
+}
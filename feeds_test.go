@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func TestFeedCaching(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := mustCreateUser(t, cfg, "a@example.com", "alice")
+	_, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: user.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	tests := []struct {
+		publicURL    string
+		cacheControl string
+		link         string
+	}{
+		{"https://chirpy.example", "public, max-age=300", "https://chirpy.example/api/chirps/1"},
+		{"", "private, max-age=300", "http://evil.example/api/chirps/1"},
+	}
+	for _, tt := range tests {
+		cfg.publicURL = tt.publicURL
+		req := httptest.NewRequest(http.MethodGet, "/feeds/chirps.atom", nil)
+		req.Host = "evil.example"
+		rec := httptest.NewRecorder()
+		cfg.handlerGlobalFeed(atomFeed)(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("PUBLIC_URL %q: Cache-Control = %q, want %q", tt.publicURL, got, tt.cacheControl)
+		}
+		if !strings.Contains(rec.Body.String(), tt.link) {
+			t.Errorf("PUBLIC_URL %q: feed doesn't link to %s", tt.publicURL, tt.link)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+// userFromHandleParam looks up the user named by the {handle} URL parameter
+// and writes an error response if there is none
+func (cfg *apiConfig) userFromHandleParam(w http.ResponseWriter, r *http.Request) (jsonDB.User, bool) {
+	user, err := cfg.DB.GetUserByHandle(chi.URLParam(r, "handle"))
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return jsonDB.User{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return jsonDB.User{}, false
+	}
+	return user, true
+}
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	followee, ok := cfg.userFromHandleParam(w, r)
+	if !ok {
+		return
+	}
+
+	if followee.Id == userId {
+		respondWithError(w, http.StatusBadRequest, "can't follow yourself")
+		return
+	}
+
+	_, err = cfg.DB.FollowUser(userId, followee.Id)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to follow user")
+		return
+	}
+
+	type response struct {
+		Following bool `json:"following"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Following: true,
+	})
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	followee, ok := cfg.userFromHandleParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = cfg.DB.UnfollowUser(userId, followee.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to unfollow user")
+		return
+	}
+
+	type response struct {
+		Following bool `json:"following"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Following: false,
+	})
+}
+
+func (cfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.userFromHandleParam(w, r)
+	if !ok {
+		return
+	}
+
+	follows, err := cfg.DB.GetFollowers(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch followers")
+		return
+	}
+
+	ids := make([]int, 0, len(follows))
+	for _, follow := range follows {
+		ids = append(ids, follow.FollowerId)
+	}
+
+	cfg.respondWithUserList(w, ids)
+}
+
+func (cfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.userFromHandleParam(w, r)
+	if !ok {
+		return
+	}
+
+	follows, err := cfg.DB.GetFollowing(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch followed users")
+		return
+	}
+
+	ids := make([]int, 0, len(follows))
+	for _, follow := range follows {
+		ids = append(ids, follow.FolloweeId)
+	}
+
+	cfg.respondWithUserList(w, ids)
+}
+
+func (cfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	beforeId, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// fetch one extra chirp to find out if there is another page
+	chirps, err := cfg.DB.GetTimeline(userId, beforeId, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch timeline")
+		return
+	}
+
+	nextCursor := ""
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		nextCursor = encodeCursor(chirps[len(chirps)-1].Id)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	responses, err := cfg.chirpResponses(r, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp authors")
+		return
+	}
+
+	type response struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Chirps:     responses,
+		NextCursor: nextCursor,
+	})
+}
@@ -9,3 +9,20 @@ require golang.org/x/crypto v0.8.0
 require github.com/joho/godotenv v1.5.1
 
 require github.com/golang-jwt/jwt/v5 v5.0.0
+
+require golang.org/x/text v0.9.0
+
+require github.com/rivo/uniseg v0.4.7
+
+require github.com/gorilla/websocket v1.5.0
+
+require google.golang.org/grpc v1.56.3
+
+require google.golang.org/protobuf v1.30.0
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/chirptext"
+	"github.com/emilmalmsten/chirpy/internal/entities"
+	"github.com/emilmalmsten/chirpy/internal/graphql"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const (
+	graphqlMaxDepth        = 8
+	graphqlMaxComplexity   = 1000
+	graphqlMaxBodySize     = 1 << 20
+	graphqlDefaultPageSize = 20
+)
+
+var (
+	errGraphQLUnauthenticated = errors.New("authentication required")
+	errGraphQLInternal        = errors.New("internal error")
+)
+
+type graphqlContextKey struct{}
+
+// graphqlRequest is the state of one GraphQL request. The loaders cache
+// what they look up for the rest of the request
+type graphqlRequest struct {
+	viewerId    int
+	users       *graphql.Loader[int, jsonDB.User]
+	chirps      *graphql.Loader[int, jsonDB.Chirp]
+	media       *graphql.Loader[string, attachmentResponse]
+	engagements *graphql.Loader[int, chirpEngagement]
+}
+
+// chirpEngagement is how the viewer has engaged with a chirp
+type chirpEngagement struct {
+	liked     bool
+	rechirped bool
+}
+
+func (cfg *apiConfig) newGraphQLRequest(viewerId int) *graphqlRequest {
+	return &graphqlRequest{
+		viewerId: viewerId,
+		users:    graphql.NewLoader(graphqlFetch(cfg.DB.GetUsers)),
+		chirps:   graphql.NewLoader(graphqlFetch(cfg.DB.GetChirpsByIds)),
+		media: graphql.NewLoader(graphqlFetch(func(ids []string) (map[string]attachmentResponse, error) {
+			media, err := cfg.DB.GetMediaByIds(ids)
+			if err != nil {
+				return nil, err
+			}
+			responses := make(map[string]attachmentResponse, len(media))
+			for id, m := range media {
+				responses[id] = newAttachmentResponse(m)
+			}
+			return responses, nil
+		})),
+		engagements: graphql.NewLoader(graphqlFetch(func(chirpIds []int) (map[int]chirpEngagement, error) {
+			engagements := map[int]chirpEngagement{}
+			if viewerId == 0 {
+				return engagements, nil
+			}
+			liked, rechirped, err := cfg.DB.GetUserEngagements(viewerId, chirpIds)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range chirpIds {
+				engagements[id] = chirpEngagement{liked: liked[id], rechirped: rechirped[id]}
+			}
+			return engagements, nil
+		})),
+	}
+}
+
+// graphqlFetch hides the errors of a loader's lookups from clients
+func graphqlFetch[K comparable, V any](fetch func([]K) (map[K]V, error)) func([]K) (map[K]V, error) {
+	return func(keys []K) (map[K]V, error) {
+		values, err := fetch(keys)
+		if err != nil {
+			return nil, graphqlInternalError(err)
+		}
+		return values, nil
+	}
+}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlContextKey{}).(*graphqlRequest)
+}
+
+// graphqlViewer returns the authenticated user making a request, or
+// errGraphQLUnauthenticated
+func graphqlViewer(p graphql.ResolveParams) (int, error) {
+	viewerId := graphqlRequestFrom(p.Context).viewerId
+	if viewerId == 0 {
+		return 0, errGraphQLUnauthenticated
+	}
+	return viewerId, nil
+}
+
+// graphqlInternalError logs an error and hides it from the client
+func graphqlInternalError(err error) error {
+	log.Printf("GraphQL resolver failed: %s", err)
+	return errGraphQLInternal
+}
+
+// scalar returns a resolver for a scalar field computed from the source
+func scalar[T any](get func(T) interface{}) graphql.ResolveFunc {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(T)), nil
+	}
+}
+
+// newGraphQLSchema builds the schema served at /api/graphql. It exposes
+// the same data as the REST API, under the same visibility rules
+func (cfg *apiConfig) newGraphQLSchema() *graphql.Schema {
+	userType := &graphql.Object{Name: "User"}
+	chirpType := &graphql.Object{Name: "Chirp"}
+	mediaType := &graphql.Object{Name: "Media"}
+
+	userType.Fields = map[string]*graphql.FieldDefinition{
+		"id":          {Resolve: scalar(func(u jsonDB.User) interface{} { return u.Id })},
+		"handle":      {Resolve: scalar(func(u jsonDB.User) interface{} { return u.Handle })},
+		"displayName": {Resolve: scalar(func(u jsonDB.User) interface{} { return u.DisplayName })},
+		"bio":         {Resolve: scalar(func(u jsonDB.User) interface{} { return u.Bio })},
+		"avatarUrl":   {Resolve: scalar(func(u jsonDB.User) interface{} { return u.AvatarURL })},
+		"isChirpyRed": {Resolve: scalar(func(u jsonDB.User) interface{} { return u.IsChirpyRed(time.Now()) })},
+		"createdAt":   {Resolve: scalar(func(u jsonDB.User) interface{} { return u.CreatedAt })},
+		// The email address is only shown to its owner
+		"email": {Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			user := p.Source.(jsonDB.User)
+			if graphqlRequestFrom(p.Context).viewerId != user.Id {
+				return nil, nil
+			}
+			return user.Email, nil
+		}},
+		"chirps": {
+			Type: chirpType,
+			List: true,
+			Args: map[string]interface{}{"limit": graphqlDefaultPageSize, "after": nil, "sort": "desc"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return cfg.resolveChirpList(p, p.Source.(jsonDB.User).Id)
+			},
+		},
+	}
+
+	chirpType.Fields = map[string]*graphql.FieldDefinition{
+		"id":           {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.Id })},
+		"body":         {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.Body })},
+		"authorId":     {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.AuthorId })},
+		"replyCount":   {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.ReplyCount })},
+		"likeCount":    {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.LikeCount })},
+		"rechirpCount": {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.RechirpCount })},
+		"edited":       {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.Edited })},
+		"hidden":       {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.Hidden })},
+		"hashtags":     {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return chirpHashtags(c) })},
+		"createdAt":    {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.CreatedAt })},
+		"updatedAt":    {Resolve: scalar(func(c jsonDB.Chirp) interface{} { return c.UpdatedAt })},
+		"author": {
+			Type: userType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlRequestFrom(p.Context).users.Load(p.Source.(jsonDB.Chirp).AuthorId), nil
+			},
+		},
+		"inReplyTo": {
+			Type: chirpType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				chirp := p.Source.(jsonDB.Chirp)
+				if chirp.InReplyTo == 0 {
+					return nil, nil
+				}
+				return graphqlRequestFrom(p.Context).chirps.Load(chirp.InReplyTo), nil
+			},
+		},
+		"likedByMe": {Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return cfg.resolveEngagement(p, func(e chirpEngagement) bool { return e.liked })
+		}},
+		"rechirpedByMe": {Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return cfg.resolveEngagement(p, func(e chirpEngagement) bool { return e.rechirped })
+		}},
+		"media": {
+			Type: mediaType,
+			List: true,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				chirp := p.Source.(jsonDB.Chirp)
+				loader := graphqlRequestFrom(p.Context).media
+				thunks := make([]graphql.Thunk, 0, len(chirp.MediaIds))
+				for _, id := range chirp.MediaIds {
+					thunks = append(thunks, loader.Load(id))
+				}
+				return graphql.Thunk(func() (interface{}, error) {
+					media := []attachmentResponse{}
+					for _, thunk := range thunks {
+						m, err := thunk()
+						if err != nil {
+							return nil, err
+						}
+						if m != nil {
+							media = append(media, m.(attachmentResponse))
+						}
+					}
+					return media, nil
+				}), nil
+			},
+		},
+	}
+
+	mediaType.Fields = map[string]*graphql.FieldDefinition{
+		"id":           {Resolve: scalar(func(m attachmentResponse) interface{} { return m.Id })},
+		"contentType":  {Resolve: scalar(func(m attachmentResponse) interface{} { return m.ContentType })},
+		"size":         {Resolve: scalar(func(m attachmentResponse) interface{} { return m.Size })},
+		"width":        {Resolve: scalar(func(m attachmentResponse) interface{} { return m.Width })},
+		"height":       {Resolve: scalar(func(m attachmentResponse) interface{} { return m.Height })},
+		"url":          {Resolve: scalar(func(m attachmentResponse) interface{} { return m.URL })},
+		"thumbnailUrl": {Resolve: scalar(func(m attachmentResponse) interface{} { return m.ThumbnailURL })},
+	}
+
+	queryType := &graphql.Object{Name: "Query", Fields: map[string]*graphql.FieldDefinition{
+		"viewer": {
+			Type: userType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				viewerId := graphqlRequestFrom(p.Context).viewerId
+				if viewerId == 0 {
+					return nil, nil
+				}
+				return graphqlRequestFrom(p.Context).users.Load(viewerId), nil
+			},
+		},
+		"user": {
+			Type:    userType,
+			Args:    map[string]interface{}{"id": nil, "handle": nil},
+			Resolve: cfg.resolveUser,
+		},
+		"chirp": {
+			Type:    chirpType,
+			Args:    map[string]interface{}{"id": nil},
+			Resolve: cfg.resolveChirp,
+		},
+		"chirps": {
+			Type: chirpType,
+			List: true,
+			Args: map[string]interface{}{"authorId": nil, "tag": nil, "limit": graphqlDefaultPageSize, "after": nil, "sort": "asc"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				authorId, _, err := graphql.IntArg(p.Args, "authorId")
+				if err != nil {
+					return nil, err
+				}
+				return cfg.resolveChirpList(p, authorId)
+			},
+		},
+	}}
+
+	mutationType := &graphql.Object{Name: "Mutation", Fields: map[string]*graphql.FieldDefinition{
+		"createChirp": {
+			Type:    chirpType,
+			Args:    map[string]interface{}{"body": nil, "inReplyTo": nil, "mediaIds": nil},
+			Resolve: cfg.resolveCreateChirp,
+		},
+		"deleteChirp": {
+			Args:    map[string]interface{}{"id": nil},
+			Resolve: cfg.resolveDeleteChirp,
+		},
+		"updateUser": {
+			Type: userType,
+			Args: map[string]interface{}{
+				"email": nil, "password": nil, "handle": nil, "displayName": nil, "bio": nil, "avatarUrl": nil,
+			},
+			Resolve: cfg.resolveUpdateUser,
+		},
+	}}
+
+	return &graphql.Schema{
+		Query:         queryType,
+		Mutation:      mutationType,
+		MaxDepth:      graphqlMaxDepth,
+		MaxComplexity: graphqlMaxComplexity,
+	}
+}
+
+func (cfg *apiConfig) resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	id, hasId, err := graphql.IntArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+	handle, hasHandle, err := graphql.StringArg(p.Args, "handle")
+	if err != nil {
+		return nil, err
+	}
+	if hasId == hasHandle {
+		return nil, errors.New("either id or handle is required")
+	}
+
+	if hasId {
+		return graphqlRequestFrom(p.Context).users.Load(id), nil
+	}
+	user, err := cfg.DB.GetUserByHandle(handle)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			return nil, nil
+		}
+		return nil, graphqlInternalError(err)
+	}
+	return user, nil
+}
+
+func (cfg *apiConfig) resolveChirp(p graphql.ResolveParams) (interface{}, error) {
+	id, ok, err := graphql.IntArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("id is required")
+	}
+
+	chirp, err := cfg.DB.GetChirp(id)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			return nil, nil
+		}
+		return nil, graphqlInternalError(err)
+	}
+	if !cfg.viewerCanSeeChirp(graphqlRequestFrom(p.Context).viewerId, chirp) {
+		return nil, nil
+	}
+	return chirp, nil
+}
+
+// resolveChirpList pages through chirps the way GET /api/chirps does, of a
+// single author when authorId is not 0. after is the ID of the last chirp
+// of the previous page
+func (cfg *apiConfig) resolveChirpList(p graphql.ResolveParams, authorId int) (interface{}, error) {
+	limit, _, err := graphql.IntArg(p.Args, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 {
+		return nil, errors.New("limit must be positive")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	after, _, err := graphql.IntArg(p.Args, "after")
+	if err != nil {
+		return nil, err
+	}
+	tag, _, err := graphql.StringArg(p.Args, "tag")
+	if err != nil {
+		return nil, err
+	}
+	sort, _, err := graphql.StringArg(p.Args, "sort")
+	if err != nil {
+		return nil, err
+	}
+
+	chirps, err := cfg.DB.GetChirpsPage(jsonDB.ChirpQuery{
+		AuthorId:   authorId,
+		Hashtag:    entities.NormalizeTag(tag),
+		AfterId:    after,
+		Descending: sort == "desc",
+		Limit:      limit,
+	})
+	if err != nil {
+		return nil, graphqlInternalError(err)
+	}
+	return chirps, nil
+}
+
+func (cfg *apiConfig) resolveEngagement(p graphql.ResolveParams, flag func(chirpEngagement) bool) (interface{}, error) {
+	thunk := graphqlRequestFrom(p.Context).engagements.Load(p.Source.(jsonDB.Chirp).Id)
+	return graphql.Thunk(func() (interface{}, error) {
+		engagement, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		if engagement == nil {
+			return false, nil
+		}
+		return flag(engagement.(chirpEngagement)), nil
+	}), nil
+}
+
+func (cfg *apiConfig) resolveCreateChirp(p graphql.ResolveParams) (interface{}, error) {
+	userId, err := graphqlViewer(p)
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := graphql.StringArg(p.Args, "body")
+	if err != nil {
+		return nil, err
+	}
+	inReplyTo, _, err := graphql.IntArg(p.Args, "inReplyTo")
+	if err != nil {
+		return nil, err
+	}
+	mediaIds, err := graphql.StringListArg(p.Args, "mediaIds")
+	if err != nil {
+		return nil, err
+	}
+
+	ent, err := cfg.entitlementsFor(userId)
+	if err != nil {
+		return nil, graphqlInternalError(err)
+	}
+	chirp, err := cfg.createChirp(userId, ent, body, inReplyTo, mediaIds, func() error {
+		if !cfg.allowAction("chirp", userId, ent.ChirpsPerHour, time.Now()).Allowed {
+			return errRateLimited
+		}
+		return nil
+	})
+	if err != nil {
+		var validationErr *chirptext.ValidationError
+		switch {
+		case errors.Is(err, errRateLimited):
+			return nil, err
+		case errors.As(err, &validationErr):
+			return nil, errors.New(validationErr.Message)
+		case errors.Is(err, errTooManyAttachments):
+			return nil, errors.New("too many attachments")
+		case errors.Is(err, jsonDB.ErrDoesNotExists):
+			return nil, errors.New("chirp being replied to does not exist")
+		case errors.Is(err, jsonDB.ErrInvalidMedia):
+			return nil, errors.New("media must be your own unattached uploads")
+		}
+		return nil, graphqlInternalError(err)
+	}
+	return chirp, nil
+}
+
+func (cfg *apiConfig) resolveDeleteChirp(p graphql.ResolveParams) (interface{}, error) {
+	userId, err := graphqlViewer(p)
+	if err != nil {
+		return nil, err
+	}
+	id, ok, err := graphql.IntArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("id is required")
+	}
+
+	err = cfg.deleteChirp(id, userId)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			return nil, errors.New("chirp not found")
+		}
+		if errors.Is(err, jsonDB.ErrNotAuthorized) {
+			return nil, errors.New("unauthorized to delete chirp")
+		}
+		return nil, graphqlInternalError(err)
+	}
+	return true, nil
+}
+
+// resolveUpdateUser changes the account and profile of the viewer. Fields
+// that are left out keep their value
+func (cfg *apiConfig) resolveUpdateUser(p graphql.ResolveParams) (interface{}, error) {
+	userId, err := graphqlViewer(p)
+	if err != nil {
+		return nil, err
+	}
+
+	update := jsonDB.ProfileUpdate{}
+	for name, field := range map[string]**string{
+		"handle":      &update.Handle,
+		"displayName": &update.DisplayName,
+		"bio":         &update.Bio,
+		"avatarUrl":   &update.AvatarURL,
+	} {
+		value, ok, err := graphql.StringArg(p.Args, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			*field = &value
+		}
+	}
+	err = validateProfileUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	email, hasEmail, err := graphql.StringArg(p.Args, "email")
+	if err != nil {
+		return nil, err
+	}
+	password, hasPassword, err := graphql.StringArg(p.Args, "password")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		return nil, graphqlInternalError(err)
+	}
+	if hasEmail || hasPassword {
+		hashedPassword := user.Password
+		if hasPassword {
+			hashedPassword, err = auth.HashPassword(password)
+			if err != nil {
+				return nil, graphqlInternalError(err)
+			}
+		}
+		if !hasEmail {
+			email = user.Email
+		}
+		user, err = cfg.DB.UpdateUser(userId, email, hashedPassword)
+		if err != nil {
+			return nil, graphqlInternalError(err)
+		}
+	}
+
+	if update != (jsonDB.ProfileUpdate{}) {
+		user, err = cfg.DB.UpdateProfile(userId, update)
+		if err != nil {
+			if errors.Is(err, jsonDB.ErrHandleTaken) {
+				return nil, errors.New("handle already taken")
+			}
+			return nil, graphqlInternalError(err)
+		}
+	}
+	return user, nil
+}
+
+// handlerGraphQL serves GraphQL requests, as JSON posted to the endpoint or
+// as query parameters of a GET request, which can only run queries.
+// Requests that fail before running get a 400; errors of single fields are
+// reported next to the data of the others
+func (cfg *apiConfig) handlerGraphQL(w http.ResponseWriter, r *http.Request) {
+	req := graphql.Request{}
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		req.ReadOnly = true
+		if variables := query.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "couldn't decode variables")
+				return
+			}
+		}
+	} else {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphqlMaxBodySize)).Decode(&req)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+			return
+		}
+	}
+	if req.Query == "" {
+		respondWithError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlContextKey{}, cfg.newGraphQLRequest(cfg.viewerID(r)))
+	response := cfg.graphqlSchema.Execute(ctx, req)
+	if response.Data == nil {
+		respondWithJSON(w, http.StatusBadRequest, response)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
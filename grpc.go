@@ -0,0 +1,469 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/emilmalmsten/chirpy --go-grpc_out=. --go-grpc_opt=module=github.com/emilmalmsten/chirpy proto/chirpy/v1/chirpy.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/chirptext"
+	"github.com/emilmalmsten/chirpy/internal/chirpypb"
+	"github.com/emilmalmsten/chirpy/internal/entities"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/stream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultGRPCAddr = "localhost:9090"
+
+// grpcAuthRequired lists the methods that need an authenticated caller.
+// Every other method accepts an optional access token
+var grpcAuthRequired = map[string]bool{
+	chirpypb.ChirpService_CreateChirp_FullMethodName: true,
+	chirpypb.ChirpService_UpdateChirp_FullMethodName: true,
+	chirpypb.ChirpService_DeleteChirp_FullMethodName: true,
+}
+
+type grpcUserIdKey struct{}
+
+// grpcServer implements the gRPC services on top of the same storage and
+// auth code as the HTTP API
+type grpcServer struct {
+	chirpypb.UnimplementedChirpServiceServer
+	chirpypb.UnimplementedUserServiceServer
+	chirpypb.UnimplementedAuthServiceServer
+	cfg *apiConfig
+}
+
+// newGRPCServer returns a gRPC server with every service registered. Idle
+// connections are pinged as often as the HTTP stream sends heartbeats, so
+// streams to clients that went away are closed
+func (cfg *apiConfig) newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(cfg.grpcUnaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(cfg.grpcStreamAuthInterceptor),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    streamHeartbeat,
+			Timeout: streamHeartbeat,
+		}),
+	)
+
+	srv := &grpcServer{cfg: cfg}
+	chirpypb.RegisterChirpServiceServer(server, srv)
+	chirpypb.RegisterUserServiceServer(server, srv)
+	chirpypb.RegisterAuthServiceServer(server, srv)
+	return server
+}
+
+// grpcAuthenticate validates the access token in the "authorization"
+// metadata of a call, as "Bearer <token>" like the HTTP header, and stores
+// the id of the caller in the context. A token that is present must be
+// valid even when the method doesn't require one
+func (cfg *apiConfig) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		if grpcAuthRequired[method] {
+			return nil, status.Error(codes.Unauthenticated, "access token required")
+		}
+		return ctx, nil
+	}
+
+	token, err := auth.GetBearerToken(http.Header{"Authorization": values})
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "malformed authorization metadata")
+	}
+
+	userId, err := cfg.userForToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	return context.WithValue(ctx, grpcUserIdKey{}, userId), nil
+}
+
+func (cfg *apiConfig) grpcUnaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := cfg.grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (cfg *apiConfig) grpcStreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := cfg.grpcAuthenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream replaces the context of a stream with the one
+// carrying the caller's id
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcUserID returns the id of the authenticated caller, or 0 for anonymous
+// calls
+func grpcUserID(ctx context.Context) int {
+	userId, _ := ctx.Value(grpcUserIdKey{}).(int)
+	return userId
+}
+
+func grpcInternalError(msg string, err error) error {
+	log.Printf("gRPC error: %s: %s", msg, err)
+	return status.Error(codes.Internal, msg)
+}
+
+func newGRPCChirp(response chirpResponse) *chirpypb.Chirp {
+	chirp := &chirpypb.Chirp{
+		Id:            int64(response.Id),
+		Body:          response.Body,
+		AuthorId:      int64(response.AuthorId),
+		InReplyTo:     int64(response.InReplyTo),
+		ReplyCount:    int32(response.ReplyCount),
+		LikeCount:     int32(response.LikeCount),
+		RechirpCount:  int32(response.RechirpCount),
+		LikedByMe:     response.LikedByMe,
+		RechirpedByMe: response.RechirpedByMe,
+		Hidden:        response.Hidden,
+		Edited:        response.Edited,
+		Hashtags:      []string{},
+		Media:         []*chirpypb.Media{},
+		CreatedAt:     timestamppb.New(response.CreatedAt),
+		UpdatedAt:     timestamppb.New(response.UpdatedAt),
+	}
+
+	if response.Author != nil {
+		chirp.Author = &chirpypb.Author{
+			Id:          int64(response.Author.Id),
+			Handle:      response.Author.Handle,
+			DisplayName: response.Author.DisplayName,
+			AvatarUrl:   response.Author.AvatarURL,
+		}
+	}
+	for _, hashtag := range response.Entities.Hashtags {
+		chirp.Hashtags = append(chirp.Hashtags, hashtag.Tag)
+	}
+	for _, media := range response.Media {
+		chirp.Media = append(chirp.Media, &chirpypb.Media{
+			Id:           media.Id,
+			ContentType:  media.ContentType,
+			Size:         media.Size,
+			Width:        int32(media.Width),
+			Height:       int32(media.Height),
+			Url:          media.URL,
+			ThumbnailUrl: media.ThumbnailURL,
+		})
+	}
+
+	return chirp
+}
+
+// grpcChirps converts chirps into messages as the caller sees them, with
+// their authors embedded
+func (s *grpcServer) grpcChirps(ctx context.Context, chirps []jsonDB.Chirp) ([]*chirpypb.Chirp, error) {
+	responses, err := s.cfg.chirpResponsesFor(grpcUserID(ctx), true, chirps)
+	if err != nil {
+		return nil, grpcInternalError("failed to fetch chirp details", err)
+	}
+
+	messages := make([]*chirpypb.Chirp, 0, len(responses))
+	for _, response := range responses {
+		messages = append(messages, newGRPCChirp(response))
+	}
+	return messages, nil
+}
+
+func (s *grpcServer) CreateChirp(ctx context.Context, req *chirpypb.CreateChirpRequest) (*chirpypb.Chirp, error) {
+	userId := grpcUserID(ctx)
+	ent, err := s.cfg.entitlementsFor(userId)
+	if err != nil {
+		return nil, grpcInternalError("error retrieving user", err)
+	}
+	chirp, err := s.cfg.createChirp(userId, ent, req.Body, int(req.InReplyTo), req.MediaIds, func() error {
+		if !s.cfg.allowAction("chirp", userId, ent.ChirpsPerHour, time.Now()).Allowed {
+			return errRateLimited
+		}
+		return nil
+	})
+	if err != nil {
+		var validationErr *chirptext.ValidationError
+		switch {
+		case errors.Is(err, errRateLimited):
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		case errors.As(err, &validationErr):
+			return nil, status.Error(codes.InvalidArgument, validationErr.Message)
+		case errors.Is(err, errTooManyAttachments):
+			return nil, status.Errorf(codes.InvalidArgument, "a chirp can have at most %d attachments", ent.MaxAttachmentsPerChirp)
+		case errors.Is(err, jsonDB.ErrDoesNotExists):
+			return nil, status.Error(codes.InvalidArgument, "chirp being replied to does not exist")
+		case errors.Is(err, jsonDB.ErrInvalidMedia):
+			return nil, status.Error(codes.InvalidArgument, "media must be your own unattached uploads")
+		default:
+			return nil, grpcInternalError("failed to create chirp", err)
+		}
+	}
+
+	messages, err := s.grpcChirps(ctx, []jsonDB.Chirp{chirp})
+	if err != nil {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (s *grpcServer) GetChirp(ctx context.Context, req *chirpypb.GetChirpRequest) (*chirpypb.Chirp, error) {
+	chirp, err := s.cfg.DB.GetChirp(int(req.Id))
+	if err != nil || !s.cfg.viewerCanSeeChirp(grpcUserID(ctx), chirp) {
+		return nil, status.Error(codes.NotFound, "chirp not found")
+	}
+
+	messages, err := s.grpcChirps(ctx, []jsonDB.Chirp{chirp})
+	if err != nil {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+// ListChirps pages through visible chirps with the same cursors as
+// GET /api/chirps
+func (s *grpcServer) ListChirps(ctx context.Context, req *chirpypb.ListChirpsRequest) (*chirpypb.ListChirpsResponse, error) {
+	limit := int(req.PageSize)
+	if limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page size")
+	}
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	afterId, err := decodeCursor(req.PageToken)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+
+	hashtag := ""
+	if req.Hashtag != "" {
+		hashtag = entities.NormalizeTag(req.Hashtag)
+		if hashtag == "" {
+			return nil, status.Error(codes.InvalidArgument, "invalid hashtag")
+		}
+	}
+
+	chirps, err := s.cfg.DB.GetChirpsPage(jsonDB.ChirpQuery{
+		AuthorId:   int(req.AuthorId),
+		Hashtag:    hashtag,
+		AfterId:    afterId,
+		Descending: req.Descending,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		return nil, grpcInternalError("failed to fetch chirps", err)
+	}
+
+	response := &chirpypb.ListChirpsResponse{}
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		response.NextPageToken = encodeCursor(chirps[len(chirps)-1].Id)
+	}
+
+	response.Chirps, err = s.grpcChirps(ctx, chirps)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *grpcServer) UpdateChirp(ctx context.Context, req *chirpypb.UpdateChirpRequest) (*chirpypb.Chirp, error) {
+	userId := grpcUserID(ctx)
+	ent, err := s.cfg.entitlementsFor(userId)
+	if err != nil {
+		return nil, grpcInternalError("error retrieving user", err)
+	}
+
+	chirp, err := s.cfg.editChirp(int(req.Id), userId, ent, req.Body)
+	if err != nil {
+		var validationErr *chirptext.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			return nil, status.Error(codes.InvalidArgument, validationErr.Message)
+		case errors.Is(err, errEditingRequiresRed):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, jsonDB.ErrDoesNotExists):
+			return nil, status.Error(codes.NotFound, "chirp not found")
+		case errors.Is(err, jsonDB.ErrNotAuthorized):
+			return nil, status.Error(codes.PermissionDenied, "unauthorized to edit chirp")
+		case errors.Is(err, jsonDB.ErrEditWindowExpired):
+			return nil, status.Error(codes.FailedPrecondition, "chirp can no longer be edited")
+		default:
+			return nil, grpcInternalError("failed to edit chirp", err)
+		}
+	}
+
+	messages, err := s.grpcChirps(ctx, []jsonDB.Chirp{chirp})
+	if err != nil {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (s *grpcServer) DeleteChirp(ctx context.Context, req *chirpypb.DeleteChirpRequest) (*chirpypb.DeleteChirpResponse, error) {
+	err := s.cfg.deleteChirp(int(req.Id), grpcUserID(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonDB.ErrDoesNotExists):
+			return nil, status.Error(codes.NotFound, "chirp not found")
+		case errors.Is(err, jsonDB.ErrNotAuthorized):
+			return nil, status.Error(codes.PermissionDenied, "unauthorized to delete chirp")
+		default:
+			return nil, grpcInternalError("failed to delete chirp", err)
+		}
+	}
+	return &chirpypb.DeleteChirpResponse{}, nil
+}
+
+// StreamChirps sends the events of the real-time stream hub, the same ones
+// the Server-Sent Events and WebSocket streams get
+func (s *grpcServer) StreamChirps(req *chirpypb.StreamChirpsRequest, ss chirpypb.ChirpService_StreamChirpsServer) error {
+	filter := stream.Filter{}
+	if len(req.AuthorIds) > 0 {
+		filter.Authors = map[int]bool{}
+		for _, authorId := range req.AuthorIds {
+			filter.Authors[int(authorId)] = true
+		}
+	}
+	if req.Hashtag != "" {
+		filter.Hashtag = entities.NormalizeTag(req.Hashtag)
+		if filter.Hashtag == "" {
+			return status.Error(codes.InvalidArgument, "invalid hashtag")
+		}
+	}
+	if req.LastEventId < 0 {
+		return status.Error(codes.InvalidArgument, "invalid last event ID")
+	}
+
+	sub, missed := s.cfg.streamHub.Subscribe(filter, req.LastEventId)
+	defer sub.Close()
+
+	send := func(ev stream.Event) error {
+		message, err := newGRPCChirpEvent(ev)
+		if err != nil {
+			return grpcInternalError("failed to decode stream event", err)
+		}
+		return ss.Send(message)
+	}
+
+	for _, ev := range missed {
+		if err := send(ev); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ss.Context().Done():
+			return status.FromContextError(ss.Context().Err()).Err()
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return status.Error(codes.Unavailable, "stream fell behind, resume from the last event")
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newGRPCChirpEvent decodes the JSON data the stream hub carries for clients
+func newGRPCChirpEvent(ev stream.Event) (*chirpypb.ChirpEvent, error) {
+	message := &chirpypb.ChirpEvent{Id: ev.Id}
+
+	switch ev.Type {
+	case stream.ChirpCreated:
+		response := chirpResponse{}
+		err := json.Unmarshal(ev.Data, &response)
+		if err != nil {
+			return nil, err
+		}
+		message.Event = &chirpypb.ChirpEvent_Created{Created: newGRPCChirp(response)}
+	case stream.ChirpDeleted:
+		deleted := struct {
+			Id       int64 `json:"id"`
+			AuthorId int64 `json:"author_id"`
+		}{}
+		err := json.Unmarshal(ev.Data, &deleted)
+		if err != nil {
+			return nil, err
+		}
+		message.Event = &chirpypb.ChirpEvent_Deleted{Deleted: &chirpypb.DeletedChirp{
+			Id:       deleted.Id,
+			AuthorId: deleted.AuthorId,
+		}}
+	default:
+		return nil, fmt.Errorf("unknown event type %q", ev.Type)
+	}
+
+	return message, nil
+}
+
+func (s *grpcServer) GetUser(ctx context.Context, req *chirpypb.GetUserRequest) (*chirpypb.User, error) {
+	var user jsonDB.User
+	var err error
+	switch lookup := req.User.(type) {
+	case *chirpypb.GetUserRequest_Id:
+		user, err = s.cfg.DB.GetUser(int(lookup.Id))
+	case *chirpypb.GetUserRequest_Handle:
+		user, err = s.cfg.DB.GetUserByHandle(lookup.Handle)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "id or handle is required")
+	}
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, grpcInternalError("error retrieving user", err)
+	}
+
+	followers, following, err := s.cfg.DB.CountFollows(user.Id)
+	if err != nil {
+		return nil, grpcInternalError("failed to count follows", err)
+	}
+
+	return &chirpypb.User{
+		Id:             int64(user.Id),
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarUrl:      user.AvatarURL,
+		FollowersCount: int32(followers),
+		FollowingCount: int32(following),
+		CreatedAt:      timestamppb.New(user.CreatedAt),
+	}, nil
+}
+
+func (s *grpcServer) ValidateToken(ctx context.Context, req *chirpypb.ValidateTokenRequest) (*chirpypb.ValidateTokenResponse, error) {
+	userId, err := s.cfg.userForToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return &chirpypb.ValidateTokenResponse{UserId: int64(userId)}, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/chirpypb"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func TestGRPCUpdateChirpReindexesChirp(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+	_, err := cfg.DB.ApplyMembershipEvent(alice.Id, jsonDB.EventUserUpgraded, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ApplyMembershipEvent: %s", err)
+	}
+	chirp, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello world", AuthorId: alice.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	srv := &grpcServer{cfg: cfg}
+	ctx := context.WithValue(context.Background(), grpcUserIdKey{}, alice.Id)
+	_, err = srv.UpdateChirp(ctx, &chirpypb.UpdateChirpRequest{Id: int64(chirp.Id), Body: "goodbye world"})
+	if err != nil {
+		t.Fatalf("UpdateChirp: %s", err)
+	}
+
+	if ids := searchChirps(t, cfg, url.Values{"q": {"hello"}}); len(ids) != 0 {
+		t.Errorf("found %v by the old body, want nothing", ids)
+	}
+	if ids := searchChirps(t, cfg, url.Values{"q": {"goodbye"}}); len(ids) != 1 {
+		t.Errorf("found %v by the new body, want chirp %d", ids, chirp.Id)
+	}
+}
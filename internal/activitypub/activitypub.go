@@ -0,0 +1,183 @@
+// Package activitypub has the ActivityStreams documents, HTTP Signatures and
+// client needed to federate with other ActivityPub servers
+package activitypub
+
+import (
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of ActivityPub documents. Servers also
+// accept and send the JSON-LD media type with the ActivityStreams profile
+const ContentType = "application/activity+json"
+
+const (
+	ActivityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	SecurityContext        = "https://w3id.org/security/v1"
+	// Public is the special collection that addresses a post to everyone
+	Public = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+// Types of activities and objects
+const (
+	TypeAccept                = "Accept"
+	TypeCreate                = "Create"
+	TypeDelete                = "Delete"
+	TypeFollow                = "Follow"
+	TypeLike                  = "Like"
+	TypeUndo                  = "Undo"
+	TypeNote                  = "Note"
+	TypePerson                = "Person"
+	TypeTombstone             = "Tombstone"
+	TypeHashtag               = "Hashtag"
+	TypeDocument              = "Document"
+	TypeImage                 = "Image"
+	TypeOrderedCollection     = "OrderedCollection"
+	TypeOrderedCollectionPage = "OrderedCollectionPage"
+)
+
+// IsActivityPubMediaType reports whether a Content-Type or Accept header
+// value names an ActivityPub document
+func IsActivityPubMediaType(value string) bool {
+	return strings.Contains(value, ContentType) ||
+		(strings.Contains(value, "application/ld+json") && strings.Contains(value, "activitystreams"))
+}
+
+type PublicKey struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Image struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Actor struct {
+	Context           interface{} `json:"@context,omitempty"`
+	Id                string      `json:"id"`
+	Type              string      `json:"type"`
+	PreferredUsername string      `json:"preferredUsername"`
+	Name              string      `json:"name,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	URL               string      `json:"url,omitempty"`
+	Icon              *Image      `json:"icon,omitempty"`
+	Inbox             string      `json:"inbox"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	Following         string      `json:"following,omitempty"`
+	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+	PublicKey         PublicKey   `json:"publicKey"`
+	Published         *time.Time  `json:"published,omitempty"`
+}
+
+// DeliveryInbox returns the inbox activities for an actor should be sent
+// to, preferring its server's shared inbox
+func (a Actor) DeliveryInbox() string {
+	if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+		return a.Endpoints.SharedInbox
+	}
+	return a.Inbox
+}
+
+type Tag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type Attachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+}
+
+type Note struct {
+	Context      interface{}  `json:"@context,omitempty"`
+	Id           string       `json:"id"`
+	Type         string       `json:"type"`
+	AttributedTo string       `json:"attributedTo,omitempty"`
+	Content      string       `json:"content,omitempty"`
+	InReplyTo    string       `json:"inReplyTo,omitempty"`
+	URL          string       `json:"url,omitempty"`
+	Published    *time.Time   `json:"published,omitempty"`
+	Updated      *time.Time   `json:"updated,omitempty"`
+	To           []string     `json:"to,omitempty"`
+	Cc           []string     `json:"cc,omitempty"`
+	Tag          []Tag        `json:"tag,omitempty"`
+	Attachment   []Attachment `json:"attachment,omitempty"`
+}
+
+// Activity is an activity we send or receive. Object is an id or an
+// embedded object; received objects decode to a string or a map
+type Activity struct {
+	Context   interface{} `json:"@context,omitempty"`
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	Published *time.Time  `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	Cc        []string    `json:"cc,omitempty"`
+}
+
+// ObjectId returns the id of a received activity's object
+func (a Activity) ObjectId() string {
+	switch obj := a.Object.(type) {
+	case string:
+		return obj
+	case map[string]interface{}:
+		id, _ := obj["id"].(string)
+		return id
+	}
+	return ""
+}
+
+// ObjectType returns the type of a received activity's embedded object, or
+// "" when only its id was sent
+func (a Activity) ObjectType() string {
+	if obj, ok := a.Object.(map[string]interface{}); ok {
+		t, _ := obj["type"].(string)
+		return t
+	}
+	return ""
+}
+
+// ObjectField returns a string field of a received activity's embedded
+// object
+func (a Activity) ObjectField(name string) string {
+	if obj, ok := a.Object.(map[string]interface{}); ok {
+		value, _ := obj[name].(string)
+		return value
+	}
+	return ""
+}
+
+type OrderedCollection struct {
+	Context    interface{}   `json:"@context,omitempty"`
+	Id         string        `json:"id"`
+	Type       string        `json:"type"`
+	TotalItems int           `json:"totalItems"`
+	First      string        `json:"first,omitempty"`
+	PartOf     string        `json:"partOf,omitempty"`
+	Next       string        `json:"next,omitempty"`
+	Items      []interface{} `json:"orderedItems,omitempty"`
+}
+
+// WebFinger is a JSON Resource Descriptor, the answer to a WebFinger query
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
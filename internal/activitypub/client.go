@@ -0,0 +1,91 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxDocumentSize limits the size of documents fetched from other servers
+const maxDocumentSize = 1 << 20
+
+var ErrNotFound = errors.New("remote object not found")
+
+// Client fetches documents from and delivers activities to other servers.
+// The http.Client can be swapped out to talk to a fake server
+type Client struct {
+	HTTP      *http.Client
+	UserAgent string
+}
+
+// FetchActor fetches the actor document at a URL
+func (c *Client) FetchActor(ctx context.Context, url string) (Actor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Actor{}, err
+	}
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return Actor{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return Actor{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Actor{}, fmt.Errorf("fetching actor %s: %s", url, resp.Status)
+	}
+
+	actor := Actor{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(&actor)
+	if err != nil {
+		return Actor{}, fmt.Errorf("decoding actor %s: %w", url, err)
+	}
+	if actor.Id == "" || actor.Inbox == "" || actor.PublicKey.PublicKeyPem == "" {
+		return Actor{}, fmt.Errorf("actor %s is missing an id, inbox or public key", url)
+	}
+	return actor, nil
+}
+
+// Deliver posts an activity to an inbox, signed with the sending actor's
+// key
+func (c *Client) Deliver(ctx context.Context, inbox string, activity interface{}, keyId string, key *rsa.PrivateKey) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("User-Agent", c.UserAgent)
+	err = Sign(req, body, keyId, key, time.Now())
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("delivering to %s: %s", inbox, resp.Status)
+	}
+	return nil
+}
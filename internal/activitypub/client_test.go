@@ -0,0 +1,86 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchActor(t *testing.T) {
+	actor := Actor{
+		Id:                "https://remote.example/users/alice",
+		Type:              TypePerson,
+		PreferredUsername: "alice",
+		Inbox:             "https://remote.example/users/alice/inbox",
+		PublicKey: PublicKey{
+			Id:           "https://remote.example/users/alice#main-key",
+			PublicKeyPem: "-----BEGIN PUBLIC KEY-----",
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alice", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != ContentType {
+			t.Errorf("Accept = %q, want %q", r.Header.Get("Accept"), ContentType)
+		}
+		json.NewEncoder(w).Encode(actor)
+	})
+	mux.HandleFunc("/keyless", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Actor{Id: actor.Id, Inbox: actor.Inbox})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &Client{HTTP: srv.Client(), UserAgent: "test"}
+	ctx := context.Background()
+
+	got, err := client.FetchActor(ctx, srv.URL+"/alice")
+	if err != nil {
+		t.Fatalf("FetchActor: %s", err)
+	}
+	if got.Id != actor.Id || got.PublicKey.Id != actor.PublicKey.Id {
+		t.Errorf("FetchActor = %+v, want %+v", got, actor)
+	}
+
+	_, err = client.FetchActor(ctx, srv.URL+"/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("FetchActor of a missing actor = %v, want %v", err, ErrNotFound)
+	}
+	_, err = client.FetchActor(ctx, srv.URL+"/keyless")
+	if err == nil {
+		t.Errorf("FetchActor accepted an actor without a public key")
+	}
+}
+
+func TestDeliverSignsRequest(t *testing.T) {
+	key := testKey(t)
+	keyId := "https://chirpy.example/ap/users/1#main-key"
+
+	received := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotKeyId, err := SignatureKeyId(r)
+		if err == nil && gotKeyId != keyId {
+			err = errors.New("wrong keyId " + gotKeyId)
+		}
+		if err == nil {
+			err = Verify(r, body, &key.PublicKey, time.Minute, time.Now())
+		}
+		received <- err
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	client := &Client{HTTP: srv.Client(), UserAgent: "test"}
+	err := client.Deliver(context.Background(), srv.URL+"/inbox", Activity{Type: TypeLike, Actor: "a"}, keyId, key)
+	if err != nil {
+		t.Fatalf("Deliver: %s", err)
+	}
+	if err := <-received; err != nil {
+		t.Errorf("inbox couldn't verify the delivery: %s", err)
+	}
+}
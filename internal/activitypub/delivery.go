@@ -0,0 +1,162 @@
+package activitypub
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const (
+	// MaxDeliveryAttempts is the number of times an activity is sent to an
+	// inbox before it is given up on
+	MaxDeliveryAttempts = 8
+	// BaseDeliveryBackoff is the wait before the first retry. Each retry
+	// waits twice as long as the one before, up to MaxDeliveryBackoff
+	BaseDeliveryBackoff = time.Minute
+	MaxDeliveryBackoff  = 6 * time.Hour
+
+	deliveryBatchSize = 50
+	deliveryWorkers   = 4
+)
+
+// DeliveryStore is the part of the database the deliverer needs
+type DeliveryStore interface {
+	GetDueActivityDeliveries(now time.Time, limit int) ([]jsonDB.ActivityDelivery, error)
+	RetryActivityDelivery(deliveryId int, errMsg string, nextAttemptAt time.Time) error
+	RemoveActivityDelivery(deliveryId int) error
+}
+
+// SignerFunc returns the key id and private key to sign a user's deliveries
+// with
+type SignerFunc func(userId int) (keyId string, key *rsa.PrivateKey, err error)
+
+// Deliverer sends queued activities to remote inboxes in the background, so
+// a slow or unreachable server never holds up the code that queued them.
+// Deliveries are kept in the database until they succeed, which lets them
+// survive restarts, and failed ones are retried with exponential backoff
+type Deliverer struct {
+	store  DeliveryStore
+	client *Client
+	signer SignerFunc
+	wake   chan struct{}
+}
+
+func NewDeliverer(store DeliveryStore, client *Client, signer SignerFunc) *Deliverer {
+	return &Deliverer{
+		store:  store,
+		client: client,
+		signer: signer,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Wake makes the delivery loop look for due deliveries right away
+func (d *Deliverer) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due activities whenever it is woken up and every
+// pollInterval, until ctx is cancelled
+func (d *Deliverer) Run(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			delivered, err := d.DeliverDue(ctx)
+			if err != nil {
+				log.Printf("Error delivering activities: %s", err)
+			}
+			if err != nil || delivered < deliveryBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue tries a batch of due deliveries once, a few at a time, and
+// returns how many were tried
+func (d *Deliverer) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.store.GetDueActivityDeliveries(time.Now().UTC(), deliveryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	queue := make(chan jsonDB.ActivityDelivery)
+	wg := sync.WaitGroup{}
+	for i := 0; i < deliveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range queue {
+				d.deliver(ctx, delivery)
+			}
+		}()
+	}
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- delivery
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	return len(due), nil
+}
+
+func (d *Deliverer) deliver(ctx context.Context, delivery jsonDB.ActivityDelivery) {
+	keyId, key, err := d.signer(delivery.UserId)
+	if err == nil {
+		err = d.client.Deliver(ctx, delivery.Inbox, json.RawMessage(delivery.Activity), keyId, key)
+	}
+	if err == nil {
+		err = d.store.RemoveActivityDelivery(delivery.Id)
+		if err != nil {
+			log.Printf("Error removing activity delivery %d: %s", delivery.Id, err)
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= MaxDeliveryAttempts {
+		log.Printf("Giving up on delivering activity %d to %s: %s", delivery.Id, delivery.Inbox, err)
+		err = d.store.RemoveActivityDelivery(delivery.Id)
+	} else {
+		err = d.store.RetryActivityDelivery(delivery.Id, err.Error(), time.Now().UTC().Add(DeliveryBackoff(attempts)))
+	}
+	if err != nil {
+		log.Printf("Error recording activity delivery %d: %s", delivery.Id, err)
+	}
+}
+
+// DeliveryBackoff returns how long to wait before retrying a delivery that
+// has failed attempts times
+func DeliveryBackoff(attempts int) time.Duration {
+	backoff := BaseDeliveryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= MaxDeliveryBackoff {
+			return MaxDeliveryBackoff
+		}
+	}
+	return backoff
+}
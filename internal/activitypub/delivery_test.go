@@ -0,0 +1,116 @@
+package activitypub
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+// laterStore makes every queued delivery due, so retries can be tested
+// without waiting out the backoff
+type laterStore struct {
+	*jsonDB.DB
+}
+
+func (s laterStore) GetDueActivityDeliveries(now time.Time, limit int) ([]jsonDB.ActivityDelivery, error) {
+	return s.DB.GetDueActivityDeliveries(now.Add(MaxDeliveryBackoff), limit)
+}
+
+func newTestDeliverer(t *testing.T, statuses ...int) (laterStore, *Deliverer, string, *int32) {
+	t.Helper()
+	db, err := jsonDB.NewDB(filepath.Join(t.TempDir(), "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	store := laterStore{db}
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	t.Cleanup(srv.Close)
+
+	key := testKey(t)
+	signer := func(userId int) (string, *rsa.PrivateKey, error) {
+		return "https://chirpy.example/ap/users/1#main-key", key, nil
+	}
+	d := NewDeliverer(store, &Client{HTTP: srv.Client()}, signer)
+	return store, d, srv.URL + "/inbox", &requests
+}
+
+func TestDeliveredActivityIsRemoved(t *testing.T) {
+	store, d, inbox, requests := newTestDeliverer(t, http.StatusAccepted)
+
+	_, err := store.EnqueueActivityDeliveries(1, []byte(`{"type":"Create"}`), []string{inbox, inbox + "2"})
+	if err != nil {
+		t.Fatalf("EnqueueActivityDeliveries: %s", err)
+	}
+	n, err := d.DeliverDue(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("DeliverDue = %d, %v, want 2", n, err)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("inbox got %d requests, want 2", got)
+	}
+	left, _ := store.GetDueActivityDeliveries(time.Now(), 10)
+	if len(left) != 0 {
+		t.Errorf("%d deliveries left after delivering", len(left))
+	}
+}
+
+func TestFailedActivityIsRetriedThenDropped(t *testing.T) {
+	store, d, inbox, requests := newTestDeliverer(t, http.StatusServiceUnavailable)
+
+	_, err := store.EnqueueActivityDeliveries(1, []byte(`{"type":"Create"}`), []string{inbox})
+	if err != nil {
+		t.Fatalf("EnqueueActivityDeliveries: %s", err)
+	}
+
+	before := time.Now().UTC()
+	d.DeliverDue(context.Background())
+	queued, _ := store.DB.GetDueActivityDeliveries(before.Add(MaxDeliveryBackoff), 10)
+	if len(queued) != 1 {
+		t.Fatalf("got %d queued deliveries, want 1", len(queued))
+	}
+	delivery := queued[0]
+	if delivery.Attempts != 1 || delivery.LastError == "" {
+		t.Errorf("delivery = %+v, want one failed attempt", delivery)
+	}
+	if delivery.NextAttemptAt.Before(before.Add(DeliveryBackoff(1))) {
+		t.Errorf("next attempt at %s, want after the backoff", delivery.NextAttemptAt)
+	}
+
+	for i := 0; i < MaxDeliveryAttempts+2; i++ {
+		d.DeliverDue(context.Background())
+	}
+	if got := atomic.LoadInt32(requests); got != MaxDeliveryAttempts {
+		t.Errorf("inbox got %d requests, want %d", got, MaxDeliveryAttempts)
+	}
+	left, _ := store.GetDueActivityDeliveries(time.Now(), 10)
+	if len(left) != 0 {
+		t.Errorf("%d deliveries left after giving up", len(left))
+	}
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	if got := DeliveryBackoff(1); got != BaseDeliveryBackoff {
+		t.Errorf("DeliveryBackoff(1) = %s, want %s", got, BaseDeliveryBackoff)
+	}
+	if got := DeliveryBackoff(3); got != 4*BaseDeliveryBackoff {
+		t.Errorf("DeliveryBackoff(3) = %s, want %s", got, 4*BaseDeliveryBackoff)
+	}
+	if got := DeliveryBackoff(100); got != MaxDeliveryBackoff {
+		t.Errorf("DeliveryBackoff(100) = %s, want %s", got, MaxDeliveryBackoff)
+	}
+}
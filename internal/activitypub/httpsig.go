@@ -0,0 +1,204 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("invalid http signature")
+var ErrSignatureExpired = errors.New("http signature date outside tolerance")
+var ErrDigestMismatch = errors.New("digest does not match body")
+
+// signedHeaders are the headers covered by the signatures we make, and the
+// ones we require in the signatures we verify
+var signedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// Digest returns the value of the Digest header for a body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Sign adds Date, Digest and Signature headers to a request, following the
+// HTTP Signatures draft used across the fediverse. keyId is the URL of the
+// public key, usually the actor id followed by #main-key
+func Sign(req *http.Request, body []byte, keyId string, key *rsa.PrivateKey, now time.Time) error {
+	req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", Digest(body))
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+
+	hashed := sha256.Sum256([]byte(signingString(req, signedHeaders)))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyId, strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// SignatureKeyId returns the keyId of a request's Signature header
+func SignatureKeyId(req *http.Request) (string, error) {
+	params, err := parseSignature(req.Header.Get("Signature"))
+	if err != nil {
+		return "", err
+	}
+	return params["keyId"], nil
+}
+
+// Verify checks the Signature header of a request against the public key
+// it names. The signature must cover the request target, host, date and
+// digest, the digest must match the body and the date must be within
+// tolerance of now
+func Verify(req *http.Request, body []byte, key *rsa.PublicKey, tolerance time.Duration, now time.Time) error {
+	params, err := parseSignature(req.Header.Get("Signature"))
+	if err != nil {
+		return err
+	}
+
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	covered := map[string]bool{}
+	for _, h := range headers {
+		covered[h] = true
+	}
+	for _, h := range signedHeaders {
+		if !covered[h] {
+			return fmt.Errorf("%w: %s is not signed", ErrInvalidSignature, h)
+		}
+	}
+
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%w: bad date", ErrInvalidSignature)
+	}
+	if now.Sub(date) > tolerance || date.Sub(now) > tolerance {
+		return ErrSignatureExpired
+	}
+
+	if req.Header.Get("Digest") != Digest(body) {
+		return ErrDigestMismatch
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	hashed := sha256.Sum256([]byte(signingString(req, headers)))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signingString builds the text that is signed from the listed headers
+func signingString(req *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+		case "host":
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			lines = append(lines, h+": "+strings.Join(req.Header.Values(h), ", "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseSignature splits a Signature header into its parameters
+func parseSignature(header string) (map[string]string, error) {
+	params := map[string]string{}
+	for header != "" {
+		key, rest, ok := strings.Cut(header, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return nil, ErrInvalidSignature
+		}
+		value, rest, ok := strings.Cut(rest[1:], `"`)
+		if !ok {
+			return nil, ErrInvalidSignature
+		}
+		params[strings.TrimSpace(key)] = value
+		header = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, ErrInvalidSignature
+	}
+	return params, nil
+}
+
+// GenerateKey returns a new RSA key pair as PEM, for an actor to sign its
+// deliveries with
+func GenerateKey() (privatePEM, publicPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	public := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicDER,
+	})
+
+	return string(private), string(public), nil
+}
+
+// ParsePrivateKey reads a PEM private key made by GenerateKey
+func ParsePrivateKey(privatePEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("no PEM data in private key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// ParsePublicKey reads a PEM public key as published in actor documents.
+// Both PKIX and PKCS #1 keys are accepted
+func ParsePublicKey(publicPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, errors.New("no PEM data in public key")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,136 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	privatePEM, publicPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	key, err := ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %s", err)
+	}
+	public, err := ParsePublicKey(publicPEM)
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %s", err)
+	}
+	if !public.Equal(&key.PublicKey) {
+		t.Fatalf("public key doesn't match private key")
+	}
+	return key
+}
+
+func signedRequest(t *testing.T, key *rsa.PrivateKey, body []byte, now time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "https://chirpy.example/ap/inbox", bytes.NewReader(body))
+	err := Sign(req, body, "https://remote.example/users/alice#main-key", key, now)
+	if err != nil {
+		t.Fatalf("Sign: %s", err)
+	}
+	return req
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	key := testKey(t)
+	body := []byte(`{"type":"Follow"}`)
+	now := time.Now()
+	req := signedRequest(t, key, body, now)
+
+	keyId, err := SignatureKeyId(req)
+	if err != nil || keyId != "https://remote.example/users/alice#main-key" {
+		t.Errorf("SignatureKeyId = %q, %v", keyId, err)
+	}
+	err = Verify(req, body, &key.PublicKey, 5*time.Minute, now)
+	if err != nil {
+		t.Errorf("Verify: %s", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key := testKey(t)
+	otherKey := testKey(t)
+	body := []byte(`{"type":"Follow"}`)
+	now := time.Now()
+	tolerance := 5 * time.Minute
+
+	tests := []struct {
+		name    string
+		modify  func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time)
+		wantErr error
+	}{
+		{
+			name: "digest mismatch",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				return []byte(`{"type":"Like"}`), &key.PublicKey, now
+			},
+			wantErr: ErrDigestMismatch,
+		},
+		{
+			name: "expired date",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				return body, &key.PublicKey, now.Add(tolerance + time.Minute)
+			},
+			wantErr: ErrSignatureExpired,
+		},
+		{
+			name: "date in the future",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				return body, &key.PublicKey, now.Add(-tolerance - time.Minute)
+			},
+			wantErr: ErrSignatureExpired,
+		},
+		{
+			name: "other key",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				return body, &otherKey.PublicKey, now
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "other target",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				req.URL.Path = "/ap/users/2/inbox"
+				return body, &key.PublicKey, now
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "digest not signed",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				sig := req.Header.Get("Signature")
+				req.Header.Set("Signature", strings.Replace(sig, " digest", "", 1))
+				return body, &key.PublicKey, now
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "no signature",
+			modify: func(req *http.Request) ([]byte, *rsa.PublicKey, time.Time) {
+				req.Header.Del("Signature")
+				return body, &key.PublicKey, now
+			},
+			wantErr: ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, key, body, now)
+			verifyBody, public, at := tt.modify(req)
+			err := Verify(req, verifyBody, public, tolerance, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
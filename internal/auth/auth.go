@@ -100,6 +100,20 @@ func GetApiKey(headers http.Header) (string, error) {
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (string, error) {
+	userIDString, _, err := ValidateToken(tokenString, tokenSecret, TokenTypeAccess)
+	return userIDString, err
+}
+
+// ValidateRefreshToken checks a refresh token and returns the ID of the user
+// it was issued to
+func ValidateRefreshToken(tokenString, tokenSecret string) (string, error) {
+	userIDString, _, err := ValidateToken(tokenString, tokenSecret, TokenTypeRefresh)
+	return userIDString, err
+}
+
+// ValidateToken checks a token of the given type and returns the ID of the
+// user it was issued to and when it was issued
+func ValidateToken(tokenString, tokenSecret string, tokenType TokenType) (string, time.Time, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -107,66 +121,47 @@ func ValidateJWT(tokenString, tokenSecret string) (string, error) {
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	expiresAt, err := token.Claims.GetExpirationTime()
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return "", errors.New("invalid issuer")
+	if issuer != string(tokenType) {
+		return "", time.Time{}, errors.New("invalid issuer")
 	}
 
 	if expiresAt.Before(time.Now().UTC()) {
-		return "", errors.New("JWT is expired")
+		return "", time.Time{}, errors.New("JWT is expired")
 	}
 
-	return userIDString, nil
-}
-
-func RefreshToken(tokenString, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+	issuedAt, err := token.Claims.GetIssuedAt()
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", err
+	if issuedAt == nil {
+		return "", time.Time{}, errors.New("JWT has no issue time")
 	}
 
-	expiresAt, err := token.Claims.GetExpirationTime()
-	if err != nil {
-		return "", err
-	}
+	return userIDString, issuedAt.Time, nil
+}
 
-	issuer, err := token.Claims.GetIssuer()
+func RefreshToken(tokenString, tokenSecret string) (string, error) {
+	userIDString, err := ValidateRefreshToken(tokenString, tokenSecret)
 	if err != nil {
 		return "", err
 	}
-	if issuer != string(TokenTypeRefresh) {
-		return "", errors.New("invalid issuer")
-	}
-
-	if expiresAt.Before(time.Now().UTC()) {
-		return "", errors.New("JWT is expired")
-	}
 
 	userID, err := strconv.Atoi(userIDString)
 	if err != nil {
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidApiKey = errors.New("invalid api key")
+var ErrInvalidSignature = errors.New("invalid signature")
+var ErrSignatureExpired = errors.New("signature timestamp outside tolerance")
+
+// ValidateApiKey checks the ApiKey authorization header against the expected
+// key. The comparison takes the same time however much of the key matches
+func ValidateApiKey(headers http.Header, expected string) error {
+	key, err := GetApiKey(headers)
+	if err != nil {
+		return err
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(key), []byte(expected)) != 1 {
+		return ErrInvalidApiKey
+	}
+	return nil
+}
+
+// SignPayload returns a signature header value of the form
+// "t=<unix time>,v1=<hex HMAC-SHA256>" for a payload. The timestamp is part
+// of the signed data so a captured request can't be replayed later
+func SignPayload(payload []byte, secret string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, computeSignature(payload, secret, timestamp))
+}
+
+// VerifySignature checks a signature header made by SignPayload. The
+// signature must have been made within tolerance of now
+func VerifySignature(header string, payload []byte, secret string, tolerance time.Duration, now time.Time) error {
+	timestamp := ""
+	signatures := []string{}
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := computeSignature(payload, secret, timestamp)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func computeSignature(payload []byte, secret, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
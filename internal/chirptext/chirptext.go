@@ -0,0 +1,123 @@
+// Package chirptext validates chirp bodies. Length is counted in
+// user-perceived characters (grapheme clusters), so an emoji with skin tone
+// or a letter with combining accents counts once, and links count as a fixed
+// number of characters however long they are
+package chirptext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// URLLength is the number of characters every link counts as, since links
+// are shortened when displayed
+const URLLength = 23
+
+// Codes of validation errors
+const (
+	CodeEmpty            = "empty"
+	CodeTooLong          = "too_long"
+	CodeInvalidEncoding  = "invalid_encoding"
+	CodeControlCharacter = "control_character"
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// ValidationError explains why a chirp body was rejected. Length and
+// MaxLength are filled in for every error so clients can show a counter
+type ValidationError struct {
+	Code      string
+	Message   string
+	Length    int
+	MaxLength int
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Normalize converts a body to NFC, so the same text typed on different
+// devices is stored the same way, turns Windows line endings into \n and
+// trims surrounding whitespace
+func Normalize(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	return strings.TrimSpace(norm.NFC.String(body))
+}
+
+// Length returns the number of characters a body counts as. Links count as
+// URLLength and everything else is counted in grapheme clusters
+func Length(body string) int {
+	length := 0
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		length += uniseg.GraphemeClusterCount(body[last:loc[0]]) + URLLength
+		last = loc[1]
+	}
+	return length + uniseg.GraphemeClusterCount(body[last:])
+}
+
+// Validate normalizes a body and checks that it has visible content, no
+// control characters and at most maxLength characters. It returns the
+// normalized body, or a *ValidationError
+func Validate(body string, maxLength int) (string, error) {
+	newError := func(code, message string, length int) error {
+		return &ValidationError{
+			Code:      code,
+			Message:   message,
+			Length:    length,
+			MaxLength: maxLength,
+		}
+	}
+
+	if !utf8.ValidString(body) {
+		return "", newError(CodeInvalidEncoding, "chirp is not valid UTF-8", 0)
+	}
+
+	body = Normalize(body)
+	length := Length(body)
+
+	for _, r := range body {
+		if isForbiddenControl(r) {
+			return "", newError(CodeControlCharacter, fmt.Sprintf("chirp contains the control character %U", r), length)
+		}
+	}
+	if !hasVisibleContent(body) {
+		return "", newError(CodeEmpty, "chirp is empty", length)
+	}
+	if length > maxLength {
+		return "", newError(CodeTooLong, fmt.Sprintf("chirp is too long: %d characters, the maximum is %d", length, maxLength), length)
+	}
+
+	return body, nil
+}
+
+// isForbiddenControl reports whether r is a control character other than a
+// line break or tab, or a bidirectional override that can make text display
+// differently from how it reads
+func isForbiddenControl(r rune) bool {
+	if r == '\n' || r == '\t' {
+		return false
+	}
+	if unicode.IsControl(r) {
+		return true
+	}
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// hasVisibleContent reports whether a body has anything besides whitespace,
+// invisible formatting characters like zero width spaces and the blank
+// characters often used to fake an empty message
+func hasVisibleContent(body string) bool {
+	for _, r := range body {
+		if !unicode.IsSpace(r) && !unicode.Is(unicode.Cf, r) && r != '\u2800' && r != '\u3164' {
+			return true
+		}
+	}
+	return false
+}
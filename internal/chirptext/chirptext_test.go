@@ -0,0 +1,109 @@
+package chirptext
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"ascii", "hello", 5},
+		{"multibyte letters", "h\u00e9llo w\u00f6rld", 11},
+		{"combining marks", "e\u0301e\u0301", 2},
+		{"zwj emoji", "\U0001F469\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466", 1},
+		{"skin tone", "\U0001F44D\U0001F3FD", 1},
+		{"flags", "\U0001F1F8\U0001F1EA\U0001F1F3\U0001F1F4", 2},
+		{"url", "https://example.com/a/very/long/path/that/keeps/going", URLLength},
+		{"url in text", "see http://x.io now", 4 + URLLength + 4},
+		{"two urls", "https://a.example https://b.example", 2*URLLength + 1},
+	}
+	for _, tt := range tests {
+		if got := Length(tt.body); got != tt.want {
+			t.Errorf("%s: Length(%q) = %d, want %d", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"nfc", "e\u0301", "\u00e9"},
+		{"crlf", "a\r\nb", "a\nb"},
+		{"trimmed", "  hello \n", "hello"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.body); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const maxLength = 10
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		wantCode string
+	}{
+		{"valid", "hello", "hello", ""},
+		{"normalized", " cafe\u0301 ", "caf\u00e9", ""},
+		{"at the limit", strings.Repeat("a", maxLength), strings.Repeat("a", maxLength), ""},
+		{"one over the limit", strings.Repeat("a", maxLength+1), "", CodeTooLong},
+		{"graphemes at the limit", strings.Repeat("\U0001F44D\U0001F3FD", maxLength), strings.Repeat("\U0001F44D\U0001F3FD", maxLength), ""},
+		{"graphemes one over the limit", strings.Repeat("\U0001F44D\U0001F3FD", maxLength+1), "", CodeTooLong},
+		{"combining marks at the limit", strings.Repeat("a\u0308\u0301", maxLength), strings.Repeat("\u00e4\u0301", maxLength), ""},
+		{"url over the limit", "https://example.com", "", CodeTooLong},
+		{"tab and newline allowed", "a\tb\nc", "a\tb\nc", ""},
+		{"empty", "", "", CodeEmpty},
+		{"only whitespace", " \n\t ", "", CodeEmpty},
+		{"only invisible characters", "\u200b\u2800\u3164", "", CodeEmpty},
+		{"null byte", "a\x00b", "", CodeControlCharacter},
+		{"escape", "a\x1b[31mb", "", CodeControlCharacter},
+		{"bidi override", "a\u202eb", "", CodeControlCharacter},
+		{"bidi isolate", "a\u2066b", "", CodeControlCharacter},
+		{"invalid utf-8", "a\xffb", "", CodeInvalidEncoding},
+	}
+	for _, tt := range tests {
+		got, err := Validate(tt.body, maxLength)
+		if tt.wantCode == "" {
+			if err != nil {
+				t.Errorf("%s: Validate(%q) failed: %s", tt.name, tt.body, err)
+			} else if got != tt.want {
+				t.Errorf("%s: Validate(%q) = %q, want %q", tt.name, tt.body, got, tt.want)
+			}
+			continue
+		}
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: Validate(%q) error = %v, want a *ValidationError", tt.name, tt.body, err)
+			continue
+		}
+		if validationErr.Code != tt.wantCode {
+			t.Errorf("%s: code = %q, want %q", tt.name, validationErr.Code, tt.wantCode)
+		}
+		if validationErr.MaxLength != maxLength {
+			t.Errorf("%s: MaxLength = %d, want %d", tt.name, validationErr.MaxLength, maxLength)
+		}
+	}
+}
+
+func TestValidateReportsLength(t *testing.T) {
+	_, err := Validate(strings.Repeat("\U0001F1F8\U0001F1EA", 12), 10)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Validate error = %v, want a *ValidationError", err)
+	}
+	if validationErr.Length != 12 {
+		t.Errorf("Length = %d, want 12", validationErr.Length)
+	}
+}
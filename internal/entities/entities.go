@@ -0,0 +1,103 @@
+// Package entities finds hashtags and mentions in chirp bodies
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+const maxHandleLength = 15
+
+// Hashtag is a #tag in a chirp. Start and End are offsets in code points
+// into the chirp body, End pointing just past the last character
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Mention is an @handle in a chirp. UserId is 0 until the handle has been
+// matched to a user
+type Mention struct {
+	Handle string `json:"handle"`
+	UserId int    `json:"user_id"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+type Entities struct {
+	Hashtags []Hashtag `json:"hashtags"`
+	Mentions []Mention `json:"mentions"`
+}
+
+// Extract returns the hashtags and mentions in text. A # or @ only starts an
+// entity at the beginning of the text or after a character that can't be
+// part of one, so e-mail addresses and URL fragments are skipped. Hashtags
+// are returned in lower case and must contain at least one letter
+func Extract(text string) Entities {
+	ents := Entities{
+		Hashtags: []Hashtag{},
+		Mentions: []Mention{},
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '@' {
+			continue
+		}
+		if i > 0 && (isTagRune(runes[i-1]) || runes[i-1] == '#' || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isTagRune(runes[end]) {
+			end++
+		}
+		if end == i+1 {
+			continue
+		}
+		word := string(runes[i+1 : end])
+
+		if runes[i] == '#' {
+			if strings.IndexFunc(word, unicode.IsLetter) == -1 {
+				continue
+			}
+			ents.Hashtags = append(ents.Hashtags, Hashtag{
+				Tag:   strings.ToLower(word),
+				Start: i,
+				End:   end,
+			})
+		} else {
+			if end-i-1 > maxHandleLength || !isHandle(word) {
+				continue
+			}
+			ents.Mentions = append(ents.Mentions, Mention{
+				Handle: word,
+				Start:  i,
+				End:    end,
+			})
+		}
+		i = end - 1
+	}
+
+	return ents
+}
+
+// NormalizeTag turns user input such as "#Go" into the form tags are
+// stored in
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isTagRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isHandle(word string) bool {
+	for _, r := range word {
+		if r != '_' && (r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
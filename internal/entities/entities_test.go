@@ -0,0 +1,81 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Hashtag
+	}{
+		{"#Go is fun", []Hashtag{{Tag: "go", Start: 0, End: 3}}},
+		{"fun: #go!", []Hashtag{{Tag: "go", Start: 5, End: 8}}},
+		{"(#go), #rust.", []Hashtag{{Tag: "go", Start: 1, End: 4}, {Tag: "rust", Start: 7, End: 12}}},
+		{"#snake_case", []Hashtag{{Tag: "snake_case", Start: 0, End: 11}}},
+		{"#Caf\u00e9", []Hashtag{{Tag: "caf\u00e9", Start: 0, End: 5}}},
+		{"#\u6771\u4eac", []Hashtag{{Tag: "\u6771\u4eac", Start: 0, End: 3}}},
+		{"#cafe\u0301", []Hashtag{{Tag: "cafe\u0301", Start: 0, End: 6}}},
+		{"#2024go", []Hashtag{{Tag: "2024go", Start: 0, End: 7}}},
+		{"#123", []Hashtag{}},
+		{"# alone", []Hashtag{}},
+		{"##go", []Hashtag{}},
+		{"page.html#section", []Hashtag{}},
+		{"C# and F#", []Hashtag{}},
+	}
+	for _, tt := range tests {
+		got := Extract(tt.text).Hashtags
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Extract(%q).Hashtags = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Mention
+	}{
+		{"@alice hi", []Mention{{Handle: "alice", Start: 0, End: 6}}},
+		{"hi @Alice_1!", []Mention{{Handle: "Alice_1", Start: 3, End: 11}}},
+		{"@alice's cat", []Mention{{Handle: "alice", Start: 0, End: 6}}},
+		{"cc @bob, @carol.", []Mention{{Handle: "bob", Start: 3, End: 7}, {Handle: "carol", Start: 9, End: 15}}},
+		{"mail alice@example.com", []Mention{}},
+		{"@@alice", []Mention{}},
+		{"@bj\u00f6rn", []Mention{}},
+		{"@abcdefghijklmnop", []Mention{}},
+		{"@abcdefghijklmno", []Mention{{Handle: "abcdefghijklmno", Start: 0, End: 16}}},
+	}
+	for _, tt := range tests {
+		got := Extract(tt.text).Mentions
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Extract(%q).Mentions = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractOffsetsCountCodePoints(t *testing.T) {
+	text := "\u00e9 \U0001F44D #tag @bob"
+	ents := Extract(text)
+	runes := []rune(text)
+
+	if len(ents.Hashtags) != 1 || len(ents.Mentions) != 1 {
+		t.Fatalf("Extract(%q) = %+v, want one hashtag and one mention", text, ents)
+	}
+	hashtag, mention := ents.Hashtags[0], ents.Mentions[0]
+	if hashtag.Start != 4 || string(runes[hashtag.Start:hashtag.End]) != "#tag" {
+		t.Errorf("hashtag at %d:%d, want the code points of #tag at 4", hashtag.Start, hashtag.End)
+	}
+	if mention.Start != 9 || string(runes[mention.Start:mention.End]) != "@bob" {
+		t.Errorf("mention at %d:%d, want the code points of @bob at 9", mention.Start, mention.End)
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	for input, want := range map[string]string{"#Go": "go", "Rust": "rust", "##x": "#x"} {
+		if got := NormalizeTag(input); got != want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package entitlements decides what each membership tier can do. Handlers
+// look up the entitlements of a user and check them instead of testing the
+// tier themselves, so the perks of a tier are all defined here
+package entitlements
+
+import "time"
+
+type Tier string
+
+const (
+	TierFree      Tier = "free"
+	TierChirpyRed Tier = "chirpy_red"
+)
+
+// Entitlements are the limits and features of a tier
+type Entitlements struct {
+	Tier                   Tier  `json:"tier"`
+	MaxChirpLength         int   `json:"max_chirp_length"`
+	CanEditChirps          bool  `json:"can_edit_chirps"`
+	ChirpsPerHour          int   `json:"chirps_per_hour"`
+	UploadsPerHour         int   `json:"uploads_per_hour"`
+	MaxAttachmentsPerChirp int   `json:"max_attachments_per_chirp"`
+	MaxUploadSize          int64 `json:"max_upload_size"`
+	MediaStorageQuota      int64 `json:"media_storage_quota"`
+}
+
+var tiers = map[Tier]Entitlements{
+	TierFree: {
+		Tier:                   TierFree,
+		MaxChirpLength:         140,
+		CanEditChirps:          true,
+		ChirpsPerHour:          30,
+		UploadsPerHour:         10,
+		MaxAttachmentsPerChirp: 4,
+		MaxUploadSize:          5 << 20,
+		MediaStorageQuota:      100 << 20,
+	},
+	TierChirpyRed: {
+		Tier:                   TierChirpyRed,
+		MaxChirpLength:         1000,
+		CanEditChirps:          true,
+		ChirpsPerHour:          300,
+		UploadsPerHour:         100,
+		MaxAttachmentsPerChirp: 4,
+		MaxUploadSize:          15 << 20,
+		MediaStorageQuota:      2 << 30,
+	},
+}
+
+// RateLimitWindow is the window ChirpsPerHour and UploadsPerHour are
+// counted over
+const RateLimitWindow = time.Hour
+
+// ForTier returns the entitlements of a tier. Unknown tiers get the free
+// tier
+func ForTier(tier Tier) Entitlements {
+	ent, ok := tiers[tier]
+	if !ok {
+		return tiers[TierFree]
+	}
+	return ent
+}
+
+// Free returns the entitlements of users without a membership, which also
+// apply to anonymous requests
+func Free() Entitlements {
+	return tiers[TierFree]
+}
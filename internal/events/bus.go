@@ -0,0 +1,208 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+// OutboxRetention is how long processed events are kept in the outbox
+const OutboxRetention = 24 * time.Hour
+
+// Store is the part of the database the bus needs
+type Store interface {
+	GetPendingOutboxEvents() ([]jsonDB.OutboxEvent, error)
+	MarkOutboxEventsDispatched(ids []int) error
+	MarkOutboxEventProcessed(id int, errMsg string) error
+	PruneOutbox(before time.Time) (int, error)
+}
+
+type handler func(Event) error
+
+// Bus delivers the events in the outbox to subscribers. Synchronous
+// subscribers run in the goroutine that recorded the event, right after the
+// write, so their effects are visible before the request that caused it is
+// answered. Asynchronous subscribers run in the background, after the
+// synchronous ones.
+//
+// Events are delivered at least once: subscribers may see an event again if
+// the server stops before the event is marked as seen. Synchronous
+// subscribers must not make writes that record events, since they run while
+// events are being dispatched
+type Bus struct {
+	store Store
+
+	mu    sync.RWMutex
+	sync  map[string][]handler
+	async map[string][]handler
+
+	dispatchMu sync.Mutex
+	processMu  sync.Mutex
+	wake       chan struct{}
+}
+
+func NewBus(store Store) *Bus {
+	return &Bus{
+		store: store,
+		sync:  map[string][]handler{},
+		async: map[string][]handler{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Subscribe adds a synchronous subscriber to events of type E
+func Subscribe[E Event](b *Bus, fn func(E) error) {
+	subscribe(b, b.sync, fn)
+}
+
+// SubscribeAsync adds an asynchronous subscriber to events of type E
+func SubscribeAsync[E Event](b *Bus, fn func(E) error) {
+	subscribe(b, b.async, fn)
+}
+
+func subscribe[E Event](b *Bus, handlers map[string][]handler, fn func(E) error) {
+	var zero E
+	name := zero.Name()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	handlers[name] = append(handlers[name], func(ev Event) error {
+		return fn(ev.(E))
+	})
+}
+
+// Dispatch runs the synchronous subscribers of every event they haven't
+// seen yet and wakes the background loop for the asynchronous ones. It is
+// called after every write that records events
+func (b *Bus) Dispatch() {
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+
+	pending, err := b.store.GetPendingOutboxEvents()
+	if err != nil {
+		log.Printf("Error loading outbox: %s", err)
+		return
+	}
+
+	dispatched := []int{}
+	for _, record := range pending {
+		if record.DispatchedAt != nil {
+			continue
+		}
+		dispatched = append(dispatched, record.Id)
+
+		ev, err := Decode(record)
+		if err != nil {
+			// the background loop records the error when it gets to it
+			continue
+		}
+		err = b.run(b.sync, ev)
+		if err != nil {
+			log.Printf("Error handling %s event %d: %s", record.Type, record.Id, err)
+		}
+	}
+	if len(dispatched) == 0 {
+		return
+	}
+
+	err = b.store.MarkOutboxEventsDispatched(dispatched)
+	if err != nil {
+		log.Printf("Error marking events as dispatched: %s", err)
+	}
+	b.Wake()
+}
+
+// Wake makes the background loop look for events right away
+func (b *Bus) Wake() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the asynchronous subscribers until ctx is cancelled. Every
+// pollInterval it also dispatches events left over from before a restart
+// and prunes old events from the outbox
+func (b *Bus) Run(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	b.Dispatch()
+	for {
+		b.process()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.wake:
+		case <-ticker.C:
+			b.Dispatch()
+			_, err := b.store.PruneOutbox(time.Now().Add(-OutboxRetention))
+			if err != nil {
+				log.Printf("Error pruning outbox: %s", err)
+			}
+		}
+	}
+}
+
+// process runs the asynchronous subscribers of dispatched events and marks
+// the events as processed
+func (b *Bus) process() {
+	b.processMu.Lock()
+	defer b.processMu.Unlock()
+
+	pending, err := b.store.GetPendingOutboxEvents()
+	if err != nil {
+		log.Printf("Error loading outbox: %s", err)
+		return
+	}
+
+	for _, record := range pending {
+		if record.DispatchedAt == nil {
+			// synchronous subscribers go first, and Dispatch wakes us
+			// once they're done
+			break
+		}
+
+		errMsg := ""
+		ev, err := Decode(record)
+		if err == nil {
+			err = b.run(b.async, ev)
+		}
+		if err != nil {
+			log.Printf("Error handling %s event %d: %s", record.Type, record.Id, err)
+			errMsg = err.Error()
+		}
+
+		err = b.store.MarkOutboxEventProcessed(record.Id, errMsg)
+		if err != nil {
+			log.Printf("Error marking event %d as processed: %s", record.Id, err)
+			return
+		}
+	}
+}
+
+// run calls every handler of an event, even when some fail, and returns
+// the errors of those that did
+func (b *Bus) run(handlers map[string][]handler, ev Event) error {
+	b.mu.RLock()
+	hs := handlers[ev.Name()]
+	b.mu.RUnlock()
+
+	errs := []error{}
+	for _, h := range hs {
+		err := h(ev)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d subscribers failed: %w", len(errs), len(hs), errors.Join(errs...))
+}
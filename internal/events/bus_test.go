@@ -0,0 +1,215 @@
+package events
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func newTestBus(t *testing.T) (*jsonDB.DB, *Bus) {
+	t.Helper()
+	db, err := jsonDB.NewDB(filepath.Join(t.TempDir(), "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	bus := NewBus(db)
+	db.OnEventsRecorded(bus.Dispatch)
+	return db, bus
+}
+
+func TestSynchronousSubscribersRunBeforeWriteReturns(t *testing.T) {
+	db, bus := newTestBus(t)
+
+	created := []int{}
+	Subscribe(bus, func(e ChirpCreated) error {
+		created = append(created, e.Chirp.Id)
+		return nil
+	})
+
+	chirp, err := db.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	if len(created) != 1 || created[0] != chirp.Id {
+		t.Errorf("subscriber saw %v, want [%d]", created, chirp.Id)
+	}
+
+	// dispatching again must not deliver the event twice
+	bus.Dispatch()
+	if len(created) != 1 {
+		t.Errorf("subscriber saw the event %d times", len(created))
+	}
+}
+
+func TestAsynchronousSubscribersRunInBackground(t *testing.T) {
+	db, bus := newTestBus(t)
+
+	var mu sync.Mutex
+	seen := map[string]int{}
+	done := make(chan struct{}, 10)
+	record := func(name string) {
+		mu.Lock()
+		seen[name]++
+		mu.Unlock()
+		done <- struct{}{}
+	}
+	SubscribeAsync(bus, func(e ChirpEdited) error {
+		record(e.Name())
+		return nil
+	})
+	SubscribeAsync(bus, func(e ChirpHidden) error {
+		record(e.Name())
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx, time.Hour)
+
+	chirp, err := db.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	_, err = db.EditChirp(chirp.Id, 1, jsonDB.ChirpEdit{Body: "hello again"}, time.Hour)
+	if err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	_, _, err = db.ReportChirp(chirp.Id, 2, jsonDB.ReasonSpam, "", 1)
+	if err != nil {
+		t.Fatalf("ReportChirp: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("asynchronous subscribers didn't run, saw %v", seen)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if seen[jsonDB.OutboxChirpEdited] != 1 || seen[jsonDB.OutboxChirpHidden] != 1 {
+		t.Errorf("subscribers saw %v, want one edit and one hide", seen)
+	}
+}
+
+func TestUserEventsLeaveOutCredentials(t *testing.T) {
+	db, bus := newTestBus(t)
+
+	var got UserCreated
+	Subscribe(bus, func(e UserCreated) error {
+		got = e
+		return nil
+	})
+
+	user, err := db.CreateUser("someone@example.com", "$2a$10$secrethash", "someone")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	if got.User.Id != user.Id || got.User.Handle != "someone" {
+		t.Errorf("event user = %+v, want user %d", got.User, user.Id)
+	}
+
+	pending, err := db.GetPendingOutboxEvents()
+	if err != nil {
+		t.Fatalf("GetPendingOutboxEvents: %s", err)
+	}
+	for _, ev := range pending {
+		if strings.Contains(string(ev.Payload), "secrethash") || strings.Contains(string(ev.Payload), "someone@example.com") {
+			t.Errorf("%s event payload includes credentials: %s", ev.Type, ev.Payload)
+		}
+	}
+}
+
+func TestTokenRevokedLeavesOutToken(t *testing.T) {
+	db, bus := newTestBus(t)
+
+	var got TokenRevoked
+	Subscribe(bus, func(e TokenRevoked) error {
+		got = e
+		return nil
+	})
+
+	err := db.RevokeToken("refresh-token", 7)
+	if err != nil {
+		t.Fatalf("RevokeToken: %s", err)
+	}
+	if got.Revocation.UserId != 7 || got.Revocation.Token != "" {
+		t.Errorf("revocation = %+v, want user 7 and no token", got.Revocation)
+	}
+	revoked, err := db.IsTokenRevoked("refresh-token")
+	if err != nil || !revoked {
+		t.Errorf("IsTokenRevoked = %t, %v, want true", revoked, err)
+	}
+}
+
+func TestEngagementsAndFollowsArePublishedOnce(t *testing.T) {
+	db, bus := newTestBus(t)
+	author, err := db.CreateUser("author@example.com", "hash", "author")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	fan, err := db.CreateUser("fan@example.com", "hash", "fan")
+	if err != nil {
+		t.Fatalf("CreateUser: %s", err)
+	}
+	chirp, err := db.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: author.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	likes := []jsonDB.EngagementEvent{}
+	Subscribe(bus, func(e ChirpLiked) error {
+		likes = append(likes, e.Engagement)
+		return nil
+	})
+	rechirps := []jsonDB.EngagementEvent{}
+	Subscribe(bus, func(e ChirpRechirped) error {
+		rechirps = append(rechirps, e.Engagement)
+		return nil
+	})
+	follows := []jsonDB.Follow{}
+	Subscribe(bus, func(e UserFollowed) error {
+		follows = append(follows, e.Follow)
+		return nil
+	})
+
+	// repeating an engagement or follow changes nothing and isn't published
+	for i := 0; i < 2; i++ {
+		_, err = db.AddEngagement(jsonDB.EngagementLike, chirp.Id, fan.Id)
+		if err != nil {
+			t.Fatalf("AddEngagement: %s", err)
+		}
+		_, err = db.AddEngagement(jsonDB.EngagementRechirp, chirp.Id, fan.Id)
+		if err != nil {
+			t.Fatalf("AddEngagement: %s", err)
+		}
+		_, err = db.FollowUser(fan.Id, author.Id)
+		if err != nil {
+			t.Fatalf("FollowUser: %s", err)
+		}
+	}
+
+	want := jsonDB.EngagementEvent{ChirpId: chirp.Id, AuthorId: author.Id, UserId: fan.Id}
+	if len(likes) != 1 || likes[0] != want {
+		t.Errorf("likes = %+v, want [%+v]", likes, want)
+	}
+	if len(rechirps) != 1 || rechirps[0] != want {
+		t.Errorf("rechirps = %+v, want [%+v]", rechirps, want)
+	}
+	if len(follows) != 1 || follows[0].FollowerId != fan.Id || follows[0].FolloweeId != author.Id {
+		t.Errorf("follows = %+v, want fan following author", follows)
+	}
+}
+
+func TestDecodeUnknownType(t *testing.T) {
+	_, err := Decode(jsonDB.OutboxEvent{Id: 1, Type: "chirp.exploded", Payload: []byte(`{}`)})
+	if err == nil {
+		t.Errorf("Decode accepted an unknown event type")
+	}
+}
@@ -0,0 +1,150 @@
+// Package events dispatches domain events to subscribers. Events are
+// recorded in the database outbox by the write that causes them, so handlers
+// don't need to know who reacts to a change, and no event is lost when the
+// server stops between the write and the subscribers running
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+// Event is a domain event. Name is the type recorded in the outbox
+type Event interface {
+	Name() string
+}
+
+// ChirpCreated is published when a chirp is posted
+type ChirpCreated struct {
+	Chirp jsonDB.Chirp
+}
+
+// ChirpDeleted is published when a chirp is deleted by its author, by a
+// moderator or with its author's account. Chirp is the chirp as it was
+// before it was deleted
+type ChirpDeleted struct {
+	Chirp jsonDB.Chirp
+}
+
+// ChirpEdited is published when the author of a chirp changes its body.
+// Chirp is the chirp after the edit
+type ChirpEdited struct {
+	Chirp jsonDB.Chirp
+}
+
+// ChirpHidden is published when a chirp is hidden, by a moderator or
+// automatically after enough reports
+type ChirpHidden struct {
+	Chirp jsonDB.Chirp
+}
+
+// ChirpRestored is published when moderators dismiss the reports against a
+// hidden chirp and it is shown again
+type ChirpRestored struct {
+	Chirp jsonDB.Chirp
+}
+
+// ChirpLiked is published when a user likes a chirp
+type ChirpLiked struct {
+	Engagement jsonDB.EngagementEvent
+}
+
+// ChirpRechirped is published when a user rechirps a chirp
+type ChirpRechirped struct {
+	Engagement jsonDB.EngagementEvent
+}
+
+// UserCreated is published when an account is created
+type UserCreated struct {
+	User jsonDB.EventUser
+}
+
+// UserUpgraded is published when a user becomes a Chirpy Red member
+type UserUpgraded struct {
+	User jsonDB.EventUser
+}
+
+// UserFollowed is published when a user starts following another
+type UserFollowed struct {
+	Follow jsonDB.Follow
+}
+
+// TokenRevoked is published when a refresh token is revoked. The revocation
+// doesn't include the token
+type TokenRevoked struct {
+	Revocation jsonDB.Revocation
+}
+
+func (ChirpCreated) Name() string   { return jsonDB.OutboxChirpCreated }
+func (ChirpDeleted) Name() string   { return jsonDB.OutboxChirpDeleted }
+func (ChirpEdited) Name() string    { return jsonDB.OutboxChirpEdited }
+func (ChirpHidden) Name() string    { return jsonDB.OutboxChirpHidden }
+func (ChirpRestored) Name() string  { return jsonDB.OutboxChirpRestored }
+func (ChirpLiked) Name() string     { return jsonDB.OutboxChirpLiked }
+func (ChirpRechirped) Name() string { return jsonDB.OutboxChirpRechirped }
+func (UserCreated) Name() string    { return jsonDB.OutboxUserCreated }
+func (UserUpgraded) Name() string   { return jsonDB.OutboxUserUpgraded }
+func (UserFollowed) Name() string   { return jsonDB.OutboxUserFollowed }
+func (TokenRevoked) Name() string   { return jsonDB.OutboxTokenRevoked }
+
+// Decode turns an outbox event back into a typed event
+func Decode(ev jsonDB.OutboxEvent) (Event, error) {
+	var event Event
+	var err error
+
+	switch ev.Type {
+	case jsonDB.OutboxChirpCreated:
+		e := ChirpCreated{}
+		err = json.Unmarshal(ev.Payload, &e.Chirp)
+		event = e
+	case jsonDB.OutboxChirpDeleted:
+		e := ChirpDeleted{}
+		err = json.Unmarshal(ev.Payload, &e.Chirp)
+		event = e
+	case jsonDB.OutboxChirpEdited:
+		e := ChirpEdited{}
+		err = json.Unmarshal(ev.Payload, &e.Chirp)
+		event = e
+	case jsonDB.OutboxChirpHidden:
+		e := ChirpHidden{}
+		err = json.Unmarshal(ev.Payload, &e.Chirp)
+		event = e
+	case jsonDB.OutboxChirpRestored:
+		e := ChirpRestored{}
+		err = json.Unmarshal(ev.Payload, &e.Chirp)
+		event = e
+	case jsonDB.OutboxChirpLiked:
+		e := ChirpLiked{}
+		err = json.Unmarshal(ev.Payload, &e.Engagement)
+		event = e
+	case jsonDB.OutboxChirpRechirped:
+		e := ChirpRechirped{}
+		err = json.Unmarshal(ev.Payload, &e.Engagement)
+		event = e
+	case jsonDB.OutboxUserCreated:
+		e := UserCreated{}
+		err = json.Unmarshal(ev.Payload, &e.User)
+		event = e
+	case jsonDB.OutboxUserUpgraded:
+		e := UserUpgraded{}
+		err = json.Unmarshal(ev.Payload, &e.User)
+		event = e
+	case jsonDB.OutboxUserFollowed:
+		e := UserFollowed{}
+		err = json.Unmarshal(ev.Payload, &e.Follow)
+		event = e
+	case jsonDB.OutboxTokenRevoked:
+		e := TokenRevoked{}
+		err = json.Unmarshal(ev.Payload, &e.Revocation)
+		event = e
+	default:
+		return nil, fmt.Errorf("unknown event type %q", ev.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s event %d: %w", ev.Type, ev.Id, err)
+	}
+
+	return event, nil
+}
@@ -0,0 +1,340 @@
+// Package feed renders lists of posts as Atom, RSS 2.0 and JSON Feed 1.1
+// documents for feed readers. Text is escaped by the encoders, so item
+// content can be anything a user wrote
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Content types of the formats
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+	JSONContentType = "application/feed+json; charset=utf-8"
+)
+
+// titleLength is the number of characters of an item's text used as its
+// title in formats that require one
+const titleLength = 80
+
+type Author struct {
+	Name   string
+	URL    string
+	Avatar string
+}
+
+type Attachment struct {
+	URL      string
+	MimeType string
+	Size     int64
+}
+
+// Item is one post. Id must be a unique, permanent URL or URI
+type Item struct {
+	Id          string
+	URL         string
+	Text        string
+	Published   time.Time
+	Updated     time.Time
+	Author      *Author
+	Tags        []string
+	Attachments []Attachment
+}
+
+// Feed is a list of items, newest first. FeedURL is where the feed itself
+// is served and HomeURL the page it is a feed of
+type Feed struct {
+	Title       string
+	Description string
+	HomeURL     string
+	FeedURL     string
+	Author      *Author
+	Updated     time.Time
+	Items       []Item
+}
+
+// title returns the start of a text on one line, for formats that need a
+// title for every item
+func title(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= titleLength {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:titleLength-1])) + "…"
+}
+
+// textHTML turns plain text into HTML that displays the same way
+func textHTML(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   *atomAuthor `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomAuthor    `xml:"author"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+func newAtomAuthor(author *Author) *atomAuthor {
+	if author == nil {
+		return nil
+	}
+	return &atomAuthor{Name: author.Name, URI: author.URL}
+}
+
+// Atom renders a feed as an Atom 1.0 document
+func Atom(f Feed) ([]byte, error) {
+	doc := atomFeed{
+		Id:       f.FeedURL,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.FeedURL},
+			{Rel: "alternate", Href: f.HomeURL},
+		},
+		Author:  newAtomAuthor(f.Author),
+		Entries: make([]atomEntry, 0, len(f.Items)),
+	}
+
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Id:        item.Id,
+			Title:     title(item.Text),
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: item.URL}},
+			Author:    newAtomAuthor(item.Author),
+			Content:   atomContent{Type: "text", Body: item.Text},
+		}
+		for _, attachment := range item.Attachments {
+			entry.Links = append(entry.Links, atomLink{
+				Rel:    "enclosure",
+				Type:   attachment.MimeType,
+				Href:   attachment.URL,
+				Length: attachment.Size,
+			})
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	return marshalXML(doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	SelfLink      rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Link        string        `xml:"link"`
+	Guid        rssGuid       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+// RSS renders a feed as an RSS 2.0 document. Items have no title, which RSS
+// allows, and their text is given as HTML in the description. RSS allows a
+// single enclosure per item, so only the first attachment is included
+func RSS(f Feed) ([]byte, error) {
+	description := f.Description
+	if description == "" {
+		description = f.Title
+	}
+
+	doc := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.HomeURL,
+			Description:   description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			SelfLink:      rssLink{Href: f.FeedURL, Rel: "self", Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(f.Items)),
+		},
+	}
+
+	for _, item := range f.Items {
+		ri := rssItem{
+			Link:        item.URL,
+			Guid:        rssGuid{IsPermaLink: item.Id == item.URL, Value: item.Id},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Description: textHTML(item.Text),
+			Categories:  item.Tags,
+		}
+		if len(item.Attachments) > 0 {
+			attachment := item.Attachments[0]
+			ri.Enclosure = &rssEnclosure{
+				URL:    attachment.URL,
+				Length: attachment.Size,
+				Type:   attachment.MimeType,
+			}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+
+	return marshalXML(doc)
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+	dat, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), dat...), nil
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Authors     []jsonAuthor   `json:"authors,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+type jsonFeedItem struct {
+	Id            string           `json:"id"`
+	URL           string           `json:"url"`
+	ContentText   string           `json:"content_text"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished time.Time        `json:"date_published"`
+	DateModified  time.Time        `json:"date_modified"`
+	Authors       []jsonAuthor     `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Attachments   []jsonAttachment `json:"attachments,omitempty"`
+}
+
+func newJSONAuthors(author *Author) []jsonAuthor {
+	if author == nil {
+		return nil
+	}
+	return []jsonAuthor{{Name: author.Name, URL: author.URL, Avatar: author.Avatar}}
+}
+
+// JSON renders a feed as a JSON Feed 1.1 document. Items have no title, as
+// the spec recommends for microblog posts
+func JSON(f Feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.HomeURL,
+		FeedURL:     f.FeedURL,
+		Description: f.Description,
+		Authors:     newJSONAuthors(f.Author),
+		Items:       make([]jsonFeedItem, 0, len(f.Items)),
+	}
+
+	for _, item := range f.Items {
+		ji := jsonFeedItem{
+			Id:            item.Id,
+			URL:           item.URL,
+			ContentText:   item.Text,
+			ContentHTML:   textHTML(item.Text),
+			DatePublished: item.Published.UTC(),
+			DateModified:  item.Updated.UTC(),
+			Authors:       newJSONAuthors(item.Author),
+			Tags:          item.Tags,
+		}
+		for _, attachment := range item.Attachments {
+			ji.Attachments = append(ji.Attachments, jsonAttachment{
+				URL:         attachment.URL,
+				MimeType:    attachment.MimeType,
+				SizeInBytes: attachment.Size,
+			})
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,155 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const hostileText = "<script>alert(1)</script> & ]]> \"quoted\"\nsecond line"
+
+func testFeed() Feed {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return Feed{
+		Title:   "Chirps & <friends>",
+		HomeURL: "https://chirpy.example/",
+		FeedURL: "https://chirpy.example/feed",
+		Updated: now,
+		Items: []Item{{
+			Id:        "https://chirpy.example/chirps/1",
+			URL:       "https://chirpy.example/chirps/1",
+			Text:      hostileText,
+			Published: now,
+			Updated:   now,
+			Author:    &Author{Name: "<b>alice</b>"},
+			Tags:      []string{"a&b"},
+		}},
+	}
+}
+
+// checkWellFormedXML reads every token of a document, which fails on
+// markup that isn't well-formed
+func checkWellFormedXML(t *testing.T, doc []byte) {
+	t.Helper()
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("document isn't well-formed: %s\n%s", err, doc)
+		}
+	}
+}
+
+func TestAtomEscapesText(t *testing.T) {
+	doc, err := Atom(testFeed())
+	if err != nil {
+		t.Fatalf("Atom: %s", err)
+	}
+	checkWellFormedXML(t, doc)
+	if bytes.Contains(doc, []byte("<script>")) {
+		t.Errorf("document contains unescaped markup:\n%s", doc)
+	}
+
+	parsed := atomFeed{}
+	err = xml.Unmarshal(doc, &parsed)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if len(parsed.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(parsed.Entries))
+	}
+	entry := parsed.Entries[0]
+	if entry.Content.Type != "text" || entry.Content.Body != hostileText {
+		t.Errorf("content = %q of type %q, want the text as is", entry.Content.Body, entry.Content.Type)
+	}
+	if entry.Author == nil || entry.Author.Name != "<b>alice</b>" {
+		t.Errorf("author = %+v, want the name as is", entry.Author)
+	}
+}
+
+func TestRSSEscapesText(t *testing.T) {
+	doc, err := RSS(testFeed())
+	if err != nil {
+		t.Fatalf("RSS: %s", err)
+	}
+	checkWellFormedXML(t, doc)
+	if bytes.Contains(doc, []byte("<script>")) {
+		t.Errorf("document contains unescaped markup:\n%s", doc)
+	}
+
+	parsed := rssDocument{}
+	err = xml.Unmarshal(doc, &parsed)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if len(parsed.Channel.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(parsed.Channel.Items))
+	}
+	// the description is HTML, so once the XML is decoded the text must
+	// still be escaped for the reader's HTML renderer
+	description := parsed.Channel.Items[0].Description
+	want := "&lt;script&gt;alert(1)&lt;/script&gt; &amp; ]]&gt; &#34;quoted&#34;<br>second line"
+	if description != want {
+		t.Errorf("description = %q, want %q", description, want)
+	}
+}
+
+func TestJSONEscapesText(t *testing.T) {
+	doc, err := JSON(testFeed())
+	if err != nil {
+		t.Fatalf("JSON: %s", err)
+	}
+	if !json.Valid(doc) {
+		t.Fatalf("document isn't valid JSON:\n%s", doc)
+	}
+
+	parsed := jsonFeed{}
+	err = json.Unmarshal(doc, &parsed)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if len(parsed.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(parsed.Items))
+	}
+	item := parsed.Items[0]
+	if item.ContentText != hostileText {
+		t.Errorf("content_text = %q, want the text as is", item.ContentText)
+	}
+	if strings.Contains(item.ContentHTML, "<script>") {
+		t.Errorf("content_html contains unescaped markup: %q", item.ContentHTML)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"short", "hello world", "hello world"},
+		{"whitespace folded", "  hello\n\n\tworld  ", "hello world"},
+		{"at the limit", strings.Repeat("é", titleLength), strings.Repeat("é", titleLength)},
+		{"over the limit", strings.Repeat("é", titleLength+1), strings.Repeat("é", titleLength-1) + "…"},
+		{"trailing space trimmed", strings.Repeat("a", titleLength-2) + " bcd", strings.Repeat("a", titleLength-2) + "…"},
+	}
+	for _, tt := range tests {
+		got := title(tt.text)
+		if got != tt.want {
+			t.Errorf("%s: title = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: title %q isn't valid UTF-8", tt.name, got)
+		}
+		if n := utf8.RuneCountInString(got); n > titleLength {
+			t.Errorf("%s: title has %d characters, want at most %d", tt.name, n, titleLength)
+		}
+	}
+}
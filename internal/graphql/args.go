@@ -0,0 +1,47 @@
+package graphql
+
+import "fmt"
+
+// IntArg returns an Int argument. ok is false when the argument is null
+func IntArg(args map[string]interface{}, name string) (value int, ok bool, err error) {
+	switch v := args[name].(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		return v, true, nil
+	}
+	return 0, false, fmt.Errorf("argument %s must be an Int", name)
+}
+
+// StringArg returns a String argument. ok is false when the argument is
+// null
+func StringArg(args map[string]interface{}, name string) (value string, ok bool, err error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	}
+	return "", false, fmt.Errorf("argument %s must be a String", name)
+}
+
+// StringListArg returns a [String] argument
+func StringListArg(args map[string]interface{}, name string) ([]string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("argument %s must be a list of Strings", name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("argument %s must be a list of Strings", name)
+}
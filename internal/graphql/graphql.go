@@ -0,0 +1,585 @@
+// Package graphql is a small GraphQL engine. A schema is built from Go
+// values: object types whose fields have resolvers. Queries are validated
+// against the schema and limited in depth and complexity before they run.
+//
+// Fields are resolved one level at a time for every object on that level,
+// so a resolver can return a Thunk from a Loader and the lookups of all of
+// its siblings are made in one batch when the first thunk is forced.
+//
+// Introspection other than __typename and directives are not supported.
+// Argument and result types aren't checked by the engine; resolvers read
+// their arguments with the Arg helpers
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DefaultListSize is the number of items a list field is assumed to return
+// when working out the complexity of a query, unless it has a limit argument
+const DefaultListSize = 10
+
+// Schema is the root of a GraphQL API
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+	// MaxDepth limits how deeply fields can be nested and MaxComplexity
+	// the number of fields a query can resolve, counting the fields under a
+	// list once for each item it may return. Zero means no limit
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// Object is an object type. Fields can refer back to the object, so they
+// are usually filled in after every object has been created
+type Object struct {
+	Name   string
+	Fields map[string]*FieldDefinition
+}
+
+// FieldDefinition is a field of an object type
+type FieldDefinition struct {
+	// Type is the object type the field resolves to, or nil for scalars
+	// and lists of scalars
+	Type *Object
+	// List fields resolve to a slice of Type
+	List bool
+	// Args are the arguments the field takes, mapped to their default
+	// values. Arguments without a default map to nil
+	Args    map[string]interface{}
+	Resolve ResolveFunc
+}
+
+// ResolveParams are passed to a resolver. Source is the value the object
+// holding the field resolved to, and nil for root fields
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}
+	Args    map[string]interface{}
+}
+
+// ResolveFunc resolves a field to a value, which may be a Thunk. Objects
+// resolve to nil when they don't exist and lists to a slice
+type ResolveFunc func(p ResolveParams) (interface{}, error)
+
+// Thunk is a value that is computed later, in a batch with others
+type Thunk func() (interface{}, error)
+
+// Request is a GraphQL request as sent over HTTP. ReadOnly requests can't
+// run mutations, which is used for requests made with GET
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	ReadOnly      bool                   `json:"-"`
+}
+
+// Response is the result of a request. Data is nil when the request was
+// rejected before it could run
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Error is an error in a response. Path leads to the field that failed
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Execute runs a request against the schema
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	operation, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if req.ReadOnly && operation.Type != "query" {
+		return errorResponse(fmt.Errorf("%ss can't be sent with GET", operation.Type))
+	}
+
+	root := s.Query
+	switch operation.Type {
+	case "mutation":
+		root = s.Mutation
+	case "subscription":
+		root = nil
+	}
+	if root == nil {
+		return errorResponse(fmt.Errorf("%ss are not supported", operation.Type))
+	}
+
+	variables, err := coerceVariables(operation.Variables, req.Variables)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	v := validator{doc: doc, variables: variables}
+	depth, complexity, err := v.selections(root, operation.Selections, 1, map[string]bool{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return errorResponse(fmt.Errorf("query depth %d exceeds the limit of %d", depth, s.MaxDepth))
+	}
+	if s.MaxComplexity > 0 && complexity > s.MaxComplexity {
+		return errorResponse(fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, s.MaxComplexity))
+	}
+
+	e := executor{ctx: ctx, doc: doc, variables: variables}
+	results := e.objects(root, []interface{}{nil}, [][]interface{}{{}}, operation.Selections)
+	return Response{Data: results[0], Errors: e.errors}
+}
+
+func errorResponse(err error) Response {
+	return Response{Errors: []Error{{Message: err.Error()}}}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, errors.New("operationName is required when the document has several operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, operation := range doc.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %s", name)
+}
+
+// coerceVariables checks the variables of a request against their
+// definitions and converts JSON numbers to the Go type of their scalar
+func coerceVariables(definitions []VariableDefinition, values map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, definition := range definitions {
+		value, ok := values[definition.Name]
+		if !ok && definition.HasDefault {
+			value, ok = definition.Default, true
+		}
+		if !ok || value == nil {
+			if strings.HasSuffix(definition.Type, "!") {
+				return nil, fmt.Errorf("variable $%s of type %s is required", definition.Name, definition.Type)
+			}
+			variables[definition.Name] = nil
+			continue
+		}
+
+		coerced, err := coerceValue(definition.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %w", definition.Name, err)
+		}
+		variables[definition.Name] = coerced
+	}
+	return variables, nil
+}
+
+func coerceValue(typ string, value interface{}) (interface{}, error) {
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		list, ok := value.([]interface{})
+		if !ok {
+			// A single value is accepted where a list is expected
+			list = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(list))
+		for i, item := range list {
+			if item == nil && strings.HasSuffix(inner, "!") {
+				return nil, fmt.Errorf("expected %s, got null", inner)
+			}
+			var err error
+			coerced[i], err = coerceValue(inner, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	}
+
+	switch typ {
+	case "Int":
+		switch n := value.(type) {
+		case int:
+			return n, nil
+		case float64:
+			if n == float64(int(n)) {
+				return int(n), nil
+			}
+		}
+	case "Float":
+		switch n := value.(type) {
+		case int:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		switch id := value.(type) {
+		case string:
+			return id, nil
+		case float64:
+			return fmt.Sprint(int(id)), nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %v", typ, value)
+}
+
+// resolveValue replaces the variables in an argument value with their
+// values
+func resolveValue(value interface{}, variables map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case Variable:
+		return variables[v.Name]
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved[i] = resolveValue(item, variables)
+		}
+		return resolved
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved[key] = resolveValue(item, variables)
+		}
+		return resolved
+	}
+	return value
+}
+
+// argumentValues returns the arguments of a field with defaults filled in
+func argumentValues(definition *FieldDefinition, field *Field, variables map[string]interface{}) map[string]interface{} {
+	args := make(map[string]interface{}, len(definition.Args))
+	for name, value := range definition.Args {
+		args[name] = value
+	}
+	for name, value := range field.Arguments {
+		resolved := resolveValue(value, variables)
+		if _, isVariable := value.(Variable); isVariable && resolved == nil && definition.Args[name] != nil {
+			continue
+		}
+		args[name] = resolved
+	}
+	return args
+}
+
+type validator struct {
+	doc       *Document
+	variables map[string]interface{}
+}
+
+// selections checks a selection set against an object type and returns its
+// depth and complexity. spreads holds the fragments being expanded, to
+// catch fragments that spread themselves
+func (v *validator) selections(obj *Object, selections []Selection, depth int, spreads map[string]bool) (int, int, error) {
+	maxDepth, complexity := 0, 0
+	for _, selection := range selections {
+		var fieldDepth, fieldComplexity int
+		var err error
+
+		switch s := selection.(type) {
+		case *Field:
+			fieldDepth, fieldComplexity, err = v.field(obj, s, depth, spreads)
+		case *FragmentSpread:
+			fragment, ok := v.doc.Fragments[s.Name]
+			if !ok {
+				return 0, 0, fmt.Errorf("unknown fragment %s", s.Name)
+			}
+			if spreads[s.Name] {
+				return 0, 0, fmt.Errorf("fragment %s spreads itself", s.Name)
+			}
+			if fragment.TypeCondition != obj.Name {
+				return 0, 0, fmt.Errorf("fragment %s on %s can't be spread on %s", s.Name, fragment.TypeCondition, obj.Name)
+			}
+			spreads[s.Name] = true
+			fieldDepth, fieldComplexity, err = v.selections(obj, fragment.Selections, depth, spreads)
+			delete(spreads, s.Name)
+		case *InlineFragment:
+			if s.TypeCondition != "" && s.TypeCondition != obj.Name {
+				return 0, 0, fmt.Errorf("fragment on %s can't be spread on %s", s.TypeCondition, obj.Name)
+			}
+			fieldDepth, fieldComplexity, err = v.selections(obj, s.Selections, depth, spreads)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if fieldDepth > maxDepth {
+			maxDepth = fieldDepth
+		}
+		complexity += fieldComplexity
+	}
+	return maxDepth, complexity, nil
+}
+
+func (v *validator) field(obj *Object, field *Field, depth int, spreads map[string]bool) (int, int, error) {
+	if field.Name == "__typename" {
+		if field.Selections != nil || field.Arguments != nil {
+			return 0, 0, errors.New("__typename takes no arguments or selections")
+		}
+		return depth, 1, nil
+	}
+
+	definition, ok := obj.Fields[field.Name]
+	if !ok {
+		return 0, 0, fmt.Errorf("type %s has no field %s", obj.Name, field.Name)
+	}
+	for name, value := range field.Arguments {
+		if _, ok := definition.Args[name]; !ok {
+			return 0, 0, fmt.Errorf("field %s.%s has no argument %s", obj.Name, field.Name, name)
+		}
+		err := v.checkVariables(value)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if definition.Type == nil {
+		if field.Selections != nil {
+			return 0, 0, fmt.Errorf("field %s.%s is a scalar and can't have selections", obj.Name, field.Name)
+		}
+		return depth, 1, nil
+	}
+	if field.Selections == nil {
+		return 0, 0, fmt.Errorf("field %s.%s of type %s needs selections", obj.Name, field.Name, definition.Type.Name)
+	}
+
+	childDepth, childComplexity, err := v.selections(definition.Type, field.Selections, depth+1, spreads)
+	if err != nil {
+		return 0, 0, err
+	}
+	if definition.List {
+		childComplexity *= listSize(argumentValues(definition, field, v.variables))
+	}
+	return childDepth, 1 + childComplexity, nil
+}
+
+func (v *validator) checkVariables(value interface{}) error {
+	switch val := value.(type) {
+	case Variable:
+		if _, ok := v.variables[val.Name]; !ok {
+			return fmt.Errorf("variable $%s is not defined", val.Name)
+		}
+	case []interface{}:
+		for _, item := range val {
+			err := v.checkVariables(item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range val {
+			err := v.checkVariables(item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listSize returns the number of items a list field may return, from its
+// limit argument
+func listSize(args map[string]interface{}) int {
+	if limit, ok := args["limit"].(int); ok && limit > 0 {
+		return limit
+	}
+	return DefaultListSize
+}
+
+type executor struct {
+	ctx       context.Context
+	doc       *Document
+	variables map[string]interface{}
+	errors    []Error
+}
+
+// object is a result object. Its keys are kept in the order they were
+// selected in
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		dat, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(dat)
+		buf.WriteByte(':')
+		dat, err = json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(dat)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// collectFields flattens the fragments of a selection set into its fields.
+// Fields selected more than once under the same key are merged
+func (e *executor) collectFields(selections []Selection, fields []*Field, byKey map[string]*Field) []*Field {
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *Field:
+			key := s.ResponseKey()
+			if existing, ok := byKey[key]; ok {
+				existing.Selections = append(existing.Selections, s.Selections...)
+				continue
+			}
+			merged := *s
+			merged.Selections = append([]Selection{}, s.Selections...)
+			byKey[key] = &merged
+			fields = append(fields, &merged)
+		case *FragmentSpread:
+			fields = e.collectFields(e.doc.Fragments[s.Name].Selections, fields, byKey)
+		case *InlineFragment:
+			fields = e.collectFields(s.Selections, fields, byKey)
+		}
+	}
+	return fields
+}
+
+// objects resolves a selection set on every source of a level at once.
+// Each field is resolved for all sources before any thunk is forced, so
+// loaders see all of their keys together
+func (e *executor) objects(obj *Object, sources []interface{}, paths [][]interface{}, selections []Selection) []*object {
+	results := make([]*object, len(sources))
+	for i := range results {
+		results[i] = &object{values: map[string]interface{}{}}
+	}
+
+	for _, field := range e.collectFields(selections, nil, map[string]*Field{}) {
+		key := field.ResponseKey()
+		if field.Name == "__typename" {
+			for i := range results {
+				results[i].set(key, obj.Name)
+			}
+			continue
+		}
+
+		definition := obj.Fields[field.Name]
+		args := argumentValues(definition, field, e.variables)
+
+		values := make([]interface{}, len(sources))
+		errs := make([]error, len(sources))
+		for i, source := range sources {
+			values[i], errs[i] = definition.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+		}
+		for i, value := range values {
+			if thunk, ok := value.(Thunk); ok && errs[i] == nil {
+				values[i], errs[i] = thunk()
+			}
+		}
+		for i, err := range errs {
+			if err != nil {
+				e.errors = append(e.errors, Error{Message: err.Error(), Path: appendPath(paths[i], key)})
+				values[i] = nil
+			}
+		}
+
+		if definition.Type == nil {
+			for i := range results {
+				results[i].set(key, values[i])
+			}
+			continue
+		}
+
+		// The objects of every source are resolved together on the next
+		// level, and put back in place afterwards
+		type slot struct {
+			result int
+			index  int
+		}
+		childSources := []interface{}{}
+		childPaths := [][]interface{}{}
+		slots := []slot{}
+		lists := make([][]interface{}, len(sources))
+		for i, value := range values {
+			results[i].set(key, nil)
+			if value == nil {
+				continue
+			}
+			path := appendPath(paths[i], key)
+			if !definition.List {
+				childSources = append(childSources, value)
+				childPaths = append(childPaths, path)
+				slots = append(slots, slot{result: i, index: -1})
+				continue
+			}
+
+			list := reflect.ValueOf(value)
+			if list.Kind() != reflect.Slice {
+				e.errors = append(e.errors, Error{Message: "list field resolved to a non-list value", Path: path})
+				continue
+			}
+			lists[i] = make([]interface{}, list.Len())
+			results[i].set(key, lists[i])
+			for j := 0; j < list.Len(); j++ {
+				childSources = append(childSources, list.Index(j).Interface())
+				childPaths = append(childPaths, appendPath(path, j))
+				slots = append(slots, slot{result: i, index: j})
+			}
+		}
+
+		children := e.objects(definition.Type, childSources, childPaths, field.Selections)
+		for n, child := range children {
+			s := slots[n]
+			if s.index < 0 {
+				results[s.result].set(key, child)
+			} else {
+				lists[s.result][s.index] = child
+			}
+		}
+	}
+
+	return results
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	extended := make([]interface{}, len(path), len(path)+1)
+	copy(extended, path)
+	return append(extended, elem)
+}
@@ -0,0 +1,184 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type testPost struct {
+	Id       int
+	AuthorId int
+}
+
+// newTestSchema returns a schema of posts and their authors. Authors are
+// looked up through a loader, and fetched records every batch it makes
+func newTestSchema(fetched *[][]int) *Schema {
+	user := &Object{Name: "User"}
+	post := &Object{Name: "Post"}
+	query := &Object{Name: "Query"}
+
+	var authors *Loader[int, string]
+	user.Fields = map[string]*FieldDefinition{
+		"name": {Resolve: func(p ResolveParams) (interface{}, error) { return p.Source, nil }},
+	}
+	post.Fields = map[string]*FieldDefinition{
+		"id": {Resolve: func(p ResolveParams) (interface{}, error) { return p.Source.(testPost).Id, nil }},
+		"author": {Type: user, Resolve: func(p ResolveParams) (interface{}, error) {
+			return authors.Load(p.Source.(testPost).AuthorId), nil
+		}},
+	}
+	query.Fields = map[string]*FieldDefinition{
+		"posts": {Type: post, List: true, Args: map[string]interface{}{"limit": nil}, Resolve: func(p ResolveParams) (interface{}, error) {
+			authors = NewLoader(func(keys []int) (map[int]string, error) {
+				*fetched = append(*fetched, keys)
+				names := map[int]string{}
+				for _, key := range keys {
+					names[key] = "user" + string(rune('0'+key))
+				}
+				return names, nil
+			})
+			limit, ok, err := IntArg(p.Args, "limit")
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				limit = DefaultListSize
+			}
+			posts := []testPost{}
+			for i := 1; i <= limit; i++ {
+				posts = append(posts, testPost{Id: i, AuthorId: i % 3})
+			}
+			return posts, nil
+		}},
+	}
+	return &Schema{Query: query, MaxDepth: 3, MaxComplexity: 50}
+}
+
+func execute(t *testing.T, schema *Schema, req Request) (string, []Error) {
+	t.Helper()
+	resp := schema.Execute(context.Background(), req)
+	dat, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	return string(dat), resp.Errors
+}
+
+func TestExecuteBatchesLoads(t *testing.T) {
+	fetched := [][]int{}
+	schema := newTestSchema(&fetched)
+
+	data, errs := execute(t, schema, Request{Query: `{ posts(limit: 4) { id author { name } } }`})
+	if len(errs) != 0 {
+		t.Fatalf("errors: %+v", errs)
+	}
+	want := `{"posts":[{"id":1,"author":{"name":"user1"}},{"id":2,"author":{"name":"user2"}},` +
+		`{"id":3,"author":{"name":"user0"}},{"id":4,"author":{"name":"user1"}}]}`
+	if data != want {
+		t.Errorf("data = %s, want %s", data, want)
+	}
+	if len(fetched) != 1 || len(fetched[0]) != 3 {
+		t.Errorf("author fetches = %v, want one fetch of 3 keys", fetched)
+	}
+}
+
+func TestExecuteVariablesAndFragments(t *testing.T) {
+	fetched := [][]int{}
+	schema := newTestSchema(&fetched)
+
+	data, errs := execute(t, schema, Request{
+		Query: `query Posts($n: Int!) { posts(limit: $n) { ...PostFields } }
+			fragment PostFields on Post { postId: id __typename }`,
+		Variables: map[string]interface{}{"n": float64(2)},
+	})
+	if len(errs) != 0 {
+		t.Fatalf("errors: %+v", errs)
+	}
+	want := `{"posts":[{"postId":1,"__typename":"Post"},{"postId":2,"__typename":"Post"}]}`
+	if data != want {
+		t.Errorf("data = %s, want %s", data, want)
+	}
+}
+
+func TestExecuteLimits(t *testing.T) {
+	schema := newTestSchema(&[][]int{})
+
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr string
+	}{
+		{
+			name:    "too complex",
+			req:     Request{Query: `{ posts(limit: 20) { id author { name } } }`},
+			wantErr: "query complexity 61 exceeds the limit of 50",
+		},
+		{
+			name:    "default list size counts",
+			req:     Request{Query: `{ posts { id author { name } } }`},
+			wantErr: "",
+		},
+		{
+			name:    "complexity from variables",
+			req:     Request{Query: `query ($n: Int) { posts(limit: $n) { id } }`, Variables: map[string]interface{}{"n": float64(100)}},
+			wantErr: "query complexity 101 exceeds the limit of 50",
+		},
+		{
+			name:    "self spreading fragment",
+			req:     Request{Query: `{ posts { ...F } } fragment F on Post { id ...F }`},
+			wantErr: "fragment F spreads itself",
+		},
+		{
+			name:    "unknown field",
+			req:     Request{Query: `{ posts { title } }`},
+			wantErr: "type Post has no field title",
+		},
+		{
+			name:    "undefined variable",
+			req:     Request{Query: `{ posts(limit: $n) { id } }`},
+			wantErr: "variable $n is not defined",
+		},
+		{
+			name:    "mutation with GET",
+			req:     Request{Query: `mutation { posts { id } }`, ReadOnly: true},
+			wantErr: "mutations can't be sent with GET",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := execute(t, schema, tt.req)
+			if tt.wantErr == "" {
+				if len(errs) != 0 {
+					t.Errorf("errors: %+v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Message, tt.wantErr) {
+				t.Errorf("errors = %+v, want %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteRejectsDeepQueries(t *testing.T) {
+	user := &Object{Name: "User"}
+	user.Fields = map[string]*FieldDefinition{
+		"name":   {Resolve: func(p ResolveParams) (interface{}, error) { return "name", nil }},
+		"friend": {Type: user, Resolve: func(p ResolveParams) (interface{}, error) { return "friend", nil }},
+	}
+	query := &Object{Name: "Query", Fields: map[string]*FieldDefinition{
+		"me": {Type: user, Resolve: func(p ResolveParams) (interface{}, error) { return "me", nil }},
+	}}
+	schema := &Schema{Query: query, MaxDepth: 3}
+
+	_, errs := execute(t, schema, Request{Query: `{ me { friend { name } } }`})
+	if len(errs) != 0 {
+		t.Errorf("errors: %+v", errs)
+	}
+	_, errs = execute(t, schema, Request{Query: `{ me { friend { friend { name } } } }`})
+	if len(errs) != 1 || errs[0].Message != "query depth 4 exceeds the limit of 3" {
+		t.Errorf("errors = %+v, want a depth error", errs)
+	}
+}
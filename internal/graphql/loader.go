@@ -0,0 +1,75 @@
+package graphql
+
+import "sync"
+
+// Loader batches and caches lookups by key, so resolvers can look up one
+// value each without making a query per object. It is meant to live for a
+// single request
+type Loader[K comparable, V any] struct {
+	mu      sync.Mutex
+	fetch   func(keys []K) (map[K]V, error)
+	pending []K
+	queued  map[K]bool
+	loaded  map[K]bool
+	values  map[K]V
+	errs    map[K]error
+}
+
+// NewLoader returns a loader that looks values up with fetch. Keys missing
+// from the map fetch returns resolve to nil
+func NewLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:  fetch,
+		queued: map[K]bool{},
+		loaded: map[K]bool{},
+		values: map[K]V{},
+		errs:   map[K]error{},
+	}
+}
+
+// Load queues a key and returns a thunk for its value. Forcing any of the
+// thunks fetches every queued key in one call
+func (l *Loader[K, V]) Load(key K) Thunk {
+	l.mu.Lock()
+	if !l.loaded[key] && !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if !l.loaded[key] {
+			l.dispatch()
+		}
+		if err, ok := l.errs[key]; ok {
+			return nil, err
+		}
+		value, ok := l.values[key]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	}
+}
+
+// dispatch fetches the queued keys. The lock must be held
+func (l *Loader[K, V]) dispatch() {
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.fetch(keys)
+	for _, key := range keys {
+		delete(l.queued, key)
+		l.loaded[key] = true
+		if err != nil {
+			l.errs[key] = err
+			continue
+		}
+		if value, ok := values[key]; ok {
+			l.values[key] = value
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package graphql
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestLoaderBatchesAndCaches(t *testing.T) {
+	batches := [][]int{}
+	loader := NewLoader(func(keys []int) (map[int]string, error) {
+		batches = append(batches, append([]int(nil), keys...))
+		values := map[int]string{}
+		for _, key := range keys {
+			if key != 3 {
+				values[key] = "user"
+			}
+		}
+		return values, nil
+	})
+
+	thunks := []Thunk{loader.Load(1), loader.Load(2), loader.Load(1), loader.Load(3)}
+	for _, thunk := range thunks {
+		_, err := thunk()
+		if err != nil {
+			t.Fatalf("thunk: %s", err)
+		}
+	}
+	if len(batches) != 1 {
+		t.Fatalf("got %d fetches, want 1: %v", len(batches), batches)
+	}
+	sort.Ints(batches[0])
+	if len(batches[0]) != 3 {
+		t.Errorf("fetched keys %v, want each key once", batches[0])
+	}
+
+	missing, err := thunks[3]()
+	if err != nil || missing != nil {
+		t.Errorf("missing key = %v, %v; want nil, nil", missing, err)
+	}
+
+	// loaded keys come from the cache
+	_, err = loader.Load(2)()
+	if err != nil {
+		t.Fatalf("thunk: %s", err)
+	}
+	if len(batches) != 1 {
+		t.Errorf("got %d fetches after loading a cached key, want 1", len(batches))
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	fetchErr := errors.New("database is down")
+	loader := NewLoader(func(keys []int) (map[int]string, error) {
+		return nil, fetchErr
+	})
+
+	first, second := loader.Load(1), loader.Load(2)
+	if _, err := first(); !errors.Is(err, fetchErr) {
+		t.Errorf("err = %v, want %v", err, fetchErr)
+	}
+	if _, err := second(); !errors.Is(err, fetchErr) {
+		t.Errorf("err = %v, want %v", err, fetchErr)
+	}
+}
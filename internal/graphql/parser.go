@@ -0,0 +1,686 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL request document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query or mutation
+type Operation struct {
+	Type       string
+	Name       string
+	Variables  []VariableDefinition
+	Selections []Selection
+}
+
+// VariableDefinition declares a variable of an operation. Type is written as
+// in the query, such as "[Int!]!"
+type VariableDefinition struct {
+	Name       string
+	Type       string
+	Default    interface{}
+	HasDefault bool
+}
+
+// Fragment is a named fragment definition
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Selections    []Selection
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment
+type Selection interface {
+	isSelection()
+}
+
+// Field selects a field, under its alias when it has one. Argument values
+// are Go values, with Variable standing in for variables
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Selections []Selection
+}
+
+type FragmentSpread struct {
+	Name string
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Selections    []Selection
+}
+
+func (*Field) isSelection()          {}
+func (*FragmentSpread) isSelection() {}
+func (*InlineFragment) isSelection() {}
+
+// ResponseKey is the key the field is returned under
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Variable is a reference to a variable in an argument value
+type Variable struct {
+	Name string
+}
+
+// SyntaxError is a query that couldn't be parsed
+type SyntaxError struct {
+	Message string
+	Offset  int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Offset, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	offset int
+}
+
+type lexer struct {
+	src    string
+	offset int
+}
+
+// next reads the next token. Whitespace, commas and comments are skipped
+func (l *lexer) next() (token, error) {
+	for l.offset < len(l.src) {
+		c := l.src[l.offset]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.offset++
+			continue
+		}
+		if c == '#' {
+			for l.offset < len(l.src) && l.src[l.offset] != '\n' && l.src[l.offset] != '\r' {
+				l.offset++
+			}
+			continue
+		}
+		if strings.HasPrefix(l.src[l.offset:], "\uFEFF") {
+			l.offset += len("\uFEFF")
+			continue
+		}
+		break
+	}
+
+	start := l.offset
+	if l.offset >= len(l.src) {
+		return token{kind: tokenEOF, offset: start}, nil
+	}
+
+	c := l.src[l.offset]
+	switch {
+	case strings.HasPrefix(l.src[l.offset:], "..."):
+		l.offset += 3
+		return token{kind: tokenPunctuator, value: "...", offset: start}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.offset++
+		return token{kind: tokenPunctuator, value: string(c), offset: start}, nil
+	case c == '_' || isLetter(c):
+		for l.offset < len(l.src) && (l.src[l.offset] == '_' || isLetter(l.src[l.offset]) || isDigit(l.src[l.offset])) {
+			l.offset++
+		}
+		return token{kind: tokenName, value: l.src[start:l.offset], offset: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.offset:])
+	return token{}, &SyntaxError{Message: fmt.Sprintf("unexpected character %q", r), Offset: start}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) digits() int {
+	start := l.offset
+	for l.offset < len(l.src) && isDigit(l.src[l.offset]) {
+		l.offset++
+	}
+	return l.offset - start
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.offset
+	kind := tokenInt
+	if l.src[l.offset] == '-' {
+		l.offset++
+	}
+	if l.digits() == 0 {
+		return token{}, &SyntaxError{Message: "invalid number", Offset: start}
+	}
+	if l.offset < len(l.src) && l.src[l.offset] == '.' {
+		kind = tokenFloat
+		l.offset++
+		if l.digits() == 0 {
+			return token{}, &SyntaxError{Message: "invalid number", Offset: start}
+		}
+	}
+	if l.offset < len(l.src) && (l.src[l.offset] == 'e' || l.src[l.offset] == 'E') {
+		kind = tokenFloat
+		l.offset++
+		if l.offset < len(l.src) && (l.src[l.offset] == '+' || l.src[l.offset] == '-') {
+			l.offset++
+		}
+		if l.digits() == 0 {
+			return token{}, &SyntaxError{Message: "invalid number", Offset: start}
+		}
+	}
+	return token{kind: kind, value: l.src[start:l.offset], offset: start}, nil
+}
+
+// string reads a string literal. Block strings are taken as they are,
+// without removing their indentation
+func (l *lexer) string() (token, error) {
+	start := l.offset
+	if strings.HasPrefix(l.src[l.offset:], `"""`) {
+		end := strings.Index(l.src[l.offset+3:], `"""`)
+		if end < 0 {
+			return token{}, &SyntaxError{Message: "unterminated string", Offset: start}
+		}
+		value := l.src[l.offset+3 : l.offset+3+end]
+		l.offset += end + 6
+		return token{kind: tokenString, value: value, offset: start}, nil
+	}
+
+	l.offset++
+	b := strings.Builder{}
+	for l.offset < len(l.src) {
+		c := l.src[l.offset]
+		switch {
+		case c == '"':
+			l.offset++
+			return token{kind: tokenString, value: b.String(), offset: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, &SyntaxError{Message: "unterminated string", Offset: start}
+		case c == '\\':
+			if l.offset+1 >= len(l.src) {
+				return token{}, &SyntaxError{Message: "unterminated string", Offset: start}
+			}
+			escape := l.src[l.offset+1]
+			l.offset += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.offset+4 > len(l.src) {
+					return token{}, &SyntaxError{Message: "invalid unicode escape", Offset: l.offset}
+				}
+				code, err := strconv.ParseUint(l.src[l.offset:l.offset+4], 16, 32)
+				if err != nil {
+					return token{}, &SyntaxError{Message: "invalid unicode escape", Offset: l.offset}
+				}
+				b.WriteRune(rune(code))
+				l.offset += 4
+			default:
+				return token{}, &SyntaxError{Message: fmt.Sprintf("invalid escape \\%c", escape), Offset: l.offset - 2}
+			}
+		default:
+			b.WriteByte(c)
+			l.offset++
+		}
+	}
+	return token{}, &SyntaxError{Message: "unterminated string", Offset: start}
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// Parse parses a GraphQL document. Directives and type system definitions
+// are not supported
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: &lexer{src: src}}
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: map[string]*Fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: selections})
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, &SyntaxError{Message: "duplicate fragment " + fragment.Name, Offset: p.tok.offset}
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.tok.kind == tokenName:
+			operation, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, &SyntaxError{Message: "document has no operations", Offset: 0}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return &SyntaxError{Message: "unexpected end of document", Offset: p.tok.offset}
+	}
+	if p.peek("@") {
+		return &SyntaxError{Message: "directives are not supported", Offset: p.tok.offset}
+	}
+	return &SyntaxError{Message: fmt.Sprintf("unexpected %q", p.tok.value), Offset: p.tok.offset}
+}
+
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	operation := &Operation{Type: p.tok.value}
+	if operation.Type != "query" && operation.Type != "mutation" && operation.Type != "subscription" {
+		return nil, p.unexpected()
+	}
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		operation.Name = p.tok.value
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek("(") {
+		operation.Variables, err = p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	operation.Selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+func (p *parser) variableDefinitions() ([]VariableDefinition, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := []VariableDefinition{}
+	for !p.peek(")") {
+		err = p.expect("$")
+		if err != nil {
+			return nil, err
+		}
+		definition := VariableDefinition{}
+		definition.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+		definition.Type, err = p.typeReference()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek("=") {
+			err = p.advance()
+			if err != nil {
+				return nil, err
+			}
+			definition.Default, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+			definition.HasDefault = true
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, p.advance()
+}
+
+func (p *parser) typeReference() (string, error) {
+	var typ string
+	if p.peek("[") {
+		err := p.advance()
+		if err != nil {
+			return "", err
+		}
+		inner, err := p.typeReference()
+		if err != nil {
+			return "", err
+		}
+		err = p.expect("]")
+		if err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+
+	if p.peek("!") {
+		typ += "!"
+		return typ, p.advance()
+	}
+	return typ, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment := &Fragment{}
+	fragment.Name, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+	if fragment.Name == "on" {
+		return nil, &SyntaxError{Message: "fragment can't be named on", Offset: p.tok.offset}
+	}
+
+	if p.tok.kind != tokenName || p.tok.value != "on" {
+		return nil, p.unexpected()
+	}
+	err = p.advance()
+	if err != nil {
+		return nil, err
+	}
+	fragment.TypeCondition, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.Selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	selections := []Selection{}
+	for !p.peek("}") {
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, &SyntaxError{Message: "empty selection set", Offset: p.tok.offset}
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	if p.peek("...") {
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			return &FragmentSpread{Name: p.tok.value}, p.advance()
+		}
+
+		fragment := &InlineFragment{}
+		if p.tok.kind == tokenName {
+			err = p.advance()
+			if err != nil {
+				return nil, err
+			}
+			fragment.TypeCondition, err = p.name()
+			if err != nil {
+				return nil, err
+			}
+		}
+		fragment.Selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		return fragment, nil
+	}
+
+	field := &Field{}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+
+	if p.peek("(") {
+		field.Arguments, err = p.arguments()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek("{") {
+		field.Selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) arguments() (map[string]interface{}, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := map[string]interface{}{}
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := arguments[name]; ok {
+			return nil, &SyntaxError{Message: "duplicate argument " + name, Offset: p.tok.offset}
+		}
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+		arguments[name], err = p.value(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return arguments, p.advance()
+}
+
+// value parses an input value. Default values of variables must be
+// constant and can't refer to other variables
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenInt:
+		n, err := strconv.Atoi(tok.value)
+		if err != nil {
+			return nil, &SyntaxError{Message: "integer out of range", Offset: tok.offset}
+		}
+		return n, p.advance()
+	case tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, &SyntaxError{Message: "invalid number", Offset: tok.offset}
+		}
+		return f, p.advance()
+	case tokenString:
+		return tok.value, p.advance()
+	case tokenName:
+		err := p.advance()
+		switch tok.value {
+		case "true":
+			return true, err
+		case "false":
+			return false, err
+		case "null":
+			return nil, err
+		}
+		// Enum values are passed on as strings
+		return tok.value, err
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return Variable{Name: name}, nil
+	case p.peek("["):
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.peek("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		object := map[string]interface{}{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			object[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	}
+	return nil, p.unexpected()
+}
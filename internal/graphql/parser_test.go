@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(`
+		query Feed($limit: Int = 5, $tags: [String!]!) {
+			chirps(limit: $limit, tags: $tags, filter: {body: "hi", min: 1.5}) {
+				id
+				by: author { ...UserFields }
+				... on Chirp { body }
+			}
+		}
+		fragment UserFields on User { handle }
+	`)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+
+	if len(doc.Operations) != 1 {
+		t.Fatalf("got %d operations, want 1", len(doc.Operations))
+	}
+	op := doc.Operations[0]
+	if op.Type != "query" || op.Name != "Feed" {
+		t.Errorf("operation = %s %s, want query Feed", op.Type, op.Name)
+	}
+	wantVariables := []VariableDefinition{
+		{Name: "limit", Type: "Int", Default: 5, HasDefault: true},
+		{Name: "tags", Type: "[String!]!"},
+	}
+	if !reflect.DeepEqual(op.Variables, wantVariables) {
+		t.Errorf("variables = %+v, want %+v", op.Variables, wantVariables)
+	}
+
+	chirps := op.Selections[0].(*Field)
+	wantArgs := map[string]interface{}{
+		"limit":  Variable{Name: "limit"},
+		"tags":   Variable{Name: "tags"},
+		"filter": map[string]interface{}{"body": "hi", "min": 1.5},
+	}
+	if !reflect.DeepEqual(chirps.Arguments, wantArgs) {
+		t.Errorf("arguments = %#v, want %#v", chirps.Arguments, wantArgs)
+	}
+	if len(chirps.Selections) != 3 {
+		t.Fatalf("got %d selections, want 3", len(chirps.Selections))
+	}
+	author := chirps.Selections[1].(*Field)
+	if author.ResponseKey() != "by" || author.Name != "author" {
+		t.Errorf("aliased field = %+v", author)
+	}
+	if spread := author.Selections[0].(*FragmentSpread); spread.Name != "UserFields" {
+		t.Errorf("spread = %+v, want UserFields", spread)
+	}
+	if inline := chirps.Selections[2].(*InlineFragment); inline.TypeCondition != "Chirp" {
+		t.Errorf("inline fragment = %+v, want one on Chirp", inline)
+	}
+	if fragment := doc.Fragments["UserFields"]; fragment == nil || fragment.TypeCondition != "User" {
+		t.Errorf("fragment = %+v, want UserFields on User", fragment)
+	}
+}
+
+func TestParseShorthandQuery(t *testing.T) {
+	doc, err := Parse(`{ me { id } }`)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if op := doc.Operations[0]; op.Type != "query" || op.Name != "" {
+		t.Errorf("operation = %+v, want an anonymous query", op)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty document":     ``,
+		"unclosed selection": `{ me { id }`,
+		"missing argument":   `{ chirp(id: ) { id } }`,
+		"unterminated text":  `{ search(q: "hi) { id } }`,
+		"variable default":   `query ($a: Int = $b) { me { id } }`,
+		"duplicate fragment": `{ me { id } } fragment F on User { id } fragment F on User { id }`,
+		"integer overflow":   `{ chirps(limit: 99999999999999999999) { id } }`,
+	}
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(query)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("err = %v, want a syntax error", err)
+			}
+		})
+	}
+}
@@ -1,78 +1,303 @@
 package jsonDB
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entities"
+)
+
+// NewChirp holds the fields of a chirp to create. A non-zero InReplyTo makes
+// the chirp a reply to that chirp. FlaggedWords are the words that need a
+// moderator to review the chirp
+type NewChirp struct {
+	Body         string
+	AuthorId     int
+	InReplyTo    int
+	Entities     entities.Entities
+	MediaIds     []string
+	FlaggedWords []string
+}
 
 // CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, author_id int) (Chirp, error) {
-	ds, err := db.loadDB()
+func (db *DB) CreateChirp(params NewChirp) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		if params.InReplyTo != 0 {
+			parent, ok := ds.Chirps[params.InReplyTo]
+			if !ok || !parent.Visible() {
+				return ErrDoesNotExists
+			}
+			parent.ReplyCount++
+			ds.Chirps[params.InReplyTo] = parent
+		}
+
+		// IDs are never reused, so cursors into the chirp list stay valid
+		// when chirps are deleted
+		ds.LastChirpId++
+
+		now := time.Now().UTC()
+		chirp = Chirp{
+			Id:           ds.LastChirpId,
+			Body:         params.Body,
+			AuthorId:     params.AuthorId,
+			InReplyTo:    params.InReplyTo,
+			Entities:     params.Entities,
+			MediaIds:     params.MediaIds,
+			FlaggedWords: params.FlaggedWords,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+
+		err := ds.attachMedia(chirp.Id, chirp.AuthorId, chirp.MediaIds)
+		if err != nil {
+			return err
+		}
+
+		ds.Chirps[chirp.Id] = chirp
+		ds.flagChirp(chirp)
+		return ds.recordEvent(OutboxChirpCreated, chirp)
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	highestID := 0
-	for _, chirp := range ds.Chirps {
-		if chirp.Id > highestID {
-			highestID = chirp.Id
+	return chirp, nil
+}
+
+func (db *DB) DeleteChirp(chirp_id, user_id int) error {
+	return db.update(func(ds *DBStructure) error {
+		chirp, ok := ds.Chirps[chirp_id]
+		if !ok || chirp.Deleted {
+			return ErrDoesNotExists
+		}
+
+		if chirp.AuthorId != user_id {
+			return ErrNotAuthorized
+		}
+
+		return ds.deleteChirp(chirp_id)
+	})
+}
+
+// deleteChirp removes a chirp and its likes and rechirps. A chirp that still
+// has replies is kept as an empty tombstone so its thread stays intact, and
+// tombstones are removed once their last reply is gone
+func (ds *DBStructure) deleteChirp(chirpId int) error {
+	chirp, ok := ds.Chirps[chirpId]
+	if !ok {
+		return nil
+	}
+	if !chirp.Deleted {
+		err := ds.recordEvent(OutboxChirpDeleted, chirp)
+		if err != nil {
+			return err
 		}
 	}
 
-	chirp := Chirp{
-		Id:       highestID + 1,
-		Body:     body,
-		AuthorId: author_id,
+	ds.removeChirpEngagements(chirpId)
+	ds.removeChirpRemoteLikes(chirpId)
+	ds.removeChirpMedia(chirp)
+	delete(ds.Revisions, chirpId)
+	ds.closeModerationCase(chirpId)
+
+	if chirp.ReplyCount > 0 {
+		tombstone := chirp.tombstone()
+		tombstone.UpdatedAt = time.Now().UTC()
+		ds.Chirps[chirpId] = tombstone
+		return nil
 	}
 
-	ds.Chirps[chirp.Id] = chirp
+	delete(ds.Chirps, chirpId)
 
-	err = db.writeDB(ds)
+	parent, ok := ds.Chirps[chirp.InReplyTo]
+	if !ok {
+		return nil
+	}
+	parent.ReplyCount--
+	ds.Chirps[parent.Id] = parent
+	if parent.Deleted && parent.ReplyCount == 0 {
+		return ds.deleteChirp(parent.Id)
+	}
+	return nil
+}
+
+// tombstone returns the placeholder that stands in for a deleted chirp
+func (chirp Chirp) tombstone() Chirp {
+	return Chirp{
+		Id:         chirp.Id,
+		InReplyTo:  chirp.InReplyTo,
+		ReplyCount: chirp.ReplyCount,
+		Deleted:    true,
+		CreatedAt:  chirp.CreatedAt,
+		UpdatedAt:  chirp.UpdatedAt,
+	}
+}
+
+// tombstoneIfHidden hides the content of a chirp hidden by moderators
+func (chirp Chirp) tombstoneIfHidden() Chirp {
+	if chirp.Hidden {
+		return chirp.tombstone()
+	}
+	return chirp
+}
+
+// GetChirps returns all chirps in the database
+func (db *DB) GetChirps() ([]Chirp, error) {
+	ds, err := db.loadDB()
 	if err != nil {
-		return chirp, fmt.Errorf("failed to write to database: %s", err)
+		return nil, fmt.Errorf("error loading the database: %s", err)
 	}
 
-	return chirp, nil
+	chirps := make([]Chirp, 0, len(ds.Chirps))
+	for _, chirp := range ds.Chirps {
+		if !chirp.Visible() {
+			continue
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, nil
 }
 
-func (db *DB) DeleteChirp(chirp_id, user_id int) error {
+// ChirpQuery selects a page of chirps. Chirps are ordered by ID, which is
+// also the order in which they were created. Zero values don't filter, and
+// a zero Limit selects every matching chirp
+type ChirpQuery struct {
+	AuthorId   int
+	Hashtag    string
+	Since      time.Time
+	Until      time.Time
+	AfterId    int
+	Descending bool
+	Limit      int
+}
+
+// GetChirpsPage returns up to query.Limit chirps matching the query that
+// come after the chirp with ID query.AfterId. Chirps are visited in ID order
+// and the scan stops as soon as the page is full, so the full chirp list is
+// never collected or sorted
+func (db *DB) GetChirpsPage(query ChirpQuery) ([]Chirp, error) {
 	ds, err := db.loadDB()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error loading the database: %s", err)
 	}
 
-	_, ok := ds.Chirps[chirp_id]
-	if !ok {
-		return ErrDoesNotExists
+	step := 1
+	id := query.AfterId + 1
+	if query.Descending {
+		step = -1
+		id = ds.LastChirpId
+		if query.AfterId > 0 && query.AfterId <= id {
+			id = query.AfterId - 1
+		}
 	}
 
-	if ds.Chirps[chirp_id].AuthorId != user_id {
-		return ErrNotAuthorized
+	chirps := []Chirp{}
+	for ; id > 0 && id <= ds.LastChirpId && (query.Limit == 0 || len(chirps) < query.Limit); id += step {
+		chirp, ok := ds.Chirps[id]
+		if !ok || !chirp.Visible() {
+			continue
+		}
+		if query.AuthorId != 0 && chirp.AuthorId != query.AuthorId {
+			continue
+		}
+		if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+			continue
+		}
+		if query.Hashtag != "" && !chirp.HasHashtag(query.Hashtag) {
+			continue
+		}
+		chirps = append(chirps, chirp)
 	}
 
-	delete(ds.Chirps, chirp_id)
+	return chirps, nil
+}
+
+// Visible reports whether the chirp shows up in feeds, search and threads.
+// Deleted chirps and chirps hidden by moderators don't
+func (chirp Chirp) Visible() bool {
+	return !chirp.Deleted && !chirp.Hidden
+}
 
-	err = db.writeDB(ds)
+// HasHashtag reports whether the chirp is tagged with tag, which must be in
+// lower case
+func (chirp Chirp) HasHashtag(tag string) bool {
+	for _, hashtag := range chirp.Entities.Hashtags {
+		if hashtag.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// CountHashtags returns for each hashtag the number of chirps created since
+// the given time that use it
+func (db *DB) CountHashtags(since time.Time) (map[string]int, error) {
+	ds, err := db.loadDB()
 	if err != nil {
-		return fmt.Errorf("failed to write to database: %s", err)
+		return nil, fmt.Errorf("error loading the database: %s", err)
 	}
 
-	return nil
+	counts := map[string]int{}
+	for _, chirp := range ds.Chirps {
+		if !chirp.Visible() || chirp.CreatedAt.Before(since) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, hashtag := range chirp.Entities.Hashtags {
+			if !seen[hashtag.Tag] {
+				seen[hashtag.Tag] = true
+				counts[hashtag.Tag]++
+			}
+		}
+	}
+
+	return counts, nil
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps() ([]Chirp, error) {
+// GetChirpsByAuthor returns all chirps written by a specific user
+func (db *DB) GetChirpsByAuthor(authorId int) ([]Chirp, error) {
 	ds, err := db.loadDB()
 	if err != nil {
 		return nil, fmt.Errorf("error loading the database: %s", err)
 	}
 
-	chirps := make([]Chirp, 0, len(ds.Chirps))
+	chirps := []Chirp{}
 	for _, chirp := range ds.Chirps {
-		chirps = append(chirps, chirp)
+		if chirp.AuthorId == authorId && !chirp.Deleted {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// GetChirpsByIds returns the chirps with the given IDs keyed by ID. Deleted,
+// hidden and unknown chirps are left out
+func (db *DB) GetChirpsByIds(ids []int) (map[int]Chirp, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading the database: %s", err)
+	}
+
+	chirps := make(map[int]Chirp, len(ids))
+	for _, id := range ids {
+		chirp, ok := ds.Chirps[id]
+		if ok && chirp.Visible() {
+			chirps[id] = chirp
+		}
 	}
 
 	return chirps, nil
 }
 
-// GetChirp returns chirp with a specific ID
+// GetChirp returns chirp with a specific ID. Chirps hidden by moderators are
+// returned as well, callers decide who gets to see them
 func (db *DB) GetChirp(id int) (Chirp, error) {
 	ds, err := db.loadDB()
 	if err != nil {
@@ -80,9 +305,62 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	}
 
 	chirp, ok := ds.Chirps[id]
-	if !ok {
-		return Chirp{}, fmt.Errorf("chirp not found: %s", err)
+	if !ok || chirp.Deleted {
+		return Chirp{}, ErrDoesNotExists
 	}
 
 	return chirp, nil
 }
+
+// GetThread returns the conversation around a chirp: its ancestors starting
+// at the root of the thread, and every chirp replying to it directly or
+// indirectly, level by level and oldest first within a level. Deleted and
+// hidden ancestors and replies are included as tombstones
+func (db *DB) GetThread(id int) (chirp Chirp, ancestors []Chirp, descendants []Chirp, err error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, nil, nil, fmt.Errorf("error loading the database: %s", err)
+	}
+
+	chirp, ok := ds.Chirps[id]
+	if !ok || chirp.Deleted {
+		return Chirp{}, nil, nil, ErrDoesNotExists
+	}
+
+	ancestors = []Chirp{}
+	seen := map[int]bool{chirp.Id: true}
+	for parentId := chirp.InReplyTo; parentId != 0 && !seen[parentId]; {
+		parent, ok := ds.Chirps[parentId]
+		if !ok {
+			break
+		}
+		seen[parentId] = true
+		ancestors = append([]Chirp{parent.tombstoneIfHidden()}, ancestors...)
+		parentId = parent.InReplyTo
+	}
+
+	replies := map[int][]Chirp{}
+	for _, c := range ds.Chirps {
+		if c.InReplyTo != 0 {
+			replies[c.InReplyTo] = append(replies[c.InReplyTo], c)
+		}
+	}
+	for _, children := range replies {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Id < children[j].Id
+		})
+	}
+
+	descendants = []Chirp{}
+	queue := []int{chirp.Id}
+	for len(queue) > 0 {
+		parentId := queue[0]
+		queue = queue[1:]
+		for _, reply := range replies[parentId] {
+			descendants = append(descendants, reply.tombstoneIfHidden())
+			queue = append(queue, reply.Id)
+		}
+	}
+
+	return chirp, ancestors, descendants, nil
+}
@@ -0,0 +1,199 @@
+package jsonDB
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Engagement is a like or a rechirp of a chirp by a user
+type Engagement struct {
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EngagementEvent is recorded when a user likes or rechirps a chirp.
+// AuthorId is the author of the chirp
+type EngagementEvent struct {
+	ChirpId  int `json:"chirp_id"`
+	AuthorId int `json:"author_id"`
+	UserId   int `json:"user_id"`
+}
+
+type EngagementKind int
+
+const (
+	EngagementLike EngagementKind = iota
+	EngagementRechirp
+)
+
+func engagementKey(chirpId, userId int) string {
+	return fmt.Sprintf("%d:%d", chirpId, userId)
+}
+
+// engagements returns the map holding engagements of a kind together with
+// the counter on the chirp that tracks them
+func (ds *DBStructure) engagements(kind EngagementKind) (map[string]Engagement, func(*Chirp) *int) {
+	if kind == EngagementRechirp {
+		return ds.Rechirps, func(c *Chirp) *int { return &c.RechirpCount }
+	}
+	return ds.Likes, func(c *Chirp) *int { return &c.LikeCount }
+}
+
+// engagementEventType returns the outbox event recorded when an engagement
+// of a kind is added
+func engagementEventType(kind EngagementKind) string {
+	if kind == EngagementRechirp {
+		return OutboxChirpRechirped
+	}
+	return OutboxChirpLiked
+}
+
+// AddEngagement likes or rechirps a chirp on behalf of a user. Doing it
+// twice has no further effect
+func (db *DB) AddEngagement(kind EngagementKind, chirpId, userId int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		chirp, ok = ds.Chirps[chirpId]
+		if !ok || !chirp.Visible() {
+			return ErrDoesNotExists
+		}
+
+		engagements, counter := ds.engagements(kind)
+		key := engagementKey(chirpId, userId)
+		if _, ok := engagements[key]; ok {
+			return errNoChanges
+		}
+
+		engagements[key] = Engagement{
+			ChirpId:   chirpId,
+			UserId:    userId,
+			CreatedAt: time.Now().UTC(),
+		}
+		*counter(&chirp)++
+		ds.Chirps[chirpId] = chirp
+		return ds.recordEvent(engagementEventType(kind), EngagementEvent{
+			ChirpId:  chirpId,
+			AuthorId: chirp.AuthorId,
+			UserId:   userId,
+		})
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// RemoveEngagement takes back a like or rechirp. Removing one that doesn't
+// exist has no effect
+func (db *DB) RemoveEngagement(kind EngagementKind, chirpId, userId int) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		chirp, ok = ds.Chirps[chirpId]
+		if !ok || !chirp.Visible() {
+			return ErrDoesNotExists
+		}
+
+		engagements, counter := ds.engagements(kind)
+		key := engagementKey(chirpId, userId)
+		if _, ok := engagements[key]; !ok {
+			return errNoChanges
+		}
+
+		delete(engagements, key)
+		*counter(&chirp)--
+		ds.Chirps[chirpId] = chirp
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// GetEngagements returns the likes or rechirps of a chirp, newest first
+func (db *DB) GetEngagements(kind EngagementKind, chirpId int) ([]Engagement, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	if chirp, ok := ds.Chirps[chirpId]; !ok || !chirp.Visible() {
+		return nil, ErrDoesNotExists
+	}
+
+	all, _ := ds.engagements(kind)
+	engagements := []Engagement{}
+	for _, engagement := range all {
+		if engagement.ChirpId == chirpId {
+			engagements = append(engagements, engagement)
+		}
+	}
+
+	sort.Slice(engagements, func(i, j int) bool {
+		return engagements[i].CreatedAt.After(engagements[j].CreatedAt)
+	})
+
+	return engagements, nil
+}
+
+// GetUserEngagements reports which of the given chirps a user has liked and
+// which they have rechirped
+func (db *DB) GetUserEngagements(userId int, chirpIds []int) (liked map[int]bool, rechirped map[int]bool, err error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	liked = map[int]bool{}
+	rechirped = map[int]bool{}
+	for _, chirpId := range chirpIds {
+		key := engagementKey(chirpId, userId)
+		if _, ok := ds.Likes[key]; ok {
+			liked[chirpId] = true
+		}
+		if _, ok := ds.Rechirps[key]; ok {
+			rechirped[chirpId] = true
+		}
+	}
+
+	return liked, rechirped, nil
+}
+
+// removeChirpEngagements deletes all likes and rechirps of a chirp
+func (ds *DBStructure) removeChirpEngagements(chirpId int) {
+	for key, like := range ds.Likes {
+		if like.ChirpId == chirpId {
+			delete(ds.Likes, key)
+		}
+	}
+	for key, rechirp := range ds.Rechirps {
+		if rechirp.ChirpId == chirpId {
+			delete(ds.Rechirps, key)
+		}
+	}
+}
+
+// removeUserEngagements deletes all likes and rechirps made by a user and
+// updates the counters of the chirps involved
+func (ds *DBStructure) removeUserEngagements(userId int) {
+	for _, kind := range []EngagementKind{EngagementLike, EngagementRechirp} {
+		engagements, counter := ds.engagements(kind)
+		for key, engagement := range engagements {
+			if engagement.UserId != userId {
+				continue
+			}
+			delete(engagements, key)
+			chirp, ok := ds.Chirps[engagement.ChirpId]
+			if ok {
+				*counter(&chirp)--
+				ds.Chirps[engagement.ChirpId] = chirp
+			}
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package jsonDB
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ActorKey is the key pair a user signs ActivityPub deliveries with. Keys
+// are kept apart from users so they never end up in profiles or exports
+type ActorKey struct {
+	UserId        int       `json:"user_id"`
+	PrivateKeyPEM string    `json:"private_key_pem"`
+	PublicKeyPEM  string    `json:"public_key_pem"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// RemoteActor is a cached copy of an actor on another server
+type RemoteActor struct {
+	Id           string    `json:"id"`
+	Handle       string    `json:"handle"`
+	Inbox        string    `json:"inbox"`
+	SharedInbox  string    `json:"shared_inbox,omitempty"`
+	PublicKeyId  string    `json:"public_key_id"`
+	PublicKeyPEM string    `json:"public_key_pem"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// RemoteFollower is an actor on another server following a user. Inbox is
+// where the user's activities are delivered for it, its server's shared
+// inbox when it has one
+type RemoteFollower struct {
+	UserId           int       `json:"user_id"`
+	ActorId          string    `json:"actor_id"`
+	Inbox            string    `json:"inbox"`
+	FollowActivityId string    `json:"follow_activity_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// RemoteLike is a like of a chirp by an actor on another server. Remote
+// likes count towards the like count of the chirp
+type RemoteLike struct {
+	ChirpId    int       `json:"chirp_id"`
+	ActorId    string    `json:"actor_id"`
+	ActivityId string    `json:"activity_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ActivityDelivery is an activity queued for delivery to a remote inbox,
+// signed as the user who sent it. Deliveries are removed once they succeed
+// or run out of attempts
+type ActivityDelivery struct {
+	Id            int             `json:"id"`
+	UserId        int             `json:"user_id"`
+	Inbox         string          `json:"inbox"`
+	Activity      json.RawMessage `json:"activity"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func remoteKey(id int, actorId string) string {
+	return fmt.Sprintf("%d:%s", id, actorId)
+}
+
+// GetActorKey returns the key pair of a user
+func (db *DB) GetActorKey(userId int) (ActorKey, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return ActorKey{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	key, ok := ds.ActorKeys[userId]
+	if !ok {
+		return ActorKey{}, ErrDoesNotExists
+	}
+	return key, nil
+}
+
+// CreateActorKey saves the key pair of a user. If the user already has one
+// it is kept and returned instead, so two requests generating a key at the
+// same time end up using the same one
+func (db *DB) CreateActorKey(userId int, privateKeyPEM, publicKeyPEM string) (ActorKey, error) {
+	key := ActorKey{}
+	err := db.update(func(ds *DBStructure) error {
+		if _, ok := ds.Users[userId]; !ok {
+			return ErrDoesNotExists
+		}
+		if existing, ok := ds.ActorKeys[userId]; ok {
+			key = existing
+			return errNoChanges
+		}
+
+		key = ActorKey{
+			UserId:        userId,
+			PrivateKeyPEM: privateKeyPEM,
+			PublicKeyPEM:  publicKeyPEM,
+			CreatedAt:     time.Now().UTC(),
+		}
+		ds.ActorKeys[userId] = key
+		return nil
+	})
+	if err != nil {
+		return ActorKey{}, err
+	}
+
+	return key, nil
+}
+
+// GetRemoteActor returns the cached copy of a remote actor
+func (db *DB) GetRemoteActor(actorId string) (RemoteActor, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return RemoteActor{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	actor, ok := ds.RemoteActors[actorId]
+	if !ok {
+		return RemoteActor{}, ErrDoesNotExists
+	}
+	return actor, nil
+}
+
+// SaveRemoteActor caches a remote actor, replacing an older copy
+func (db *DB) SaveRemoteActor(actor RemoteActor) error {
+	return db.update(func(ds *DBStructure) error {
+		ds.RemoteActors[actor.Id] = actor
+		return nil
+	})
+}
+
+// AddRemoteFollower makes a remote actor follow a user. Following again
+// updates the inbox and the id of the follow activity
+func (db *DB) AddRemoteFollower(follower RemoteFollower) (RemoteFollower, error) {
+	err := db.update(func(ds *DBStructure) error {
+		user, ok := ds.Users[follower.UserId]
+		if !ok || user.DeletionScheduledAt != nil {
+			return ErrDoesNotExists
+		}
+
+		key := remoteKey(follower.UserId, follower.ActorId)
+		follower.CreatedAt = time.Now().UTC()
+		if existing, ok := ds.RemoteFollowers[key]; ok {
+			follower.CreatedAt = existing.CreatedAt
+		}
+		ds.RemoteFollowers[key] = follower
+		return nil
+	})
+	if err != nil {
+		return RemoteFollower{}, err
+	}
+
+	return follower, nil
+}
+
+// RemoveRemoteFollower ends a remote actor's follow of a user. The follow is
+// named by the user, or by the id of the follow activity when userId is 0.
+// Removing a follow that doesn't exist is not an error
+func (db *DB) RemoveRemoteFollower(actorId string, userId int, followActivityId string) error {
+	return db.update(func(ds *DBStructure) error {
+		removed := false
+		for key, follower := range ds.RemoteFollowers {
+			if follower.ActorId != actorId {
+				continue
+			}
+			if follower.UserId == userId || (userId == 0 && follower.FollowActivityId == followActivityId) {
+				delete(ds.RemoteFollowers, key)
+				removed = true
+			}
+		}
+		if !removed {
+			return errNoChanges
+		}
+		return nil
+	})
+}
+
+// GetRemoteFollowers returns the remote followers of a user, oldest first
+func (db *DB) GetRemoteFollowers(userId int) ([]RemoteFollower, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	followers := []RemoteFollower{}
+	for _, follower := range ds.RemoteFollowers {
+		if follower.UserId == userId {
+			followers = append(followers, follower)
+		}
+	}
+	sort.Slice(followers, func(i, j int) bool {
+		if followers[i].CreatedAt.Equal(followers[j].CreatedAt) {
+			return followers[i].ActorId < followers[j].ActorId
+		}
+		return followers[i].CreatedAt.Before(followers[j].CreatedAt)
+	})
+
+	return followers, nil
+}
+
+// AddRemoteLike records a remote actor's like of a chirp. Liking a chirp
+// twice has no further effect
+func (db *DB) AddRemoteLike(chirpId int, actorId, activityId string) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		chirp, ok = ds.Chirps[chirpId]
+		if !ok || !chirp.Visible() {
+			return ErrDoesNotExists
+		}
+
+		key := remoteKey(chirpId, actorId)
+		if _, ok := ds.RemoteLikes[key]; ok {
+			return errNoChanges
+		}
+
+		ds.RemoteLikes[key] = RemoteLike{
+			ChirpId:    chirpId,
+			ActorId:    actorId,
+			ActivityId: activityId,
+			CreatedAt:  time.Now().UTC(),
+		}
+		chirp.LikeCount++
+		ds.Chirps[chirpId] = chirp
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// RemoveRemoteLike takes back a remote actor's like. The like is named by
+// the chirp, or by the id of the like activity when chirpId is 0. Removing
+// a like that doesn't exist is not an error
+func (db *DB) RemoveRemoteLike(actorId string, chirpId int, activityId string) error {
+	return db.update(func(ds *DBStructure) error {
+		removed := false
+		for key, like := range ds.RemoteLikes {
+			if like.ActorId != actorId {
+				continue
+			}
+			if like.ChirpId != chirpId && (chirpId != 0 || like.ActivityId != activityId) {
+				continue
+			}
+			delete(ds.RemoteLikes, key)
+			removed = true
+			if chirp, ok := ds.Chirps[like.ChirpId]; ok && chirp.LikeCount > 0 {
+				chirp.LikeCount--
+				ds.Chirps[like.ChirpId] = chirp
+			}
+		}
+		if !removed {
+			return errNoChanges
+		}
+		return nil
+	})
+}
+
+// removeChirpRemoteLikes removes every remote like of a chirp
+func (ds *DBStructure) removeChirpRemoteLikes(chirpId int) {
+	for key, like := range ds.RemoteLikes {
+		if like.ChirpId == chirpId {
+			delete(ds.RemoteLikes, key)
+		}
+	}
+}
+
+// removeUserFederation removes the key pair, remote followers and queued
+// deliveries of a user
+func (ds *DBStructure) removeUserFederation(userId int) {
+	delete(ds.ActorKeys, userId)
+	for key, follower := range ds.RemoteFollowers {
+		if follower.UserId == userId {
+			delete(ds.RemoteFollowers, key)
+		}
+	}
+	for id, delivery := range ds.ActivityDeliveries {
+		if delivery.UserId == userId {
+			delete(ds.ActivityDeliveries, id)
+		}
+	}
+}
+
+// EnqueueActivityDeliveries queues an activity signed by a user for each
+// inbox. The deliveries are due right away
+func (db *DB) EnqueueActivityDeliveries(userId int, activity []byte, inboxes []string) ([]ActivityDelivery, error) {
+	deliveries := []ActivityDelivery{}
+	if len(inboxes) == 0 {
+		return deliveries, nil
+	}
+
+	err := db.update(func(ds *DBStructure) error {
+		now := time.Now().UTC()
+		for _, inbox := range inboxes {
+			ds.LastActivityDeliveryId++
+			delivery := ActivityDelivery{
+				Id:            ds.LastActivityDeliveryId,
+				UserId:        userId,
+				Inbox:         inbox,
+				Activity:      activity,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			ds.ActivityDeliveries[delivery.Id] = delivery
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// GetDueActivityDeliveries returns up to limit deliveries whose next attempt
+// is due, the longest waiting first
+func (db *DB) GetDueActivityDeliveries(now time.Time, limit int) ([]ActivityDelivery, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	due := []ActivityDelivery{}
+	for _, delivery := range ds.ActivityDeliveries {
+		if !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Id < due[j].Id
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// RetryActivityDelivery records a failed attempt and makes the delivery due
+// again at nextAttemptAt
+func (db *DB) RetryActivityDelivery(deliveryId int, errMsg string, nextAttemptAt time.Time) error {
+	return db.update(func(ds *DBStructure) error {
+		delivery, ok := ds.ActivityDeliveries[deliveryId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+		delivery.Attempts++
+		delivery.LastError = errMsg
+		delivery.NextAttemptAt = nextAttemptAt
+		ds.ActivityDeliveries[deliveryId] = delivery
+		return nil
+	})
+}
+
+// RemoveActivityDelivery removes a delivery that succeeded or gave up.
+// Removing a delivery that doesn't exist is not an error
+func (db *DB) RemoveActivityDelivery(deliveryId int) error {
+	return db.update(func(ds *DBStructure) error {
+		if _, ok := ds.ActivityDeliveries[deliveryId]; !ok {
+			return errNoChanges
+		}
+		delete(ds.ActivityDeliveries, deliveryId)
+		return nil
+	})
+}
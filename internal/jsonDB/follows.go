@@ -0,0 +1,155 @@
+package jsonDB
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func followKey(followerId, followeeId int) string {
+	return fmt.Sprintf("%d:%d", followerId, followeeId)
+}
+
+// FollowUser makes followerId follow followeeId. Following a user twice is
+// not an error and keeps the original follow
+func (db *DB) FollowUser(followerId, followeeId int) (Follow, error) {
+	follow := Follow{}
+	err := db.update(func(ds *DBStructure) error {
+		if _, ok := ds.Users[followeeId]; !ok {
+			return ErrDoesNotExists
+		}
+
+		key := followKey(followerId, followeeId)
+		if existing, ok := ds.Follows[key]; ok {
+			follow = existing
+			return errNoChanges
+		}
+
+		follow = Follow{
+			FollowerId: followerId,
+			FolloweeId: followeeId,
+			CreatedAt:  time.Now().UTC(),
+		}
+		ds.Follows[key] = follow
+		return ds.recordEvent(OutboxUserFollowed, follow)
+	})
+	if err != nil {
+		return Follow{}, err
+	}
+
+	return follow, nil
+}
+
+// UnfollowUser removes a follow. Removing a follow that doesn't exist is not
+// an error
+func (db *DB) UnfollowUser(followerId, followeeId int) error {
+	return db.update(func(ds *DBStructure) error {
+		key := followKey(followerId, followeeId)
+		if _, ok := ds.Follows[key]; !ok {
+			return errNoChanges
+		}
+		delete(ds.Follows, key)
+		return nil
+	})
+}
+
+// GetFollowers returns the follows pointing at a user, newest first
+func (db *DB) GetFollowers(userId int) ([]Follow, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	follows := []Follow{}
+	for _, follow := range ds.Follows {
+		if follow.FolloweeId == userId {
+			follows = append(follows, follow)
+		}
+	}
+	sortFollows(follows)
+
+	return follows, nil
+}
+
+// GetFollowing returns the follows made by a user, newest first
+func (db *DB) GetFollowing(userId int) ([]Follow, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	follows := []Follow{}
+	for _, follow := range ds.Follows {
+		if follow.FollowerId == userId {
+			follows = append(follows, follow)
+		}
+	}
+	sortFollows(follows)
+
+	return follows, nil
+}
+
+// CountFollows returns how many users follow userId and how many users
+// userId follows
+func (db *DB) CountFollows(userId int) (followers int, following int, err error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	for _, follow := range ds.Follows {
+		if follow.FolloweeId == userId {
+			followers++
+		}
+		if follow.FollowerId == userId {
+			following++
+		}
+	}
+
+	return followers, following, nil
+}
+
+// GetTimeline returns up to limit chirps written by userId or by the users
+// they follow, newest first. Only chirps with an ID lower than beforeId are
+// returned, a beforeId of 0 starts from the newest chirp
+func (db *DB) GetTimeline(userId, beforeId, limit int) ([]Chirp, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	authors := map[int]bool{userId: true}
+	for _, follow := range ds.Follows {
+		if follow.FollowerId == userId {
+			authors[follow.FolloweeId] = true
+		}
+	}
+
+	start := ds.LastChirpId
+	if beforeId > 0 && beforeId <= start {
+		start = beforeId - 1
+	}
+
+	chirps := []Chirp{}
+	for id := start; id > 0 && len(chirps) < limit; id-- {
+		chirp, ok := ds.Chirps[id]
+		if !ok || !chirp.Visible() || !authors[chirp.AuthorId] {
+			continue
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, nil
+}
+
+func sortFollows(follows []Follow) {
+	sort.Slice(follows, func(i, j int) bool {
+		return follows[i].CreatedAt.After(follows[j].CreatedAt)
+	})
+}
@@ -4,41 +4,122 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entities"
 )
 
 var ErrAlreadyExists = errors.New("already exists")
 var ErrDoesNotExists = errors.New("does not exist")
 var ErrNotAuthorized = errors.New("not authorized")
+var ErrHandleTaken = errors.New("handle already taken")
+var ErrEditWindowExpired = errors.New("edit window expired")
+var ErrInvalidMedia = errors.New("invalid media")
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+var ErrCaseClaimed = errors.New("case claimed by another moderator")
+var ErrCaseResolved = errors.New("case already resolved")
+
+// errNoChanges ends an update without writing, for changes that turn out to
+// be no-ops
+var errNoChanges = errors.New("no changes")
 
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path     string
+	mux      *sync.RWMutex
+	onEvents func()
 }
 
 type DBStructure struct {
-	Chirps      map[int]Chirp         `json:"chirps"`
-	Users       map[int]User          `json:"user"`
-	Revocations map[string]Revocation `json:"revocation"`
+	SchemaVersion             int                         `json:"schema_version"`
+	LastChirpId               int                         `json:"last_chirp_id"`
+	Chirps                    map[int]Chirp               `json:"chirps"`
+	Users                     map[int]User                `json:"user"`
+	Revocations               map[string]Revocation       `json:"revocation"`
+	MembershipEvents          []MembershipEvent           `json:"membership_events"`
+	Follows                   map[string]Follow           `json:"follows"`
+	Likes                     map[string]Engagement       `json:"likes"`
+	Rechirps                  map[string]Engagement       `json:"rechirps"`
+	Notifications             map[int]Notification        `json:"notifications"`
+	LastNotificationId        int                         `json:"last_notification_id"`
+	Revisions                 map[int][]ChirpRevision     `json:"revisions"`
+	Media                     map[string]Media            `json:"media"`
+	Reports                   map[int]Report              `json:"reports"`
+	LastReportId              int                         `json:"last_report_id"`
+	ModerationCases           map[int]ModerationCase      `json:"moderation_cases"`
+	ModerationLog             []ModerationAction          `json:"moderation_log"`
+	WebhookEvents             map[string]WebhookEvent     `json:"webhook_events"`
+	WebhookSubscriptions      map[int]WebhookSubscription `json:"webhook_subscriptions"`
+	LastWebhookSubscriptionId int                         `json:"last_webhook_subscription_id"`
+	WebhookDeliveries         map[int]WebhookDelivery     `json:"webhook_deliveries"`
+	LastWebhookDeliveryId     int                         `json:"last_webhook_delivery_id"`
+	Outbox                    map[int]OutboxEvent         `json:"outbox"`
+	LastOutboxId              int                         `json:"last_outbox_id"`
+	ActorKeys                 map[int]ActorKey            `json:"actor_keys"`
+	RemoteActors              map[string]RemoteActor      `json:"remote_actors"`
+	RemoteFollowers           map[string]RemoteFollower   `json:"remote_followers"`
+	RemoteLikes               map[string]RemoteLike       `json:"remote_likes"`
+	ActivityDeliveries        map[int]ActivityDelivery    `json:"activity_deliveries"`
+	LastActivityDeliveryId    int                         `json:"last_activity_delivery_id"`
+
+	// recordedEvents counts the events recorded since the structure was
+	// loaded
+	recordedEvents int
+	// migrated is set when the structure was loaded from an older schema
+	migrated bool
 }
 
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id           int               `json:"id"`
+	Body         string            `json:"body"`
+	AuthorId     int               `json:"author_id"`
+	LikeCount    int               `json:"like_count"`
+	RechirpCount int               `json:"rechirp_count"`
+	InReplyTo    int               `json:"in_reply_to,omitempty"`
+	ReplyCount   int               `json:"reply_count"`
+	Deleted      bool              `json:"deleted,omitempty"`
+	Entities     entities.Entities `json:"entities"`
+	Edited       bool              `json:"edited"`
+	Hidden       bool              `json:"hidden,omitempty"`
+	MediaIds     []string          `json:"media_ids"`
+	FlaggedWords []string          `json:"flagged_words,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
 }
 
 type User struct {
-	Id            int    `json:"id"`
-	Email         string `json:"email"`
-	Password      string
-	Is_chirpy_red bool `json:"is_chirpy_red"`
+	Id                     int    `json:"id"`
+	Email                  string `json:"email"`
+	Password               string
+	Handle                 string      `json:"handle"`
+	DisplayName            string      `json:"display_name"`
+	Bio                    string      `json:"bio"`
+	AvatarURL              string      `json:"avatar_url"`
+	Is_chirpy_red          bool        `json:"is_chirpy_red"`
+	Membership             *Membership `json:"membership,omitempty"`
+	DeletionScheduledAt    *time.Time  `json:"deletion_scheduled_at,omitempty"`
+	SessionsRevokedAt      *time.Time  `json:"sessions_revoked_at,omitempty"`
+	AnonymizeOnDelete      bool        `json:"anonymize_on_delete,omitempty"`
+	MutedNotificationTypes []string    `json:"muted_notification_types"`
+	CreatedAt              time.Time   `json:"created_at"`
+	UpdatedAt              time.Time   `json:"updated_at"`
+}
+
+// MembershipEvent records a change to a user's Chirpy Red membership.
+// Status is the status of the membership after the change
+type MembershipEvent struct {
+	UserId      int       `json:"user_id"`
+	Event       string    `json:"event"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Status      string    `json:"status,omitempty"`
+	At          time.Time `json:"at"`
 }
 
 type Revocation struct {
-	Token     string    `json:"token"`
+	Token     string    `json:"token,omitempty"`
+	UserId    int       `json:"user_id,omitempty"`
 	RevokedAt time.Time `json:"revoked_at"`
 }
 
@@ -54,14 +135,39 @@ func NewDB(path string) (*DB, error) {
 	return &db, nil
 }
 
-// loadDB reads the database file into memory
+// loadDB reads the database file into memory for reading. Changes must go
+// through update instead, so they can't overwrite each other. A file with an
+// older schema is saved once migrated, so values filled in by migrations
+// don't change from one read to the next
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mux.RLock()
-	defer db.mux.RUnlock()
+	ds, err := db.read()
+	db.mux.RUnlock()
+	if err != nil || !ds.migrated {
+		return ds, err
+	}
+
+	err = db.update(func(migrated *DBStructure) error {
+		ds = *migrated
+		if !migrated.migrated {
+			// another read saved the migrated data first
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return DBStructure{}, err
+	}
+	return ds, nil
+}
+
+// read reads the database file. The caller must hold the lock
+func (db *DB) read() (DBStructure, error) {
 	file, err := os.Open(db.path)
 	if err != nil {
 		return DBStructure{}, fmt.Errorf("can't open db file: %s", err)
 	}
+	defer file.Close()
 
 	// Read the contents of the file into a byte slice
 	stat, err := file.Stat()
@@ -70,16 +176,15 @@ func (db *DB) loadDB() (DBStructure, error) {
 	}
 	bytes := make([]byte, stat.Size())
 
-	_, err = file.Read(bytes)
+	_, err = io.ReadFull(file, bytes)
 	if err != nil {
 		return DBStructure{}, fmt.Errorf("failed to read data: %s", err)
 	}
-	file.Close()
 
 	ds := DBStructure{}
 	if len(bytes) == 0 {
-		ds.Chirps = map[int]Chirp{}
-		ds.Users = map[int]User{}
+		ds.initMaps()
+		ds.SchemaVersion = currentSchemaVersion
 		return ds, nil
 	}
 
@@ -88,16 +193,110 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return DBStructure{}, fmt.Errorf("failed to unmarshal JSON: %s", err)
 	}
+	ds.initMaps()
+	ds.migrate()
 
 	return ds, nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
+// initMaps makes sure every map in the structure can be written to, since
+// older database files won't contain all of them
+func (ds *DBStructure) initMaps() {
+	if ds.Chirps == nil {
+		ds.Chirps = map[int]Chirp{}
+	}
+	if ds.Users == nil {
+		ds.Users = map[int]User{}
+	}
+	if ds.Revocations == nil {
+		ds.Revocations = map[string]Revocation{}
+	}
+	if ds.Follows == nil {
+		ds.Follows = map[string]Follow{}
+	}
+	if ds.Likes == nil {
+		ds.Likes = map[string]Engagement{}
+	}
+	if ds.Rechirps == nil {
+		ds.Rechirps = map[string]Engagement{}
+	}
+	if ds.Notifications == nil {
+		ds.Notifications = map[int]Notification{}
+	}
+	if ds.Revisions == nil {
+		ds.Revisions = map[int][]ChirpRevision{}
+	}
+	if ds.Media == nil {
+		ds.Media = map[string]Media{}
+	}
+	if ds.Reports == nil {
+		ds.Reports = map[int]Report{}
+	}
+	if ds.ModerationCases == nil {
+		ds.ModerationCases = map[int]ModerationCase{}
+	}
+	if ds.WebhookEvents == nil {
+		ds.WebhookEvents = map[string]WebhookEvent{}
+	}
+	if ds.WebhookSubscriptions == nil {
+		ds.WebhookSubscriptions = map[int]WebhookSubscription{}
+	}
+	if ds.WebhookDeliveries == nil {
+		ds.WebhookDeliveries = map[int]WebhookDelivery{}
+	}
+	if ds.Outbox == nil {
+		ds.Outbox = map[int]OutboxEvent{}
+	}
+	if ds.ActorKeys == nil {
+		ds.ActorKeys = map[int]ActorKey{}
+	}
+	if ds.RemoteActors == nil {
+		ds.RemoteActors = map[string]RemoteActor{}
+	}
+	if ds.RemoteFollowers == nil {
+		ds.RemoteFollowers = map[string]RemoteFollower{}
+	}
+	if ds.RemoteLikes == nil {
+		ds.RemoteLikes = map[string]RemoteLike{}
+	}
+	if ds.ActivityDeliveries == nil {
+		ds.ActivityDeliveries = map[int]ActivityDelivery{}
+	}
+}
 
+// update loads the database, lets fn change it and writes it back, holding
+// the lock the whole time so concurrent updates can't overwrite each other.
+// Nothing is written when fn returns an error, which is returned as is, or
+// errNoChanges, which is not an error. Once the lock is released the
+// subscribers to recorded events are told about them
+func (db *DB) update(fn func(ds *DBStructure) error) error {
 	db.mux.Lock()
-	defer db.mux.Unlock()
+	ds, err := db.read()
+	if err != nil {
+		db.mux.Unlock()
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	err = fn(&ds)
+	if err == nil {
+		err = db.write(ds)
+	}
+	db.mux.Unlock()
+	if errors.Is(err, errNoChanges) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if ds.recordedEvents > 0 && db.onEvents != nil {
+		db.onEvents()
+	}
+	return nil
+}
 
+// write writes the database file to disk. The caller must hold the lock
+func (db *DB) write(dbStructure DBStructure) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return fmt.Errorf("error marshalling JSON: %s", err)
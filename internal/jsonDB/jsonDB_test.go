@@ -0,0 +1,262 @@
+package jsonDB
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	return db
+}
+
+func mustCreateUser(t *testing.T, db *DB, email string) User {
+	t.Helper()
+	user, err := db.CreateUser(email, "hash", "")
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %s", email, err)
+	}
+	return user
+}
+
+func mustCreateChirp(t *testing.T, db *DB, authorId int, body string) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(NewChirp{Body: body, AuthorId: authorId})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	return chirp
+}
+
+func TestConcurrentUpdatesAreNotLost(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "author@example.com")
+	chirp := mustCreateChirp(t, db, author.Id, "hello")
+
+	const n = 25
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(userId int) {
+			defer wg.Done()
+			_, err := db.AddEngagement(EngagementLike, chirp.Id, userId)
+			if err != nil {
+				t.Errorf("AddEngagement: %s", err)
+			}
+			_, err = db.CreateChirp(NewChirp{Body: "reply", AuthorId: userId, InReplyTo: chirp.Id})
+			if err != nil {
+				t.Errorf("CreateChirp: %s", err)
+			}
+		}(100 + i)
+	}
+	wg.Wait()
+
+	got, err := db.GetChirp(chirp.Id)
+	if err != nil {
+		t.Fatalf("GetChirp: %s", err)
+	}
+	if got.LikeCount != n {
+		t.Errorf("LikeCount = %d, want %d", got.LikeCount, n)
+	}
+	if got.ReplyCount != n {
+		t.Errorf("ReplyCount = %d, want %d", got.ReplyCount, n)
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %s", err)
+	}
+	if len(chirps) != n+1 {
+		t.Errorf("got %d chirps, want %d", len(chirps), n+1)
+	}
+}
+
+func TestUpdateWithoutChangesDoesNotNotify(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "author@example.com")
+	chirp := mustCreateChirp(t, db, author.Id, "hello")
+
+	notified := 0
+	db.OnEventsRecorded(func() { notified++ })
+
+	_, err := db.AddEngagement(EngagementLike, chirp.Id, author.Id)
+	if err != nil {
+		t.Fatalf("AddEngagement: %s", err)
+	}
+	if notified != 1 {
+		t.Errorf("event hook called %d times after liking a chirp, want 1", notified)
+	}
+	// liking twice is a no-op and must not fail
+	got, err := db.AddEngagement(EngagementLike, chirp.Id, author.Id)
+	if err != nil {
+		t.Fatalf("second AddEngagement: %s", err)
+	}
+	if got.LikeCount != 1 {
+		t.Errorf("LikeCount = %d, want 1", got.LikeCount)
+	}
+	if notified != 1 {
+		t.Errorf("event hook called %d times after a write without changes, want 1", notified)
+	}
+
+	mustCreateChirp(t, db, author.Id, "second")
+	if notified != 2 {
+		t.Errorf("event hook called %d times after creating a chirp, want 2", notified)
+	}
+}
+
+func TestFailedUpdateIsNotWritten(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "author@example.com")
+
+	_, err := db.CreateChirp(NewChirp{Body: "reply", AuthorId: author.Id, InReplyTo: 42})
+	if err != ErrDoesNotExists {
+		t.Fatalf("CreateChirp replying to a missing chirp: err = %v, want %v", err, ErrDoesNotExists)
+	}
+
+	chirp := mustCreateChirp(t, db, author.Id, "hello")
+	if chirp.Id != 1 {
+		t.Errorf("chirp ID = %d, want 1: the failed create must not use up an ID", chirp.Id)
+	}
+}
+
+func TestDeleteChirpKeepsTombstoneForReplies(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "author@example.com")
+	other := mustCreateUser(t, db, "other@example.com")
+	parent := mustCreateChirp(t, db, author.Id, "parent")
+	reply, err := db.CreateChirp(NewChirp{Body: "reply", AuthorId: other.Id, InReplyTo: parent.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	err = db.DeleteChirp(parent.Id, other.Id)
+	if err != ErrNotAuthorized {
+		t.Fatalf("DeleteChirp by another user: err = %v, want %v", err, ErrNotAuthorized)
+	}
+	err = db.DeleteChirp(parent.Id, author.Id)
+	if err != nil {
+		t.Fatalf("DeleteChirp: %s", err)
+	}
+
+	_, ancestors, _, err := db.GetThread(reply.Id)
+	if err != nil {
+		t.Fatalf("GetThread: %s", err)
+	}
+	if len(ancestors) != 1 || !ancestors[0].Deleted || ancestors[0].Body != "" {
+		t.Fatalf("ancestors = %+v, want one empty tombstone", ancestors)
+	}
+
+	err = db.DeleteChirp(reply.Id, other.Id)
+	if err != nil {
+		t.Fatalf("DeleteChirp reply: %s", err)
+	}
+	ds, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("loadDB: %s", err)
+	}
+	if len(ds.Chirps) != 0 {
+		t.Errorf("%d chirps left, want the tombstone removed with its last reply", len(ds.Chirps))
+	}
+}
+
+func TestSessionRevoked(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+	if user.SessionRevoked(time.Now()) {
+		t.Error("session revoked without a deletion request")
+	}
+
+	user, err := db.ScheduleUserDeletion(user.Id, time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatalf("ScheduleUserDeletion: %s", err)
+	}
+	revokedAt := *user.SessionsRevokedAt
+	if !revokedAt.Equal(revokedAt.Truncate(time.Second)) {
+		t.Errorf("SessionsRevokedAt = %s, want a whole second like token issue times", revokedAt)
+	}
+	if !user.SessionRevoked(revokedAt.Add(-time.Second)) {
+		t.Error("token issued before the deletion request isn't revoked")
+	}
+	// a token's issue time is truncated to the second, so one issued in the
+	// same second can't be told apart from one issued just before
+	if !user.SessionRevoked(revokedAt) {
+		t.Error("token issued in the second of the deletion request isn't revoked")
+	}
+	if user.SessionRevoked(revokedAt.Add(time.Second)) {
+		t.Error("token issued after the deletion request is revoked")
+	}
+}
+
+func TestMigratedDataIsSavedOnFirstRead(t *testing.T) {
+	db := newTestDB(t)
+	err := os.WriteFile(db.path, []byte(`{"chirps":{"1":{"id":1,"body":"old","author_id":1}},"user":{}}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	first, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatalf("GetChirp: %s", err)
+	}
+	if first.CreatedAt.IsZero() {
+		t.Fatal("chirp has no created_at after migrating")
+	}
+	time.Sleep(time.Millisecond)
+	second, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatalf("GetChirp: %s", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("created_at changed between reads: %s, then %s", first.CreatedAt, second.CreatedAt)
+	}
+
+	ds, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("loadDB: %s", err)
+	}
+	if ds.SchemaVersion != currentSchemaVersion {
+		t.Errorf("schema version = %d, want %d", ds.SchemaVersion, currentSchemaVersion)
+	}
+}
+
+func TestGetThreadOrdersReplies(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "author@example.com")
+	root := mustCreateChirp(t, db, author.Id, "root")
+
+	reply := func(parentId int) Chirp {
+		t.Helper()
+		chirp, err := db.CreateChirp(NewChirp{Body: "reply", AuthorId: author.Id, InReplyTo: parentId})
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+		return chirp
+	}
+	first := reply(root.Id)
+	second := reply(root.Id)
+	nested := reply(first.Id)
+	third := reply(root.Id)
+	want := []int{first.Id, second.Id, third.Id, nested.Id}
+
+	for i := 0; i < 10; i++ {
+		_, _, descendants, err := db.GetThread(root.Id)
+		if err != nil {
+			t.Fatalf("GetThread: %s", err)
+		}
+		got := []int{}
+		for _, chirp := range descendants {
+			got = append(got, chirp.Id)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("descendants = %v, want %v", got, want)
+		}
+	}
+}
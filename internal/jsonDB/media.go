@@ -0,0 +1,151 @@
+package jsonDB
+
+import (
+	"fmt"
+	"time"
+)
+
+// Media is an uploaded file. Its contents live in a blob store, keyed by the
+// media ID. ChirpId is 0 until the media is attached to a chirp
+type Media struct {
+	Id                   string    `json:"id"`
+	OwnerId              int       `json:"owner_id"`
+	ChirpId              int       `json:"chirp_id"`
+	ContentType          string    `json:"content_type"`
+	Size                 int64     `json:"size"`
+	Width                int       `json:"width"`
+	Height               int       `json:"height"`
+	ThumbnailContentType string    `json:"thumbnail_content_type"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// CreateMedia stores the record of an uploaded file. It fails with
+// ErrQuotaExceeded when the owner's uploads would take up more than quota
+// bytes
+func (db *DB) CreateMedia(media Media, quota int64) (Media, error) {
+	err := db.update(func(ds *DBStructure) error {
+		if _, ok := ds.Media[media.Id]; ok {
+			return ErrAlreadyExists
+		}
+		if ds.mediaUsage(media.OwnerId)+media.Size > quota {
+			return ErrQuotaExceeded
+		}
+
+		media.ChirpId = 0
+		media.CreatedAt = time.Now().UTC()
+		ds.Media[media.Id] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+// GetMedia returns the media with a specific ID
+func (db *DB) GetMedia(id string) (Media, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	media, ok := ds.Media[id]
+	if !ok {
+		return Media{}, ErrDoesNotExists
+	}
+
+	return media, nil
+}
+
+// GetMediaByIds returns the media with the given IDs keyed by ID. Unknown IDs
+// are left out
+func (db *DB) GetMediaByIds(ids []string) (map[string]Media, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	media := make(map[string]Media, len(ids))
+	for _, id := range ids {
+		m, ok := ds.Media[id]
+		if ok {
+			media[id] = m
+		}
+	}
+
+	return media, nil
+}
+
+// PruneUnattachedMedia removes the media uploaded before a time that were
+// never attached to a chirp and returns their IDs, so their blobs can be
+// deleted
+func (db *DB) PruneUnattachedMedia(before time.Time) ([]string, error) {
+	pruned := []string{}
+	err := db.update(func(ds *DBStructure) error {
+		for id, media := range ds.Media {
+			if media.ChirpId == 0 && media.CreatedAt.Before(before) {
+				delete(ds.Media, id)
+				pruned = append(pruned, id)
+			}
+		}
+		if len(pruned) == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pruned, nil
+}
+
+// GetMediaUsage returns the total size in bytes of the media a user has
+// uploaded
+func (db *DB) GetMediaUsage(userId int) (int64, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return ds.mediaUsage(userId), nil
+}
+
+func (ds *DBStructure) mediaUsage(userId int) int64 {
+	var usage int64
+	for _, m := range ds.Media {
+		if m.OwnerId == userId {
+			usage += m.Size
+		}
+	}
+	return usage
+}
+
+// attachMedia links uploaded media to a new chirp. Every piece of media must
+// belong to the author and not be attached to another chirp yet
+func (ds *DBStructure) attachMedia(chirpId, authorId int, mediaIds []string) error {
+	seen := map[string]bool{}
+	for _, id := range mediaIds {
+		media, ok := ds.Media[id]
+		if !ok || seen[id] || media.OwnerId != authorId || media.ChirpId != 0 {
+			return ErrInvalidMedia
+		}
+		seen[id] = true
+	}
+
+	for _, id := range mediaIds {
+		media := ds.Media[id]
+		media.ChirpId = chirpId
+		ds.Media[id] = media
+	}
+
+	return nil
+}
+
+// removeChirpMedia deletes the media records attached to a chirp
+func (ds *DBStructure) removeChirpMedia(chirp Chirp) {
+	for _, id := range chirp.MediaIds {
+		delete(ds.Media, id)
+	}
+}
@@ -0,0 +1,83 @@
+package jsonDB
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateMediaEnforcesQuota(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+
+	// uploads racing each other can't both fit in the quota
+	const quota = 150
+	wg := sync.WaitGroup{}
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = db.CreateMedia(Media{Id: fmt.Sprintf("m%d", i), OwnerId: user.Id, Size: 100}, quota)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrQuotaExceeded):
+			t.Errorf("CreateMedia: %s", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d uploads, want 1", created)
+	}
+
+	usage, err := db.GetMediaUsage(user.Id)
+	if err != nil {
+		t.Fatalf("GetMediaUsage: %s", err)
+	}
+	if usage != 100 {
+		t.Errorf("usage = %d, want 100", usage)
+	}
+}
+
+func TestPruneUnattachedMedia(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+	for _, id := range []string{"attached", "abandoned"} {
+		_, err := db.CreateMedia(Media{Id: id, OwnerId: user.Id, Size: 10}, 1000)
+		if err != nil {
+			t.Fatalf("CreateMedia: %s", err)
+		}
+	}
+	_, err := db.CreateChirp(NewChirp{Body: "photo", AuthorId: user.Id, MediaIds: []string{"attached"}})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	pruned, err := db.PruneUnattachedMedia(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PruneUnattachedMedia: %s", err)
+	}
+	if len(pruned) != 0 {
+		t.Errorf("pruned recent uploads %v", pruned)
+	}
+
+	pruned, err = db.PruneUnattachedMedia(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("PruneUnattachedMedia: %s", err)
+	}
+	if len(pruned) != 1 || pruned[0] != "abandoned" {
+		t.Errorf("pruned %v, want [abandoned]", pruned)
+	}
+	_, err = db.GetMedia("attached")
+	if err != nil {
+		t.Errorf("GetMedia(attached): %s", err)
+	}
+}
@@ -0,0 +1,188 @@
+package jsonDB
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoMembership = errors.New("user has no membership")
+
+const PlanChirpyRed = "chirpy_red"
+
+// MembershipPeriod is how long a membership lasts when the payment provider
+// doesn't say when the paid period ends
+const MembershipPeriod = 30 * 24 * time.Hour
+
+// Statuses of a membership. A cancelled membership stays in effect until
+// the end of the period that was paid for, then expires
+const (
+	MembershipActive     = "active"
+	MembershipCancelled  = "cancelled"
+	MembershipExpired    = "expired"
+	MembershipDowngraded = "downgraded"
+)
+
+// Membership events from the payment provider, and the expiry we record
+// ourselves
+const (
+	EventUserUpgraded          = "user.upgraded"
+	EventUserDowngraded        = "user.downgraded"
+	EventSubscriptionRenewed   = "subscription.renewed"
+	EventSubscriptionCancelled = "subscription.cancelled"
+	EventSubscriptionExpired   = "subscription.expired"
+)
+
+// Membership is a user's paid plan
+type Membership struct {
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	StartedAt        time.Time  `json:"started_at"`
+	CurrentPeriodEnd time.Time  `json:"current_period_end"`
+	CancelledAt      *time.Time `json:"cancelled_at,omitempty"`
+	EndedAt          *time.Time `json:"ended_at,omitempty"`
+}
+
+// IsActive reports whether the membership gives access to its plan at the
+// given time
+func (m *Membership) IsActive(now time.Time) bool {
+	if m == nil {
+		return false
+	}
+	if m.Status != MembershipActive && m.Status != MembershipCancelled {
+		return false
+	}
+	return now.Before(m.CurrentPeriodEnd)
+}
+
+// IsChirpyRed reports whether the user has an active Chirpy Red membership
+// at the given time. The stored Is_chirpy_red is only brought up to date
+// when the membership changes or expires, so read the status through this
+func (user User) IsChirpyRed(now time.Time) bool {
+	return user.Membership.IsActive(now)
+}
+
+// ApplyMembershipEvent updates a user's membership for an event from the
+// payment provider. periodEnd is when the paid period ends; a zero periodEnd
+// means a full MembershipPeriod from now. Renewing or cancelling without a
+// membership returns ErrNoMembership
+func (db *DB) ApplyMembershipEvent(userId int, event string, periodEnd time.Time) (User, error) {
+	user := User{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		user, ok = ds.Users[userId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		now := time.Now().UTC()
+		if periodEnd.IsZero() {
+			periodEnd = now.Add(MembershipPeriod)
+		}
+		periodEnd = periodEnd.UTC()
+
+		membership := user.Membership
+		switch event {
+		case EventUserUpgraded:
+			if !membership.IsActive(now) {
+				membership = &Membership{
+					Plan:      PlanChirpyRed,
+					StartedAt: now,
+				}
+			}
+			membership.Status = MembershipActive
+			membership.CurrentPeriodEnd = periodEnd
+			membership.CancelledAt = nil
+			membership.EndedAt = nil
+		case EventSubscriptionRenewed:
+			if membership == nil {
+				return ErrNoMembership
+			}
+			if !membership.IsActive(now) {
+				membership.StartedAt = now
+			}
+			membership.Status = MembershipActive
+			membership.CurrentPeriodEnd = periodEnd
+			membership.CancelledAt = nil
+			membership.EndedAt = nil
+		case EventSubscriptionCancelled:
+			if !membership.IsActive(now) {
+				return ErrNoMembership
+			}
+			membership.Status = MembershipCancelled
+			membership.CancelledAt = &now
+		case EventUserDowngraded:
+			if membership == nil {
+				return ErrNoMembership
+			}
+			membership.Status = MembershipDowngraded
+			membership.EndedAt = &now
+		default:
+			return fmt.Errorf("unknown membership event %q", event)
+		}
+
+		user.Membership = membership
+		err := ds.setMembership(user, event, now)
+		user = ds.Users[userId]
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// ExpireMemberships ends the memberships whose paid period ran out before
+// now and returns how many expired
+func (db *DB) ExpireMemberships(now time.Time) (int, error) {
+	expired := 0
+	err := db.update(func(ds *DBStructure) error {
+		for _, user := range ds.Users {
+			m := user.Membership
+			if m == nil || (m.Status != MembershipActive && m.Status != MembershipCancelled) || m.IsActive(now) {
+				continue
+			}
+
+			endedAt := m.CurrentPeriodEnd
+			m.Status = MembershipExpired
+			m.EndedAt = &endedAt
+			err := ds.setMembership(user, EventSubscriptionExpired, now)
+			if err != nil {
+				return err
+			}
+			expired++
+		}
+
+		if expired == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// setMembership saves a user whose membership changed, keeping
+// Is_chirpy_red in step with it, and records the change in the membership
+// history
+func (ds *DBStructure) setMembership(user User, event string, now time.Time) error {
+	user.Is_chirpy_red = user.IsChirpyRed(now)
+	user.UpdatedAt = now
+	ds.Users[user.Id] = user
+
+	ds.MembershipEvents = append(ds.MembershipEvents, MembershipEvent{
+		UserId:      user.Id,
+		Event:       event,
+		IsChirpyRed: user.Is_chirpy_red,
+		Status:      user.Membership.Status,
+		At:          now,
+	})
+	if event == EventUserUpgraded {
+		return ds.recordEvent(OutboxUserUpgraded, user.eventUser())
+	}
+	return nil
+}
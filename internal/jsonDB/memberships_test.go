@@ -0,0 +1,33 @@
+package jsonDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsChirpyRedFollowsMembershipPeriod(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+
+	periodEnd := time.Now().UTC().Add(time.Hour)
+	user, err := db.ApplyMembershipEvent(user.Id, EventUserUpgraded, periodEnd)
+	if err != nil {
+		t.Fatalf("ApplyMembershipEvent: %s", err)
+	}
+	if !user.IsChirpyRed(time.Now()) {
+		t.Error("upgraded user is not Chirpy Red")
+	}
+	// the period ran out but ExpireMemberships hasn't run yet
+	if user.IsChirpyRed(periodEnd.Add(time.Second)) {
+		t.Error("user is Chirpy Red after the paid period ended")
+	}
+
+	renewedEnd := periodEnd.Add(MembershipPeriod)
+	user, err = db.ApplyMembershipEvent(user.Id, EventSubscriptionRenewed, renewedEnd)
+	if err != nil {
+		t.Fatalf("ApplyMembershipEvent: %s", err)
+	}
+	if !user.IsChirpyRed(periodEnd.Add(time.Second)) {
+		t.Error("renewed user is not Chirpy Red after the first period")
+	}
+}
@@ -0,0 +1,78 @@
+package jsonDB
+
+import "time"
+
+// migrations upgrade a database loaded from disk to the current schema. The
+// migration at index i takes the database from version i to version i+1.
+// Migrated data is saved by the first read or write that loads it
+var migrations = []func(ds *DBStructure){
+	backfillTimestamps,
+	initLastChirpId,
+	backfillMemberships,
+}
+
+var currentSchemaVersion = len(migrations)
+
+func (ds *DBStructure) migrate() {
+	for ds.SchemaVersion < currentSchemaVersion {
+		migrations[ds.SchemaVersion](ds)
+		ds.SchemaVersion++
+		ds.migrated = true
+	}
+}
+
+// backfillTimestamps sets created_at and updated_at on chirps and users
+// stored before they had timestamps. The original times are unknown, so the
+// time of the migration is used
+func backfillTimestamps(ds *DBStructure) {
+	now := time.Now().UTC()
+
+	for id, chirp := range ds.Chirps {
+		if chirp.CreatedAt.IsZero() {
+			chirp.CreatedAt = now
+		}
+		if chirp.UpdatedAt.IsZero() {
+			chirp.UpdatedAt = chirp.CreatedAt
+		}
+		ds.Chirps[id] = chirp
+	}
+
+	for id, user := range ds.Users {
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = user.CreatedAt
+		}
+		ds.Users[id] = user
+	}
+}
+
+// initLastChirpId starts the chirp ID sequence after the highest ID in use
+func initLastChirpId(ds *DBStructure) {
+	for id := range ds.Chirps {
+		if id > ds.LastChirpId {
+			ds.LastChirpId = id
+		}
+	}
+}
+
+// backfillMemberships gives users upgraded before memberships were tracked
+// an active membership. When it started is unknown, so the time the user was
+// last updated is used, and the first period ends a full period from now
+func backfillMemberships(ds *DBStructure) {
+	now := time.Now().UTC()
+
+	for id, user := range ds.Users {
+		if !user.Is_chirpy_red || user.Membership != nil {
+			continue
+		}
+		user.Membership = &Membership{
+			Plan:             PlanChirpyRed,
+			Status:           MembershipActive,
+			StartedAt:        user.UpdatedAt,
+			CurrentPeriodEnd: now.Add(MembershipPeriod),
+		}
+		ds.Users[id] = user
+	}
+}
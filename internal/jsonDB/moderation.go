@@ -0,0 +1,442 @@
+package jsonDB
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons a chirp can be reported for. ReasonFlaggedWords is only used for
+// reports filed by the profanity filter
+const (
+	ReasonSpam           = "spam"
+	ReasonHarassment     = "harassment"
+	ReasonHate           = "hate"
+	ReasonViolence       = "violence"
+	ReasonSexual         = "sexual"
+	ReasonSelfHarm       = "self_harm"
+	ReasonMisinformation = "misinformation"
+	ReasonOther          = "other"
+	ReasonFlaggedWords   = "flagged_words"
+)
+
+// ReportReasons are the reasons users can pick when reporting a chirp
+var ReportReasons = []string{
+	ReasonSpam,
+	ReasonHarassment,
+	ReasonHate,
+	ReasonViolence,
+	ReasonSexual,
+	ReasonSelfHarm,
+	ReasonMisinformation,
+	ReasonOther,
+}
+
+// Statuses of a moderation case
+const (
+	CaseOpen     = "open"
+	CaseClaimed  = "claimed"
+	CaseResolved = "resolved"
+)
+
+// Ways a moderator can resolve a case
+const (
+	ResolutionHide    = "hide"
+	ResolutionDelete  = "delete"
+	ResolutionDismiss = "dismiss"
+)
+
+// Actions recorded in the moderation log besides the resolutions. A chirp
+// deleted by its author closes its case with ActionChirpDeleted
+const (
+	ActionClaim        = "claim"
+	ActionAutoHide     = "auto_hide"
+	ActionChirpDeleted = "chirp_deleted"
+)
+
+// Report is a complaint about a chirp. Reports filed by the system rather
+// than a user have a ReporterId of 0
+type Report struct {
+	Id         int       `json:"id"`
+	ChirpId    int       `json:"chirp_id"`
+	ReporterId int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ModerationCase collects the reports about a chirp until a moderator
+// resolves it. Reports arriving after a case was dismissed open it again
+type ModerationCase struct {
+	ChirpId    int        `json:"chirp_id"`
+	Status     string     `json:"status"`
+	ReportIds  []int      `json:"report_ids"`
+	AutoHidden bool       `json:"auto_hidden"`
+	ClaimedBy  int        `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ModerationAction is an entry in the audit log of moderation decisions.
+// Actions taken automatically have a ModeratorId of 0
+type ModerationAction struct {
+	ChirpId     int       `json:"chirp_id"`
+	ModeratorId int       `json:"moderator_id"`
+	Action      string    `json:"action"`
+	Note        string    `json:"note,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// ReportChirp files a report from a user. Once autoHideThreshold users have
+// reported a chirp in an unresolved case it is hidden until a moderator
+// looks at it. A user can only report a chirp once
+func (db *DB) ReportChirp(chirpId, reporterId int, reason, details string, autoHideThreshold int) (Report, ModerationCase, error) {
+	report := Report{}
+	mc := ModerationCase{}
+	err := db.update(func(ds *DBStructure) error {
+		chirp, ok := ds.Chirps[chirpId]
+		if !ok || !chirp.Visible() {
+			return ErrDoesNotExists
+		}
+
+		for _, id := range ds.ModerationCases[chirpId].ReportIds {
+			if ds.Reports[id].ReporterId == reporterId {
+				return ErrAlreadyExists
+			}
+		}
+
+		report, mc = ds.addReport(chirpId, reporterId, reason, details)
+
+		if autoHideThreshold > 0 && mc.Status != CaseResolved && ds.countReporters(mc) >= autoHideThreshold {
+			chirp.Hidden = true
+			ds.Chirps[chirpId] = chirp
+			mc.AutoHidden = true
+			ds.ModerationCases[chirpId] = mc
+			ds.logModerationAction(chirpId, 0, ActionAutoHide, fmt.Sprintf("reported by %d users", autoHideThreshold))
+			return ds.recordEvent(OutboxChirpHidden, chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return Report{}, ModerationCase{}, err
+	}
+
+	return report, mc, nil
+}
+
+// addReport stores a report and adds it to the case of the chirp, opening
+// the case if there is none or it was dismissed
+func (ds *DBStructure) addReport(chirpId, reporterId int, reason, details string) (Report, ModerationCase) {
+	now := time.Now().UTC()
+
+	ds.LastReportId++
+	report := Report{
+		Id:         ds.LastReportId,
+		ChirpId:    chirpId,
+		ReporterId: reporterId,
+		Reason:     reason,
+		Details:    details,
+		CreatedAt:  now,
+	}
+	ds.Reports[report.Id] = report
+
+	mc, ok := ds.ModerationCases[chirpId]
+	if !ok || mc.Resolution == ResolutionDismiss {
+		mc = ModerationCase{
+			ChirpId:   chirpId,
+			Status:    CaseOpen,
+			ReportIds: mc.ReportIds,
+			CreatedAt: now,
+		}
+	}
+	mc.ReportIds = append(mc.ReportIds, report.Id)
+	mc.UpdatedAt = now
+	ds.ModerationCases[chirpId] = mc
+
+	return report, mc
+}
+
+// countReporters returns the number of users who reported the chirp since
+// the case was opened
+func (ds *DBStructure) countReporters(mc ModerationCase) int {
+	reporters := map[int]bool{}
+	for _, id := range mc.ReportIds {
+		report := ds.Reports[id]
+		if report.ReporterId != 0 && !report.CreatedAt.Before(mc.CreatedAt) {
+			reporters[report.ReporterId] = true
+		}
+	}
+	return len(reporters)
+}
+
+// flagChirp files a report for chirps the profanity filter flagged. An
+// edited chirp that is still waiting for a moderator isn't reported again,
+// since moderators see its current body anyway
+func (ds *DBStructure) flagChirp(chirp Chirp) {
+	if len(chirp.FlaggedWords) == 0 || ds.hasOpenSystemReport(chirp.Id) {
+		return
+	}
+	ds.addReport(chirp.Id, 0, ReasonFlaggedWords, strings.Join(chirp.FlaggedWords, ", "))
+}
+
+// hasOpenSystemReport reports whether the unresolved case of a chirp holds a
+// report filed by the system
+func (ds *DBStructure) hasOpenSystemReport(chirpId int) bool {
+	mc, ok := ds.ModerationCases[chirpId]
+	if !ok || mc.Status == CaseResolved {
+		return false
+	}
+	for _, id := range mc.ReportIds {
+		report := ds.Reports[id]
+		if report.ReporterId == 0 && !report.CreatedAt.Before(mc.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeModerationCase resolves the open case of a chirp that is being
+// deleted, since there is nothing left to moderate
+func (ds *DBStructure) closeModerationCase(chirpId int) {
+	mc, ok := ds.ModerationCases[chirpId]
+	if !ok || mc.Status == CaseResolved {
+		return
+	}
+
+	now := time.Now().UTC()
+	mc.Status = CaseResolved
+	mc.Resolution = ActionChirpDeleted
+	mc.ResolvedAt = &now
+	mc.UpdatedAt = now
+	ds.ModerationCases[chirpId] = mc
+	ds.logModerationAction(chirpId, 0, ActionChirpDeleted, "")
+}
+
+func (ds *DBStructure) logModerationAction(chirpId, moderatorId int, action, note string) {
+	ds.ModerationLog = append(ds.ModerationLog, ModerationAction{
+		ChirpId:     chirpId,
+		ModeratorId: moderatorId,
+		Action:      action,
+		Note:        note,
+		At:          time.Now().UTC(),
+	})
+}
+
+// GetModerationCases returns the cases with the given status, or every
+// unresolved case when status is empty. Cases with the most reports come
+// first, then the oldest
+func (db *DB) GetModerationCases(status string) ([]ModerationCase, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	cases := []ModerationCase{}
+	for _, mc := range ds.ModerationCases {
+		if status == "" && mc.Status == CaseResolved {
+			continue
+		}
+		if status != "" && mc.Status != status {
+			continue
+		}
+		cases = append(cases, mc)
+	}
+
+	sort.Slice(cases, func(i, j int) bool {
+		if len(cases[i].ReportIds) != len(cases[j].ReportIds) {
+			return len(cases[i].ReportIds) > len(cases[j].ReportIds)
+		}
+		if !cases[i].CreatedAt.Equal(cases[j].CreatedAt) {
+			return cases[i].CreatedAt.Before(cases[j].CreatedAt)
+		}
+		return cases[i].ChirpId < cases[j].ChirpId
+	})
+
+	return cases, nil
+}
+
+// GetModerationCase returns the case of a chirp with its reports, oldest
+// first
+func (db *DB) GetModerationCase(chirpId int) (ModerationCase, []Report, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return ModerationCase{}, nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	mc, ok := ds.ModerationCases[chirpId]
+	if !ok {
+		return ModerationCase{}, nil, ErrDoesNotExists
+	}
+
+	reports := make([]Report, 0, len(mc.ReportIds))
+	for _, id := range mc.ReportIds {
+		if report, ok := ds.Reports[id]; ok {
+			reports = append(reports, report)
+		}
+	}
+
+	return mc, reports, nil
+}
+
+// GetModeratedChirps returns the chirps with the given IDs keyed by ID,
+// including the ones hidden by moderators. Deleted and unknown chirps are
+// left out
+func (db *DB) GetModeratedChirps(ids []int) (map[int]Chirp, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirps := make(map[int]Chirp, len(ids))
+	for _, id := range ids {
+		chirp, ok := ds.Chirps[id]
+		if ok && !chirp.Deleted {
+			chirps[id] = chirp
+		}
+	}
+
+	return chirps, nil
+}
+
+// GetReports returns the reports with the given IDs keyed by ID
+func (db *DB) GetReports(ids []int) (map[int]Report, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	reports := make(map[int]Report, len(ids))
+	for _, id := range ids {
+		if report, ok := ds.Reports[id]; ok {
+			reports[id] = report
+		}
+	}
+
+	return reports, nil
+}
+
+// ClaimModerationCase assigns a case to a moderator so others know it is
+// being handled. Claiming a case twice has no further effect
+func (db *DB) ClaimModerationCase(chirpId, moderatorId int) (ModerationCase, error) {
+	mc := ModerationCase{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		mc, ok = ds.ModerationCases[chirpId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+		if mc.Status == CaseResolved {
+			return ErrCaseResolved
+		}
+		if mc.Status == CaseClaimed {
+			if mc.ClaimedBy != moderatorId {
+				return ErrCaseClaimed
+			}
+			return errNoChanges
+		}
+
+		now := time.Now().UTC()
+		mc.Status = CaseClaimed
+		mc.ClaimedBy = moderatorId
+		mc.ClaimedAt = &now
+		mc.UpdatedAt = now
+		ds.ModerationCases[chirpId] = mc
+		ds.logModerationAction(chirpId, moderatorId, ActionClaim, "")
+		return nil
+	})
+	if err != nil {
+		return ModerationCase{}, err
+	}
+
+	return mc, nil
+}
+
+// ResolveModerationCase closes a case by hiding the chirp, deleting it or
+// dismissing the reports, which also unhides a chirp that was hidden
+// automatically. The chirp is returned as it was before the decision. Cases
+// claimed by another moderator can't be resolved
+func (db *DB) ResolveModerationCase(chirpId, moderatorId int, resolution, note string) (ModerationCase, Chirp, error) {
+	mc := ModerationCase{}
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		mc, ok = ds.ModerationCases[chirpId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+		if mc.Status == CaseResolved {
+			return ErrCaseResolved
+		}
+		if mc.Status == CaseClaimed && mc.ClaimedBy != moderatorId {
+			return ErrCaseClaimed
+		}
+
+		chirp, ok = ds.Chirps[chirpId]
+		if !ok || chirp.Deleted {
+			return ErrDoesNotExists
+		}
+
+		switch resolution {
+		case ResolutionHide:
+			hidden := chirp
+			hidden.Hidden = true
+			ds.Chirps[chirpId] = hidden
+		case ResolutionDismiss:
+			unhidden := chirp
+			unhidden.Hidden = false
+			ds.Chirps[chirpId] = unhidden
+		case ResolutionDelete:
+			// resolve the case first so deleting the chirp doesn't close it
+		default:
+			return fmt.Errorf("unknown resolution %q", resolution)
+		}
+
+		now := time.Now().UTC()
+		mc.Status = CaseResolved
+		mc.Resolution = resolution
+		mc.ResolvedBy = moderatorId
+		mc.ResolvedAt = &now
+		mc.UpdatedAt = now
+		ds.ModerationCases[chirpId] = mc
+		ds.logModerationAction(chirpId, moderatorId, resolution, note)
+
+		switch {
+		case resolution == ResolutionDelete:
+			return ds.deleteChirp(chirpId)
+		case resolution == ResolutionHide && !chirp.Hidden:
+			return ds.recordEvent(OutboxChirpHidden, ds.Chirps[chirpId])
+		case resolution == ResolutionDismiss && chirp.Hidden:
+			return ds.recordEvent(OutboxChirpRestored, ds.Chirps[chirpId])
+		}
+		return nil
+	})
+	if err != nil {
+		return ModerationCase{}, Chirp{}, err
+	}
+
+	return mc, chirp, nil
+}
+
+// GetModerationLog returns the moderation decisions about a chirp, or about
+// every chirp when chirpId is 0, newest first
+func (db *DB) GetModerationLog(chirpId int) ([]ModerationAction, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	actions := []ModerationAction{}
+	for i := len(ds.ModerationLog) - 1; i >= 0; i-- {
+		action := ds.ModerationLog[i]
+		if chirpId == 0 || action.ChirpId == chirpId {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions, nil
+}
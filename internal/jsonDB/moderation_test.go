@@ -0,0 +1,47 @@
+package jsonDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEditsDontRepeatSystemReports(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+	chirp, err := db.CreateChirp(NewChirp{Body: "darn", AuthorId: user.Id, FlaggedWords: []string{"darn"}})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	countReports := func() int {
+		t.Helper()
+		mc, _, err := db.GetModerationCase(chirp.Id)
+		if err != nil {
+			t.Fatalf("GetModerationCase: %s", err)
+		}
+		return len(mc.ReportIds)
+	}
+
+	for _, body := range []string{"darn it", "darn it all"} {
+		_, err = db.EditChirp(chirp.Id, user.Id, ChirpEdit{Body: body, FlaggedWords: []string{"darn"}}, time.Hour)
+		if err != nil {
+			t.Fatalf("EditChirp: %s", err)
+		}
+	}
+	if n := countReports(); n != 1 {
+		t.Errorf("got %d reports after edits of an open case, want 1", n)
+	}
+
+	moderator := mustCreateUser(t, db, "mod@example.com")
+	_, _, err = db.ResolveModerationCase(chirp.Id, moderator.Id, ResolutionDismiss, "")
+	if err != nil {
+		t.Fatalf("ResolveModerationCase: %s", err)
+	}
+	_, err = db.EditChirp(chirp.Id, user.Id, ChirpEdit{Body: "darn again", FlaggedWords: []string{"darn"}}, time.Hour)
+	if err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	if n := countReports(); n != 2 {
+		t.Errorf("got %d reports after editing a dismissed chirp, want 2", n)
+	}
+}
@@ -0,0 +1,247 @@
+package jsonDB
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	NotificationMention = "mention"
+	NotificationReply   = "reply"
+	NotificationLike    = "like"
+	NotificationRechirp = "rechirp"
+	NotificationFollow  = "follow"
+)
+
+// NotificationRepeatWindow is how long a notification that hasn't been read
+// keeps the same like, rechirp or follow from notifying again, so undoing
+// and redoing one doesn't flood the recipient
+const NotificationRepeatWindow = time.Hour
+
+// NotificationTypes lists every type of notification a user can receive
+var NotificationTypes = []string{
+	NotificationMention,
+	NotificationReply,
+	NotificationLike,
+	NotificationRechirp,
+	NotificationFollow,
+}
+
+// Notification tells a user that ActorId interacted with them, through the
+// chirp ChirpId if the type involves one
+type Notification struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	ActorId   int        `json:"actor_id"`
+	ChirpId   int        `json:"chirp_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// CreateNotifications stores new notifications. Notifications about a user's
+// own actions, of a type the recipient has muted, or repeating an earlier
+// one are skipped. It returns the notifications that were stored
+func (db *DB) CreateNotifications(notifications []Notification) ([]Notification, error) {
+	created := []Notification{}
+	err := db.update(func(ds *DBStructure) error {
+		now := time.Now().UTC()
+		for _, notification := range notifications {
+			if notification.UserId == notification.ActorId {
+				continue
+			}
+			user, ok := ds.Users[notification.UserId]
+			if !ok || user.hasMuted(notification.Type) {
+				continue
+			}
+			if ds.isRepeatNotification(notification, now.Add(-NotificationRepeatWindow)) {
+				continue
+			}
+
+			ds.LastNotificationId++
+			notification.Id = ds.LastNotificationId
+			notification.CreatedAt = now
+			notification.ReadAt = nil
+			ds.Notifications[notification.Id] = notification
+			created = append(created, notification)
+		}
+
+		if len(created) == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetNotifications returns up to limit notifications of a user with an ID
+// lower than beforeId, newest first. A beforeId of 0 starts from the newest
+func (db *DB) GetNotifications(userId, beforeId, limit int, unreadOnly bool) ([]Notification, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	notifications := []Notification{}
+	for _, notification := range ds.Notifications {
+		if notification.UserId != userId {
+			continue
+		}
+		if beforeId > 0 && notification.Id >= beforeId {
+			continue
+		}
+		if unreadOnly && notification.ReadAt != nil {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Id > notifications[j].Id
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+
+	return notifications, nil
+}
+
+// CountUnreadNotifications returns the number of unread notifications of a
+// user
+func (db *DB) CountUnreadNotifications(userId int) (int, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	count := 0
+	for _, notification := range ds.Notifications {
+		if notification.UserId == userId && notification.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MarkNotificationsRead marks notifications of a user as read. With no ids
+// every notification of the user is marked. It returns the number of
+// notifications that were unread before
+func (db *DB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	selected := map[int]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	marked := 0
+	err := db.update(func(ds *DBStructure) error {
+		now := time.Now().UTC()
+		for id, notification := range ds.Notifications {
+			if notification.UserId != userId || notification.ReadAt != nil {
+				continue
+			}
+			if len(ids) > 0 && !selected[id] {
+				continue
+			}
+			notification.ReadAt = &now
+			ds.Notifications[id] = notification
+			marked++
+		}
+
+		if marked == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return marked, nil
+}
+
+// DeleteChirpNotifications removes every notification about a chirp
+func (db *DB) DeleteChirpNotifications(chirpId int) error {
+	return db.update(func(ds *DBStructure) error {
+		removed := 0
+		for id, notification := range ds.Notifications {
+			if notification.ChirpId == chirpId {
+				delete(ds.Notifications, id)
+				removed++
+			}
+		}
+
+		if removed == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+}
+
+// SetMutedNotificationTypes replaces the notification types a user doesn't
+// want to receive
+func (db *DB) SetMutedNotificationTypes(userId int, types []string) (User, error) {
+	user := User{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		user, ok = ds.Users[userId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		user.MutedNotificationTypes = types
+		user.UpdatedAt = time.Now().UTC()
+		ds.Users[userId] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (user User) hasMuted(notificationType string) bool {
+	for _, muted := range user.MutedNotificationTypes {
+		if muted == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepeatNotification reports whether the recipient of n was already told
+// about the same interaction. A mention or reply is only ever notified once
+// per chirp, so editing the chirp doesn't notify again. Likes, rechirps and
+// follows can be undone and redone, so they only count as repeats of an
+// unread notification created after since
+func (ds *DBStructure) isRepeatNotification(n Notification, since time.Time) bool {
+	chirpScoped := n.Type == NotificationMention || n.Type == NotificationReply
+	for _, existing := range ds.Notifications {
+		if existing.UserId != n.UserId || existing.Type != n.Type || existing.ChirpId != n.ChirpId {
+			continue
+		}
+		if chirpScoped {
+			return true
+		}
+		if existing.ActorId == n.ActorId && existing.ReadAt == nil && existing.CreatedAt.After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUserNotifications deletes the notifications a user received and the
+// ones caused by them
+func (ds *DBStructure) removeUserNotifications(userId int) {
+	for id, notification := range ds.Notifications {
+		if notification.UserId == userId || notification.ActorId == userId {
+			delete(ds.Notifications, id)
+		}
+	}
+}
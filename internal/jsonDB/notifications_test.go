@@ -0,0 +1,90 @@
+package jsonDB
+
+import "testing"
+
+func TestCreateNotificationsSkipsRecentRepeats(t *testing.T) {
+	db := newTestDB(t)
+	alice := mustCreateUser(t, db, "a@example.com")
+	bob := mustCreateUser(t, db, "b@example.com")
+	follow := Notification{UserId: alice.Id, Type: NotificationFollow, ActorId: bob.Id}
+
+	create := func() int {
+		t.Helper()
+		created, err := db.CreateNotifications([]Notification{follow})
+		if err != nil {
+			t.Fatalf("CreateNotifications: %s", err)
+		}
+		return len(created)
+	}
+
+	if n := create(); n != 1 {
+		t.Fatalf("created %d notifications, want 1", n)
+	}
+	// bob unfollows and follows again right away
+	if n := create(); n != 0 {
+		t.Errorf("repeat of an unread notification: created %d, want 0", n)
+	}
+
+	_, err := db.MarkNotificationsRead(alice.Id, nil)
+	if err != nil {
+		t.Fatalf("MarkNotificationsRead: %s", err)
+	}
+	if n := create(); n != 1 {
+		t.Errorf("repeat of a read notification: created %d, want 1", n)
+	}
+
+	// an unread notification stops suppressing repeats once it is old
+	err = db.update(func(ds *DBStructure) error {
+		for id, n := range ds.Notifications {
+			n.CreatedAt = n.CreatedAt.Add(-2 * NotificationRepeatWindow)
+			ds.Notifications[id] = n
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if n := create(); n != 1 {
+		t.Errorf("repeat of an old notification: created %d, want 1", n)
+	}
+}
+
+func TestCreateNotificationsNotifiesMentionsOnce(t *testing.T) {
+	db := newTestDB(t)
+	alice := mustCreateUser(t, db, "a@example.com")
+	bob := mustCreateUser(t, db, "b@example.com")
+	chirp := mustCreateChirp(t, db, bob.Id, "hi @alice")
+	mention := Notification{UserId: alice.Id, Type: NotificationMention, ActorId: bob.Id, ChirpId: chirp.Id}
+
+	created, err := db.CreateNotifications([]Notification{mention})
+	if err != nil {
+		t.Fatalf("CreateNotifications: %s", err)
+	}
+	if len(created) != 1 {
+		t.Fatalf("created %d notifications, want 1", len(created))
+	}
+
+	_, err = db.MarkNotificationsRead(alice.Id, nil)
+	if err != nil {
+		t.Fatalf("MarkNotificationsRead: %s", err)
+	}
+	err = db.update(func(ds *DBStructure) error {
+		for id, n := range ds.Notifications {
+			n.CreatedAt = n.CreatedAt.Add(-2 * NotificationRepeatWindow)
+			ds.Notifications[id] = n
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %s", err)
+	}
+
+	// bob edits the chirp, which notifies its mentions again
+	created, err = db.CreateNotifications([]Notification{mention})
+	if err != nil {
+		t.Fatalf("CreateNotifications: %s", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("mention of an already notified chirp: created %d, want 0", len(created))
+	}
+}
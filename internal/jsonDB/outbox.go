@@ -0,0 +1,162 @@
+package jsonDB
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Types of the domain events recorded in the outbox
+const (
+	OutboxChirpCreated   = "chirp.created"
+	OutboxChirpDeleted   = "chirp.deleted"
+	OutboxChirpEdited    = "chirp.edited"
+	OutboxChirpHidden    = "chirp.hidden"
+	OutboxChirpRestored  = "chirp.restored"
+	OutboxChirpLiked     = "chirp.liked"
+	OutboxChirpRechirped = "chirp.rechirped"
+	OutboxUserCreated    = "user.created"
+	OutboxUserUpgraded   = "user.upgraded"
+	OutboxUserFollowed   = "user.followed"
+	OutboxTokenRevoked   = "token.revoked"
+)
+
+// OutboxEvent is a domain event saved in the same write as the change it
+// describes, so it is delivered to subscribers even if the server stops
+// right after the write. DispatchedAt is set once synchronous subscribers
+// have seen the event and ProcessedAt once asynchronous subscribers have
+type OutboxEvent struct {
+	Id           int             `json:"id"`
+	Type         string          `json:"type"`
+	Payload      json.RawMessage `json:"payload"`
+	CreatedAt    time.Time       `json:"created_at"`
+	DispatchedAt *time.Time      `json:"dispatched_at,omitempty"`
+	ProcessedAt  *time.Time      `json:"processed_at,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// OnEventsRecorded sets a function that is called after every write that
+// recorded outbox events, once the write is on disk
+func (db *DB) OnEventsRecorded(fn func()) {
+	db.onEvents = fn
+}
+
+// recordEvent adds an event to the outbox, to be saved with the next write
+func (ds *DBStructure) recordEvent(eventType string, payload interface{}) error {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("can't encode %s event: %w", eventType, err)
+	}
+
+	ds.LastOutboxId++
+	ds.Outbox[ds.LastOutboxId] = OutboxEvent{
+		Id:        ds.LastOutboxId,
+		Type:      eventType,
+		Payload:   dat,
+		CreatedAt: time.Now().UTC(),
+	}
+	ds.recordedEvents++
+	return nil
+}
+
+// EventUser is the part of a user recorded with user events. Credentials
+// and private settings are left out, since events stay in the outbox for a
+// while and are passed on to webhooks
+type EventUser struct {
+	Id          int       `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (user User) eventUser() EventUser {
+	return EventUser{
+		Id:          user.Id,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		IsChirpyRed: user.IsChirpyRed(time.Now()),
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// GetPendingOutboxEvents returns the events that haven't been processed yet,
+// in the order they were recorded
+func (db *DB) GetPendingOutboxEvents() ([]OutboxEvent, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	pending := []OutboxEvent{}
+	for _, ev := range ds.Outbox {
+		if ev.ProcessedAt == nil {
+			pending = append(pending, ev)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Id < pending[j].Id
+	})
+
+	return pending, nil
+}
+
+// MarkOutboxEventsDispatched records that synchronous subscribers have seen
+// events
+func (db *DB) MarkOutboxEventsDispatched(ids []int) error {
+	return db.updateOutboxEvents(ids, func(ev *OutboxEvent, now time.Time) {
+		ev.DispatchedAt = &now
+	})
+}
+
+// MarkOutboxEventProcessed records that asynchronous subscribers have seen
+// an event. errMsg describes subscribers that failed, if any
+func (db *DB) MarkOutboxEventProcessed(id int, errMsg string) error {
+	return db.updateOutboxEvents([]int{id}, func(ev *OutboxEvent, now time.Time) {
+		ev.ProcessedAt = &now
+		ev.Error = errMsg
+	})
+}
+
+func (db *DB) updateOutboxEvents(ids []int, update func(ev *OutboxEvent, now time.Time)) error {
+	return db.update(func(ds *DBStructure) error {
+		now := time.Now().UTC()
+		for _, id := range ids {
+			ev, ok := ds.Outbox[id]
+			if !ok {
+				return ErrDoesNotExists
+			}
+			update(&ev, now)
+			ds.Outbox[id] = ev
+		}
+		return nil
+	})
+}
+
+// PruneOutbox removes events processed before a time and returns how many
+// were removed
+func (db *DB) PruneOutbox(before time.Time) (int, error) {
+	pruned := 0
+	err := db.update(func(ds *DBStructure) error {
+		for id, ev := range ds.Outbox {
+			if ev.ProcessedAt != nil && ev.ProcessedAt.Before(before) {
+				delete(ds.Outbox, id)
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,92 @@
+package jsonDB
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entities"
+)
+
+// ChirpRevision is an earlier version of an edited chirp. WrittenAt is when
+// the version was posted and ReplacedAt when it was edited away
+type ChirpRevision struct {
+	Body       string            `json:"body"`
+	Entities   entities.Entities `json:"entities"`
+	WrittenAt  time.Time         `json:"written_at"`
+	ReplacedAt time.Time         `json:"replaced_at"`
+}
+
+// ChirpEdit is the new content of an edited chirp
+type ChirpEdit struct {
+	Body         string
+	Entities     entities.Entities
+	FlaggedWords []string
+}
+
+// EditChirp replaces the body of a chirp, keeping the previous version in
+// its revision history. Only the author can edit a chirp, and only within
+// editWindow of posting it. An edit that leaves the body as it is changes
+// nothing and returns the chirp as it was
+func (db *DB) EditChirp(chirpId, userId int, edit ChirpEdit, editWindow time.Duration) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		chirp, ok = ds.Chirps[chirpId]
+		if !ok || !chirp.Visible() {
+			return ErrDoesNotExists
+		}
+
+		if chirp.AuthorId != userId {
+			return ErrNotAuthorized
+		}
+
+		now := time.Now().UTC()
+		if now.After(chirp.CreatedAt.Add(editWindow)) {
+			return ErrEditWindowExpired
+		}
+		if edit.Body == chirp.Body {
+			return errNoChanges
+		}
+
+		ds.Revisions[chirpId] = append(ds.Revisions[chirpId], ChirpRevision{
+			Body:       chirp.Body,
+			Entities:   chirp.Entities,
+			WrittenAt:  chirp.UpdatedAt,
+			ReplacedAt: now,
+		})
+
+		chirp.Body = edit.Body
+		chirp.Entities = edit.Entities
+		chirp.FlaggedWords = edit.FlaggedWords
+		chirp.Edited = true
+		chirp.UpdatedAt = now
+		ds.Chirps[chirpId] = chirp
+		ds.flagChirp(chirp)
+		return ds.recordEvent(OutboxChirpEdited, chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// GetChirpRevisions returns the earlier versions of a chirp, oldest first
+func (db *DB) GetChirpRevisions(chirpId int) ([]ChirpRevision, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, ok := ds.Chirps[chirpId]
+	if !ok || chirp.Deleted {
+		return nil, ErrDoesNotExists
+	}
+
+	revisions := ds.Revisions[chirpId]
+	if revisions == nil {
+		revisions = []ChirpRevision{}
+	}
+
+	return revisions, nil
+}
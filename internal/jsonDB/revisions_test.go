@@ -0,0 +1,53 @@
+package jsonDB
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEditChirp(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "a@example.com")
+	chirp := mustCreateChirp(t, db, user.Id, "first")
+
+	unchanged, err := db.EditChirp(chirp.Id, user.Id, ChirpEdit{Body: "first"}, time.Hour)
+	if err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	if unchanged.Edited || !unchanged.UpdatedAt.Equal(chirp.UpdatedAt) {
+		t.Errorf("edit without changes marked the chirp edited: %+v", unchanged)
+	}
+	revisions, err := db.GetChirpRevisions(chirp.Id)
+	if err != nil {
+		t.Fatalf("GetChirpRevisions: %s", err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("edit without changes added %d revisions", len(revisions))
+	}
+
+	edited, err := db.EditChirp(chirp.Id, user.Id, ChirpEdit{Body: "second"}, time.Hour)
+	if err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	if !edited.Edited || edited.Body != "second" {
+		t.Errorf("edited chirp = %+v", edited)
+	}
+	revisions, err = db.GetChirpRevisions(chirp.Id)
+	if err != nil {
+		t.Fatalf("GetChirpRevisions: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].Body != "first" {
+		t.Errorf("revisions = %+v, want the first version", revisions)
+	}
+
+	other := mustCreateUser(t, db, "b@example.com")
+	_, err = db.EditChirp(chirp.Id, other.Id, ChirpEdit{Body: "third"}, time.Hour)
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("edit by another user: err = %v, want %v", err, ErrNotAuthorized)
+	}
+	_, err = db.EditChirp(chirp.Id, user.Id, ChirpEdit{Body: "third"}, -time.Second)
+	if !errors.Is(err, ErrEditWindowExpired) {
+		t.Errorf("edit after the window: err = %v, want %v", err, ErrEditWindowExpired)
+	}
+}
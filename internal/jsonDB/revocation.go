@@ -5,24 +5,20 @@ import (
 	"time"
 )
 
-func (db *DB) RevokeToken(token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	revocation := Revocation{
-		Token:     token,
-		RevokedAt: time.Now().UTC(),
-	}
-	dbStructure.Revocations[token] = revocation
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// RevokeToken revokes a refresh token issued to a user. The token itself is
+// left out of the recorded event
+func (db *DB) RevokeToken(token string, userId int) error {
+	return db.update(func(ds *DBStructure) error {
+		revocation := Revocation{
+			Token:     token,
+			UserId:    userId,
+			RevokedAt: time.Now().UTC(),
+		}
+		ds.Revocations[token] = revocation
+
+		revocation.Token = ""
+		return ds.recordEvent(OutboxTokenRevoked, revocation)
+	})
 }
 
 func (db *DB) IsTokenRevoked(token string) (bool, error) {
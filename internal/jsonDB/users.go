@@ -1,38 +1,125 @@
 package jsonDB
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
-func (db *DB) CreateUser(email string, password string) (User, error) {
+// CreateUser creates a new user and saves it to disk. If handle is empty a
+// unique handle is generated from the user's ID
+func (db *DB) CreateUser(email, password, handle string) (User, error) {
+	user := User{}
+	err := db.update(func(ds *DBStructure) error {
+		highestID := 0
+		for _, user := range ds.Users {
+			if email == user.Email {
+				return ErrAlreadyExists
+			}
+			if user.Id > highestID {
+				highestID = user.Id
+			}
+		}
+
+		if handle == "" {
+			handle = generateHandle(ds, highestID+1)
+		} else if isHandleTaken(ds, handle, 0) {
+			return ErrHandleTaken
+		}
+
+		now := time.Now().UTC()
+		user = User{
+			Id:            highestID + 1,
+			Email:         email,
+			Password:      password,
+			Handle:        handle,
+			Is_chirpy_red: false,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+
+		ds.Users[user.Id] = user
+		return ds.recordEvent(OutboxUserCreated, user.eventUser())
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// GetUser returns the user with a specific ID
+func (db *DB) GetUser(userId int) (User, error) {
 	ds, err := db.loadDB()
 	if err != nil {
 		return User{}, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	highestID := 0
-	for _, user := range ds.Users {
-		if email == user.Email {
-			return User{}, ErrAlreadyExists
-		}
-		if user.Id > highestID {
-			highestID = user.Id
+	user, ok := ds.Users[userId]
+	if !ok {
+		return User{}, ErrDoesNotExists
+	}
+
+	return user, nil
+}
+
+// GetUsers returns the users with the given IDs keyed by ID. IDs that don't
+// belong to a user are left out
+func (db *DB) GetUsers(userIds []int) (map[int]User, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	users := make(map[int]User, len(userIds))
+	for _, id := range userIds {
+		user, ok := ds.Users[id]
+		if ok {
+			users[id] = user
 		}
 	}
 
-	user := User{
-		Id:            highestID + 1,
-		Email:         email,
-		Password:      password,
-		Is_chirpy_red: false,
+	return users, nil
+}
+
+// GetUserByHandle returns the user with a specific handle, ignoring case
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return User{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	for _, user := range ds.Users {
+		if strings.EqualFold(user.Handle, handle) {
+			return user, nil
+		}
 	}
 
-	ds.Users[user.Id] = user
+	return User{}, ErrDoesNotExists
+}
 
-	err = db.writeDB(ds)
+// GetUsersByHandles returns the users with the given handles, keyed by the
+// lower case handle. Unknown handles are left out
+func (db *DB) GetUsersByHandles(handles []string) (map[string]User, error) {
+	ds, err := db.loadDB()
 	if err != nil {
-		return user, fmt.Errorf("failed to write to database: %s", err)
+		return nil, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	return user, nil
+	wanted := make(map[string]bool, len(handles))
+	for _, handle := range handles {
+		wanted[strings.ToLower(handle)] = true
+	}
+
+	users := map[string]User{}
+	for _, user := range ds.Users {
+		handle := strings.ToLower(user.Handle)
+		if wanted[handle] {
+			users[handle] = user
+		}
+	}
+
+	return users, nil
 }
 
 func (db *DB) GetUserByEmail(email string) (User, error) {
@@ -52,48 +139,212 @@ func (db *DB) GetUserByEmail(email string) (User, error) {
 }
 
 func (db *DB) UpdateUser(userId int, newEmail, newPassword string) (User, error) {
-	ds, err := db.loadDB()
+	return db.updateUser(userId, func(ds *DBStructure, user *User) error {
+		user.Email = newEmail
+		user.Password = newPassword
+		user.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// updateUser applies change to a user and saves it
+func (db *DB) updateUser(userId int, change func(ds *DBStructure, user *User) error) (User, error) {
+	user := User{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		user, ok = ds.Users[userId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		err := change(ds, &user)
+		if err != nil {
+			return err
+		}
+		ds.Users[userId] = user
+		return nil
+	})
 	if err != nil {
-		return User{}, fmt.Errorf("failed to load database: %s", err)
+		return User{}, err
 	}
 
-	user, ok := ds.Users[userId]
-	if !ok {
-		return User{}, ErrDoesNotExists
-	}
+	return user, nil
+}
 
-	user.Email = newEmail
-	user.Password = newPassword
+// ProfileUpdate holds the profile fields to change. Nil fields are left as
+// they are
+type ProfileUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
 
-	ds.Users[userId] = user
+// UpdateProfile changes the public profile of a user
+func (db *DB) UpdateProfile(userId int, update ProfileUpdate) (User, error) {
+	return db.updateUser(userId, func(ds *DBStructure, user *User) error {
+		if update.Handle != nil {
+			if isHandleTaken(ds, *update.Handle, userId) {
+				return ErrHandleTaken
+			}
+			user.Handle = *update.Handle
+		}
+		if update.DisplayName != nil {
+			user.DisplayName = *update.DisplayName
+		}
+		if update.Bio != nil {
+			user.Bio = *update.Bio
+		}
+		if update.AvatarURL != nil {
+			user.AvatarURL = *update.AvatarURL
+		}
+		user.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+}
 
-	err = db.writeDB(ds)
-	if err != nil {
-		return User{}, fmt.Errorf("failed to save user in database: %s", err)
+// isHandleTaken reports whether a user other than exceptUserId already uses
+// the handle. Handles are compared case-insensitively
+func isHandleTaken(ds *DBStructure, handle string, exceptUserId int) bool {
+	for _, user := range ds.Users {
+		if user.Id != exceptUserId && strings.EqualFold(user.Handle, handle) {
+			return true
+		}
 	}
+	return false
+}
 
-	return user, nil
+// generateHandle returns a free handle of the form user<id>
+func generateHandle(ds *DBStructure, userId int) string {
+	handle := fmt.Sprintf("user%d", userId)
+	for i := 1; isHandleTaken(ds, handle, 0); i++ {
+		handle = fmt.Sprintf("user%d_%d", userId, i)
+	}
+	return handle
 }
 
-// Add function to upgrade user membership
-func (db *DB) UpgradeUser(userId int) (User, error) {
+// GetMembershipEvents returns the membership history of a user, oldest first
+func (db *DB) GetMembershipEvents(userId int) ([]MembershipEvent, error) {
 	ds, err := db.loadDB()
 	if err != nil {
-		return User{}, fmt.Errorf("failed to load database: %s", err)
+		return nil, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	user, ok := ds.Users[userId]
-	if !ok {
-		return User{}, ErrDoesNotExists
+	events := []MembershipEvent{}
+	for _, event := range ds.MembershipEvents {
+		if event.UserId == userId {
+			events = append(events, event)
+		}
 	}
 
-	user.Is_chirpy_red = true
-	ds.Users[userId] = user
+	return events, nil
+}
+
+// ScheduleUserDeletion marks a user for deletion at purgeAt. Until then the
+// deletion can be cancelled with CancelUserDeletion. The user's sessions are
+// revoked: tokens issued before now stay invalid even if the deletion is
+// cancelled
+func (db *DB) ScheduleUserDeletion(userId int, purgeAt time.Time, anonymizeChirps bool) (User, error) {
+	return db.updateUser(userId, func(ds *DBStructure, user *User) error {
+		purgeAt = purgeAt.UTC()
+		user.DeletionScheduledAt = &purgeAt
+		// tokens only record the second they were issued in
+		revokedAt := time.Now().UTC().Truncate(time.Second)
+		user.SessionsRevokedAt = &revokedAt
+		user.AnonymizeOnDelete = anonymizeChirps
+		return nil
+	})
+}
+
+// SessionRevoked reports whether a token issued to the user at issuedAt has
+// been revoked along with all of the user's sessions. Tokens only record the
+// second they were issued in, so tokens from the same second as the
+// revocation are revoked too, even when they were issued just after it. A
+// user who logs in again in that second has to log in once more
+func (user User) SessionRevoked(issuedAt time.Time) bool {
+	return user.SessionsRevokedAt != nil && !issuedAt.After(*user.SessionsRevokedAt)
+}
+
+// CancelUserDeletion removes a pending deletion from a user
+func (db *DB) CancelUserDeletion(userId int) (User, error) {
+	return db.updateUser(userId, func(ds *DBStructure, user *User) error {
+		user.DeletionScheduledAt = nil
+		user.AnonymizeOnDelete = false
+		return nil
+	})
+}
+
+// PurgeResult describes what PurgeDeletedUsers removed. The blobs of the
+// removed media still have to be deleted from the blob store
+type PurgeResult struct {
+	Users    int
+	MediaIds []string
+}
+
+// PurgeDeletedUsers permanently removes every user whose deletion was
+// scheduled before now. Their chirps are either deleted or kept without an
+// author, and their membership history and uploaded media are removed
+func (db *DB) PurgeDeletedUsers(now time.Time) (PurgeResult, error) {
+	result := PurgeResult{}
+	err := db.update(func(ds *DBStructure) error {
+		for id, user := range ds.Users {
+			if user.DeletionScheduledAt == nil || user.DeletionScheduledAt.After(now) {
+				continue
+			}
+
+			ds.removeUserEngagements(id)
+			ds.removeUserNotifications(id)
+			ds.removeUserFederation(id)
+
+			for mediaId, media := range ds.Media {
+				if media.OwnerId == id {
+					delete(ds.Media, mediaId)
+					result.MediaIds = append(result.MediaIds, mediaId)
+				}
+			}
+
+			for chirpId, chirp := range ds.Chirps {
+				if chirp.AuthorId != id {
+					continue
+				}
+				if user.AnonymizeOnDelete {
+					chirp.AuthorId = 0
+					chirp.MediaIds = nil
+					ds.Chirps[chirpId] = chirp
+				} else {
+					err := ds.deleteChirp(chirpId)
+					if err != nil {
+						return err
+					}
+				}
+			}
 
-	err = db.writeDB(ds)
+			events := ds.MembershipEvents[:0]
+			for _, event := range ds.MembershipEvents {
+				if event.UserId != id {
+					events = append(events, event)
+				}
+			}
+			ds.MembershipEvents = events
+
+			for key, follow := range ds.Follows {
+				if follow.FollowerId == id || follow.FolloweeId == id {
+					delete(ds.Follows, key)
+				}
+			}
+
+			delete(ds.Users, id)
+			result.Users++
+		}
+
+		if result.Users == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
 	if err != nil {
-		return User{}, fmt.Errorf("failed to save user in database: %s", err)
+		return PurgeResult{}, err
 	}
 
-	return user, nil
+	return result, nil
 }
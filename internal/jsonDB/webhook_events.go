@@ -0,0 +1,83 @@
+package jsonDB
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Statuses of a received webhook event
+const (
+	WebhookProcessing = "processing"
+	WebhookProcessed  = "processed"
+	WebhookIgnored    = "ignored"
+	WebhookFailed     = "failed"
+)
+
+// WebhookEvent is a webhook received from a third party, kept so retried
+// deliveries are only processed once and for auditing. Deliveries counts
+// every time the event was received. StartedAt is when processing last
+// started
+type WebhookEvent struct {
+	Id          string          `json:"id"`
+	Source      string          `json:"source"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Deliveries  int             `json:"deliveries"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	StartedAt   time.Time       `json:"started_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+// BeginWebhookEvent records the delivery of a webhook event and reports
+// whether it should be processed. Events that are already processed, ignored
+// or being processed are duplicates, failed events are processed again. An
+// event that has been processing for longer than staleAfter is taken to
+// have been abandoned, for instance by a crash, and is processed again
+func (db *DB) BeginWebhookEvent(event WebhookEvent, staleAfter time.Duration) (WebhookEvent, bool, error) {
+	stored := WebhookEvent{}
+	process := false
+	err := db.update(func(ds *DBStructure) error {
+		now := time.Now().UTC()
+		var ok bool
+		stored, ok = ds.WebhookEvents[event.Id]
+		if !ok {
+			stored = event
+			stored.ReceivedAt = now
+		}
+		stored.Deliveries++
+
+		stale := stored.Status == WebhookProcessing && now.Sub(stored.StartedAt) > staleAfter
+		process = !ok || stored.Status == WebhookFailed || stale
+		if process {
+			stored.Status = WebhookProcessing
+			stored.Error = ""
+			stored.StartedAt = now
+		}
+		ds.WebhookEvents[event.Id] = stored
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, false, err
+	}
+
+	return stored, process, nil
+}
+
+// FinishWebhookEvent stores the outcome of processing a webhook event
+func (db *DB) FinishWebhookEvent(id, status, errorMessage string) error {
+	return db.update(func(ds *DBStructure) error {
+		event, ok := ds.WebhookEvents[id]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		now := time.Now().UTC()
+		event.Status = status
+		event.Error = errorMessage
+		event.ProcessedAt = &now
+		ds.WebhookEvents[id] = event
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package jsonDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBeginWebhookEvent(t *testing.T) {
+	db := newTestDB(t)
+	event := WebhookEvent{Id: "evt_1", Source: "polka", Event: EventUserUpgraded}
+
+	_, process, err := db.BeginWebhookEvent(event, time.Hour)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+	if !process {
+		t.Fatal("new event was not processed")
+	}
+
+	stored, process, err := db.BeginWebhookEvent(event, time.Hour)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+	if process {
+		t.Error("event being processed was processed again")
+	}
+	if stored.Deliveries != 2 {
+		t.Errorf("Deliveries = %d, want 2", stored.Deliveries)
+	}
+
+	err = db.FinishWebhookEvent(event.Id, WebhookFailed, "boom")
+	if err != nil {
+		t.Fatalf("FinishWebhookEvent: %s", err)
+	}
+	_, process, err = db.BeginWebhookEvent(event, time.Hour)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+	if !process {
+		t.Error("failed event was not processed again")
+	}
+
+	err = db.FinishWebhookEvent(event.Id, WebhookProcessed, "")
+	if err != nil {
+		t.Fatalf("FinishWebhookEvent: %s", err)
+	}
+	_, process, err = db.BeginWebhookEvent(event, time.Hour)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+	if process {
+		t.Error("processed event was processed again")
+	}
+}
+
+func TestBeginWebhookEventReclaimsStaleProcessing(t *testing.T) {
+	db := newTestDB(t)
+	event := WebhookEvent{Id: "evt_1", Source: "polka", Event: EventUserUpgraded}
+
+	_, _, err := db.BeginWebhookEvent(event, time.Hour)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+
+	// nothing finished the event, as if the server stopped while processing
+	stored, process, err := db.BeginWebhookEvent(event, -time.Second)
+	if err != nil {
+		t.Fatalf("BeginWebhookEvent: %s", err)
+	}
+	if !process {
+		t.Fatal("stale event was not processed again")
+	}
+	if stored.Status != WebhookProcessing {
+		t.Errorf("Status = %q, want %q", stored.Status, WebhookProcessing)
+	}
+}
@@ -0,0 +1,368 @@
+package jsonDB
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Events that webhook subscriptions can be notified of
+const (
+	WebhookChirpCreated   = "chirp.created"
+	WebhookChirpDeleted   = "chirp.deleted"
+	WebhookUserCreated    = "user.created"
+	WebhookUserUpgraded   = "user.upgraded"
+	WebhookSessionRevoked = "session.revoked"
+)
+
+var WebhookEventTypes = []string{
+	WebhookChirpCreated,
+	WebhookChirpDeleted,
+	WebhookUserCreated,
+	WebhookUserUpgraded,
+	WebhookSessionRevoked,
+}
+
+// Statuses of a webhook delivery. Deliveries that keep failing end up dead
+// and are only tried again when retried by hand
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription asks for events to be posted to a URL. Payloads are
+// signed with Secret
+type WebhookSubscription struct {
+	Id        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the subscription wants events of a type
+func (sub WebhookSubscription) Subscribes(event string) bool {
+	for _, e := range sub.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookAttempt is one try at delivering a webhook. StatusCode is 0 when
+// no response was received
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery is an event queued for a subscription, with a log of the
+// attempts to deliver it
+type WebhookDelivery struct {
+	Id             int              `json:"id"`
+	SubscriptionId int              `json:"subscription_id"`
+	Event          string           `json:"event"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+	NextAttemptAt  time.Time        `json:"next_attempt_at"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+	DeadAt         *time.Time       `json:"dead_at,omitempty"`
+}
+
+// WebhookSubscriptionUpdate holds the fields of a subscription to change.
+// Nil fields are left as they are
+type WebhookSubscriptionUpdate struct {
+	URL    *string
+	Events *[]string
+	Active *bool
+}
+
+func (db *DB) CreateWebhookSubscription(url string, events []string, secret string) (WebhookSubscription, error) {
+	sub := WebhookSubscription{}
+	err := db.update(func(ds *DBStructure) error {
+		ds.LastWebhookSubscriptionId++
+		now := time.Now().UTC()
+		sub = WebhookSubscription{
+			Id:        ds.LastWebhookSubscriptionId,
+			URL:       url,
+			Events:    events,
+			Secret:    secret,
+			Active:    true,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		ds.WebhookSubscriptions[sub.Id] = sub
+		return nil
+	})
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+// GetWebhookSubscriptions returns every subscription, oldest first
+func (db *DB) GetWebhookSubscriptions() ([]WebhookSubscription, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	subs := make([]WebhookSubscription, 0, len(ds.WebhookSubscriptions))
+	for _, sub := range ds.WebhookSubscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Id < subs[j].Id
+	})
+
+	return subs, nil
+}
+
+func (db *DB) GetWebhookSubscription(id int) (WebhookSubscription, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	sub, ok := ds.WebhookSubscriptions[id]
+	if !ok {
+		return WebhookSubscription{}, ErrDoesNotExists
+	}
+
+	return sub, nil
+}
+
+func (db *DB) UpdateWebhookSubscription(id int, update WebhookSubscriptionUpdate) (WebhookSubscription, error) {
+	sub := WebhookSubscription{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		sub, ok = ds.WebhookSubscriptions[id]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		if update.URL != nil {
+			sub.URL = *update.URL
+		}
+		if update.Events != nil {
+			sub.Events = *update.Events
+		}
+		if update.Active != nil {
+			sub.Active = *update.Active
+		}
+		sub.UpdatedAt = time.Now().UTC()
+		ds.WebhookSubscriptions[id] = sub
+		return nil
+	})
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+// DeleteWebhookSubscription removes a subscription and its deliveries
+func (db *DB) DeleteWebhookSubscription(id int) error {
+	return db.update(func(ds *DBStructure) error {
+		if _, ok := ds.WebhookSubscriptions[id]; !ok {
+			return ErrDoesNotExists
+		}
+		delete(ds.WebhookSubscriptions, id)
+
+		for deliveryId, delivery := range ds.WebhookDeliveries {
+			if delivery.SubscriptionId == id {
+				delete(ds.WebhookDeliveries, deliveryId)
+			}
+		}
+		return nil
+	})
+}
+
+// EnqueueWebhookDeliveries queues an event for every active subscription to
+// its type. The deliveries are due right away
+func (db *DB) EnqueueWebhookDeliveries(event string, payload []byte) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := db.update(func(ds *DBStructure) error {
+		deliveries = ds.enqueueWebhookDeliveries(event, payload)
+		if len(deliveries) == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (ds *DBStructure) enqueueWebhookDeliveries(event string, payload []byte) []WebhookDelivery {
+	now := time.Now().UTC()
+	deliveries := []WebhookDelivery{}
+	for _, sub := range ds.WebhookSubscriptions {
+		if !sub.Active || !sub.Subscribes(event) {
+			continue
+		}
+
+		ds.LastWebhookDeliveryId++
+		delivery := WebhookDelivery{
+			Id:             ds.LastWebhookDeliveryId,
+			SubscriptionId: sub.Id,
+			Event:          event,
+			Payload:        payload,
+			Status:         DeliveryPending,
+			Attempts:       []WebhookAttempt{},
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		ds.WebhookDeliveries[delivery.Id] = delivery
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due, the longest waiting first
+func (db *DB) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	due := []WebhookDelivery{}
+	for _, delivery := range ds.WebhookDeliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Id < due[j].Id
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// RecordWebhookAttempt adds an attempt to the log of a delivery and moves it
+// to a new status. Pending deliveries are tried again at nextAttemptAt
+func (db *DB) RecordWebhookAttempt(deliveryId int, attempt WebhookAttempt, status string, nextAttemptAt time.Time) (WebhookDelivery, error) {
+	delivery := WebhookDelivery{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		delivery, ok = ds.WebhookDeliveries[deliveryId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = status
+		delivery.NextAttemptAt = nextAttemptAt
+		switch status {
+		case DeliveryDelivered:
+			delivery.DeliveredAt = &attempt.At
+		case DeliveryDead:
+			delivery.DeadAt = &attempt.At
+		}
+		ds.WebhookDeliveries[deliveryId] = delivery
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns the deliveries of a subscription, or of every
+// subscription when subscriptionId is 0, newest first. An empty status
+// returns deliveries with any status
+func (db *DB) GetWebhookDeliveries(subscriptionId int, status string) ([]WebhookDelivery, error) {
+	ds, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range ds.WebhookDeliveries {
+		if subscriptionId != 0 && delivery.SubscriptionId != subscriptionId {
+			continue
+		}
+		if status != "" && delivery.Status != status {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Id > deliveries[j].Id
+	})
+
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery makes a delivery pending again and due right away,
+// typically to bring back a dead delivery once the receiver is fixed
+func (db *DB) RetryWebhookDelivery(deliveryId int) (WebhookDelivery, error) {
+	delivery := WebhookDelivery{}
+	err := db.update(func(ds *DBStructure) error {
+		var ok bool
+		delivery, ok = ds.WebhookDeliveries[deliveryId]
+		if !ok {
+			return ErrDoesNotExists
+		}
+		if delivery.Status == DeliveryDelivered {
+			return ErrAlreadyExists
+		}
+
+		delivery.Status = DeliveryPending
+		delivery.NextAttemptAt = time.Now().UTC()
+		delivery.DeadAt = nil
+		ds.WebhookDeliveries[deliveryId] = delivery
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// PruneWebhookDeliveries removes deliveries that were delivered before
+// deliveredBefore or died before deadBefore, and returns how many were
+// removed. Pending deliveries are always kept
+func (db *DB) PruneWebhookDeliveries(deliveredBefore, deadBefore time.Time) (int, error) {
+	pruned := 0
+	err := db.update(func(ds *DBStructure) error {
+		for id, delivery := range ds.WebhookDeliveries {
+			delivered := delivery.DeliveredAt != nil && delivery.DeliveredAt.Before(deliveredBefore)
+			dead := delivery.Status == DeliveryDead && delivery.DeadAt != nil && delivery.DeadAt.Before(deadBefore)
+			if delivered || dead {
+				delete(ds.WebhookDeliveries, id)
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
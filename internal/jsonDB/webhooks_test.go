@@ -0,0 +1,115 @@
+package jsonDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliveryLifecycle(t *testing.T) {
+	db := newTestDB(t)
+	sub, err := db.CreateWebhookSubscription("https://example.com/hook", []string{WebhookChirpCreated}, "secret")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+	_, err = db.CreateWebhookSubscription("https://example.com/other", []string{WebhookUserCreated}, "secret")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+
+	deliveries, err := db.EnqueueWebhookDeliveries(WebhookChirpCreated, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("EnqueueWebhookDeliveries: %s", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].SubscriptionId != sub.Id {
+		t.Fatalf("deliveries = %+v, want one for subscription %d", deliveries, sub.Id)
+	}
+	delivery := deliveries[0]
+
+	now := time.Now().UTC()
+	due, err := db.GetDueWebhookDeliveries(now, 10)
+	if err != nil {
+		t.Fatalf("GetDueWebhookDeliveries: %s", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("got %d due deliveries, want 1", len(due))
+	}
+
+	next := now.Add(time.Minute)
+	_, err = db.RecordWebhookAttempt(delivery.Id, WebhookAttempt{At: now, StatusCode: 500}, DeliveryPending, next)
+	if err != nil {
+		t.Fatalf("RecordWebhookAttempt: %s", err)
+	}
+	due, _ = db.GetDueWebhookDeliveries(now, 10)
+	if len(due) != 0 {
+		t.Errorf("delivery is due again before its next attempt")
+	}
+	due, _ = db.GetDueWebhookDeliveries(next, 10)
+	if len(due) != 1 {
+		t.Errorf("delivery isn't due at its next attempt")
+	}
+
+	dead, err := db.RecordWebhookAttempt(delivery.Id, WebhookAttempt{At: now, StatusCode: 500}, DeliveryDead, time.Time{})
+	if err != nil {
+		t.Fatalf("RecordWebhookAttempt: %s", err)
+	}
+	if dead.DeadAt == nil || len(dead.Attempts) != 2 {
+		t.Fatalf("dead delivery = %+v, want DeadAt and two attempts", dead)
+	}
+
+	retried, err := db.RetryWebhookDelivery(delivery.Id)
+	if err != nil {
+		t.Fatalf("RetryWebhookDelivery: %s", err)
+	}
+	if retried.Status != DeliveryPending || retried.DeadAt != nil {
+		t.Errorf("retried delivery = %+v, want pending", retried)
+	}
+
+	_, err = db.RecordWebhookAttempt(delivery.Id, WebhookAttempt{At: now, StatusCode: 204}, DeliveryDelivered, time.Time{})
+	if err != nil {
+		t.Fatalf("RecordWebhookAttempt: %s", err)
+	}
+	_, err = db.RetryWebhookDelivery(delivery.Id)
+	if err != ErrAlreadyExists {
+		t.Errorf("retrying a delivered delivery: err = %v, want %v", err, ErrAlreadyExists)
+	}
+}
+
+func TestPruneWebhookDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.CreateWebhookSubscription("https://example.com/hook", []string{WebhookChirpCreated}, "secret")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+
+	enqueue := func() WebhookDelivery {
+		deliveries, err := db.EnqueueWebhookDeliveries(WebhookChirpCreated, []byte(`{}`))
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("EnqueueWebhookDeliveries: %v, %v", deliveries, err)
+		}
+		return deliveries[0]
+	}
+
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	delivered := enqueue()
+	db.RecordWebhookAttempt(delivered.Id, WebhookAttempt{At: old}, DeliveryDelivered, time.Time{})
+	dead := enqueue()
+	db.RecordWebhookAttempt(dead.Id, WebhookAttempt{At: old}, DeliveryDead, time.Time{})
+	pending := enqueue()
+
+	cutoff := time.Now().UTC().Add(-24 * time.Hour)
+	pruned, err := db.PruneWebhookDeliveries(cutoff, old.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PruneWebhookDeliveries: %s", err)
+	}
+	if pruned != 1 {
+		t.Errorf("pruned %d deliveries, want only the delivered one", pruned)
+	}
+
+	left, err := db.GetWebhookDeliveries(0, "")
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries: %s", err)
+	}
+	if len(left) != 2 || left[0].Id != pending.Id || left[1].Id != dead.Id {
+		t.Errorf("deliveries left = %+v, want the pending and dead ones", left)
+	}
+}
@@ -0,0 +1,227 @@
+// Package media validates uploaded images, strips their metadata and makes
+// thumbnails
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxPixels limits the decoded size of an image, so a small file can't
+	// expand into a huge bitmap
+	MaxPixels = 40_000_000
+	// MaxGIFFrames limits the number of frames in an animated GIF and
+	// MaxGIFPixels the pixels of all of its frames together
+	MaxGIFFrames = 300
+	MaxGIFPixels = 100_000_000
+	// ThumbnailSize is the longest side of a thumbnail
+	ThumbnailSize = 320
+
+	jpegQuality = 90
+)
+
+var ErrUnsupportedType = errors.New("unsupported media type")
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// AllowedTypes lists the content types that can be uploaded
+var AllowedTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Image is an uploaded image after processing
+type Image struct {
+	ContentType          string
+	Data                 []byte
+	Width                int
+	Height               int
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Process checks the real type of the uploaded data by sniffing it rather
+// than trusting the client, then decodes and re-encodes the image. Re-encoding
+// drops EXIF and any other metadata; the EXIF orientation of JPEGs is applied
+// to the pixels first so photos keep facing the right way
+func Process(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+	if !isAllowed(contentType) {
+		return Image{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return Image{}, ErrTooLarge
+	}
+
+	var buf bytes.Buffer
+	var first image.Image
+
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to decode jpeg: %w", err)
+		}
+		first = applyOrientation(img, exifOrientation(data))
+		err = jpeg.Encode(&buf, first, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to decode png: %w", err)
+		}
+		first = img
+		err = png.Encode(&buf, img)
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to encode png: %w", err)
+		}
+	case "image/gif":
+		err := checkGIFSize(data)
+		if err != nil {
+			return Image{}, err
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to decode gif: %w", err)
+		}
+		if len(g.Image) == 0 {
+			return Image{}, errors.New("gif has no frames")
+		}
+		first = g.Image[0]
+		err = gif.EncodeAll(&buf, g)
+		if err != nil {
+			return Image{}, fmt.Errorf("failed to encode gif: %w", err)
+		}
+	}
+
+	thumbnail, thumbnailType, err := makeThumbnail(first, contentType)
+	if err != nil {
+		return Image{}, err
+	}
+
+	bounds := first.Bounds()
+	return Image{
+		ContentType:          contentType,
+		Data:                 buf.Bytes(),
+		Width:                bounds.Dx(),
+		Height:               bounds.Dy(),
+		Thumbnail:            thumbnail,
+		ThumbnailContentType: thumbnailType,
+	}, nil
+}
+
+// checkGIFSize walks the blocks of a GIF without decoding them, so a GIF
+// with more frames or pixels than allowed is rejected before any memory is
+// spent on its frames. Malformed data is left for the decoder to reject
+func checkGIFSize(data []byte) error {
+	// header and logical screen descriptor
+	const headerSize = 13
+	if len(data) < headerSize {
+		return nil
+	}
+	pos := headerSize
+	if data[10]&0x80 != 0 {
+		pos += colorTableSize(data[10])
+	}
+
+	frames, pixels := 0, 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension, made of a label and data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+2)
+		case 0x2c: // image descriptor, then the image data
+			if pos+10 > len(data) {
+				return nil
+			}
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			frames++
+			pixels += width * height
+			if frames > MaxGIFFrames || pixels > MaxGIFPixels {
+				return ErrTooLarge
+			}
+
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += colorTableSize(flags)
+			}
+			// skip the LZW code size before the data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+1)
+		default: // trailer or malformed data
+			return nil
+		}
+	}
+	return nil
+}
+
+// colorTableSize returns the size in bytes of the color table described by
+// the flags of a GIF screen or image descriptor
+func colorTableSize(flags byte) int {
+	return 3 << (flags&0x07 + 1)
+}
+
+// skipGIFSubBlocks returns the position after the sub-blocks starting at
+// pos, which end with an empty block
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		n := int(data[pos])
+		pos += 1 + n
+		if n == 0 {
+			break
+		}
+	}
+	return pos
+}
+
+// makeThumbnail scales an image down to fit in a ThumbnailSize square. JPEGs
+// get JPEG thumbnails, other images PNG ones so transparency is kept
+func makeThumbnail(img image.Image, contentType string) ([]byte, string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width >= height {
+			height = maxInt(1, height*ThumbnailSize/width)
+			width = ThumbnailSize
+		} else {
+			width = maxInt(1, width*ThumbnailSize/height)
+			height = ThumbnailSize
+		}
+	}
+	thumb := resize(img, width, height)
+
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+
+	err := png.Encode(&buf, thumb)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+func isAllowed(contentType string) bool {
+	for _, allowed := range AllowedTypes {
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
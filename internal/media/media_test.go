@@ -0,0 +1,81 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, g)
+	if err != nil {
+		t.Fatalf("EncodeAll: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// gifWithFrames returns a GIF whose frames claim to be width by height
+// pixels but hold no image data
+func gifWithFrames(frames, width, height int) []byte {
+	dimensions := make([]byte, 4)
+	binary.LittleEndian.PutUint16(dimensions, uint16(width))
+	binary.LittleEndian.PutUint16(dimensions[2:], uint16(height))
+
+	data := append([]byte("GIF89a"), dimensions...)
+	data = append(data, 0, 0, 0)
+	for i := 0; i < frames; i++ {
+		data = append(data, 0x2c, 0, 0, 0, 0)
+		data = append(data, dimensions...)
+		// a local color table of two colors
+		data = append(data, 0x80, 0, 0, 0, 255, 255, 255)
+		// LZW code size and no data
+		data = append(data, 2, 0)
+	}
+	return append(data, 0x3b)
+}
+
+func TestProcessGIF(t *testing.T) {
+	img, err := Process(encodeGIF(t, 3))
+	if err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	if img.ContentType != "image/gif" || img.Width != 4 || img.Height != 4 {
+		t.Errorf("image = %s %dx%d, want a 4x4 gif", img.ContentType, img.Width, img.Height)
+	}
+
+	_, err = Process(encodeGIF(t, MaxGIFFrames+1))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("too many frames: err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestCheckGIFSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"small animation", gifWithFrames(10, 100, 100), nil},
+		{"too many pixels", gifWithFrames(2, 8000, 8000), ErrTooLarge},
+		{"too many frames", gifWithFrames(MaxGIFFrames+1, 1, 1), ErrTooLarge},
+		{"truncated", gifWithFrames(2, 8000, 8000)[:20], nil},
+	}
+	for _, tt := range tests {
+		err := checkGIFSize(tt.data)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package profanity
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps letters from other scripts that look like latin letters
+// to the latin letter
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'з': '3', 'і': 'i', 'ј': 'j', 'к': 'k',
+	'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y',
+	'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k',
+	'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin
+	'ı': 'i', 'ɑ': 'a', 'ɡ': 'g', 'ʀ': 'r', 'ß': 's',
+}
+
+// leetspeak maps digits and symbols used in place of letters
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '6': 'g', '7': 't',
+	'8': 'b', '9': 'g', '@': 'a', '$': 's', '!': 'i', '|': 'i', '+': 't',
+}
+
+const leetSymbols = "@$!|+"
+
+func isLeetSymbol(r rune) bool {
+	return strings.ContainsRune(leetSymbols, r)
+}
+
+// fold reduces a word to a skeleton that look-alike spellings share. The
+// word gets the compatibility mapping of NFKC, decomposed so accents can be
+// dropped, then is lower cased with confusable letters and leetspeak
+// replaced. Letters that are commonly swapped for each other, like l and i,
+// fold to the same letter
+func fold(word string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.IsMark(r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		if c, ok := leetspeak[r]; ok {
+			r = c
+		}
+		if r == 'l' {
+			r = 'i'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
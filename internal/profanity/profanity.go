@@ -0,0 +1,325 @@
+// Package profanity finds banned words in text. Words are matched whole and
+// case-insensitively after folding away accents, compatibility forms,
+// look-alike letters and leetspeak, and each word has an action deciding
+// whether it is masked, rejects the text or flags it for review
+package profanity
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Replacement is written in place of masked words
+const Replacement = "****"
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+)
+
+var ErrNoWordList = errors.New("filter has no word list file")
+
+// Rule is a banned word. A Prefix rule also matches longer words starting
+// with Word, so "kerfuffle*" catches "kerfuffles" and "kerfuffled"
+type Rule struct {
+	Word   string
+	Action Action
+	Prefix bool
+}
+
+// DefaultRules are used when no word list file is configured
+var DefaultRules = []Rule{
+	{Word: "kerfuffle", Action: ActionMask},
+	{Word: "sharbert", Action: ActionMask},
+	{Word: "fornax", Action: ActionMask},
+}
+
+// Match is a banned word found in a text. Start and End are byte offsets
+// into the checked text
+type Match struct {
+	Word   string
+	Action Action
+	Start  int
+	End    int
+}
+
+// Result is the outcome of checking a text. Text has every masked word
+// replaced
+type Result struct {
+	Text    string
+	Matches []Match
+}
+
+// Rejected reports whether the text contains a word that isn't allowed at all
+func (res Result) Rejected() bool {
+	for _, m := range res.Matches {
+		if m.Action == ActionReject {
+			return true
+		}
+	}
+	return false
+}
+
+// FlaggedWords returns the distinct words that need a moderator to look at
+// the text
+func (res Result) FlaggedWords() []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, m := range res.Matches {
+		if m.Action != ActionFlag || seen[m.Word] {
+			continue
+		}
+		seen[m.Word] = true
+		words = append(words, m.Word)
+	}
+	return words
+}
+
+// Filter checks text against a set of rules. It is safe for concurrent use,
+// and the rules of a filter loaded from a file can be swapped out with
+// Reload while it is in use
+type Filter struct {
+	mu       sync.RWMutex
+	path     string
+	exact    map[string]Rule
+	prefixes []prefixRule
+}
+
+type prefixRule struct {
+	folded string
+	rule   Rule
+}
+
+func New(rules []Rule) *Filter {
+	f := &Filter{}
+	f.setRules(rules)
+	return f
+}
+
+// Load creates a filter from a word list file
+func Load(path string) (*Filter, error) {
+	rules, err := readWordList(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := New(rules)
+	f.path = path
+	return f, nil
+}
+
+// Reload reads the word list file again and returns the number of rules in
+// it. On error the filter keeps its current rules
+func (f *Filter) Reload() (int, error) {
+	if f.path == "" {
+		return 0, ErrNoWordList
+	}
+
+	rules, err := readWordList(f.path)
+	if err != nil {
+		return 0, err
+	}
+
+	f.setRules(rules)
+	return len(rules), nil
+}
+
+func (f *Filter) setRules(rules []Rule) {
+	exact := map[string]Rule{}
+	prefixes := []prefixRule{}
+	for _, rule := range rules {
+		if rule.Prefix {
+			prefixes = append(prefixes, prefixRule{folded: fold(rule.Word), rule: rule})
+			continue
+		}
+		exact[fold(rule.Word)] = rule
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exact = exact
+	f.prefixes = prefixes
+}
+
+// Check finds the banned words in text and masks the ones that should be
+// masked
+func (f *Filter) Check(text string) Result {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	matches := []Match{}
+	for _, span := range wordSpans(text) {
+		rule, start, end, ok := f.matchSpan(text, span[0], span[1])
+		if !ok {
+			continue
+		}
+		matches = append(matches, Match{
+			Word:   rule.Word,
+			Action: rule.Action,
+			Start:  start,
+			End:    end,
+		})
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.Action != ActionMask {
+			continue
+		}
+		sb.WriteString(text[last:m.Start])
+		sb.WriteString(Replacement)
+		last = m.End
+	}
+	sb.WriteString(text[last:])
+
+	return Result{
+		Text:    sb.String(),
+		Matches: matches,
+	}
+}
+
+// matchSpan looks a word up in the rules. Symbols that double as leetspeak
+// are also tried trimmed off the ends, so "@fornax" or "fornax!" match on
+// "fornax" and only that part is masked
+func (f *Filter) matchSpan(text string, start, end int) (Rule, int, int, bool) {
+	trimmedStart, trimmedEnd := start, end
+	for trimmedStart < trimmedEnd && isLeetSymbol(rune(text[trimmedStart])) {
+		trimmedStart++
+	}
+	for trimmedEnd > trimmedStart && isLeetSymbol(rune(text[trimmedEnd-1])) {
+		trimmedEnd--
+	}
+
+	if trimmedStart < trimmedEnd {
+		if rule, ok := f.lookup(fold(text[trimmedStart:trimmedEnd])); ok {
+			return rule, trimmedStart, trimmedEnd, true
+		}
+	}
+	if trimmedStart != start || trimmedEnd != end {
+		if rule, ok := f.lookup(fold(text[start:end])); ok {
+			return rule, start, end, true
+		}
+	}
+
+	return Rule{}, 0, 0, false
+}
+
+func (f *Filter) lookup(folded string) (Rule, bool) {
+	if folded == "" {
+		return Rule{}, false
+	}
+	if rule, ok := f.exact[folded]; ok {
+		return rule, true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(folded, prefix.folded) {
+			return prefix.rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// wordSpans returns the byte offsets of the words in text. Invisible
+// formatting characters and leetspeak symbols count as part of a word so
+// they can't be used to split one up
+func wordSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) ||
+		unicode.Is(unicode.Cf, r) || isLeetSymbol(r)
+}
+
+// ParseWordList reads rules from r. Each line holds a word, optionally
+// followed by an action; words without one are masked. A trailing * makes
+// the word a prefix. Blank lines and lines starting with # are ignored:
+//
+//	# words to hide
+//	kerfuffle
+//	sharbert* mask
+//	fornax reject
+//	wombat flag
+func ParseWordList(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a word and an optional action", lineNumber)
+		}
+
+		rule := Rule{Action: ActionMask}
+		if len(fields) == 2 {
+			rule.Action = Action(strings.ToLower(fields[1]))
+			if rule.Action != ActionMask && rule.Action != ActionReject && rule.Action != ActionFlag {
+				return nil, fmt.Errorf("line %d: unknown action %q", lineNumber, fields[1])
+			}
+		}
+
+		word, isPrefix := strings.CutSuffix(fields[0], "*")
+		if len(wordSpans(word)) != 1 || fold(word) == "" {
+			return nil, fmt.Errorf("line %d: %q is not a single word", lineNumber, fields[0])
+		}
+		rule.Word = strings.ToLower(word)
+		rule.Prefix = isPrefix
+
+		rules = append(rules, rule)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func readWordList(path string) ([]Rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules, err := ParseWordList(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return rules, nil
+}
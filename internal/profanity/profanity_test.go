@@ -0,0 +1,137 @@
+package profanity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testRules = []Rule{
+	{Word: "kerfuffle", Action: ActionMask},
+	{Word: "sharbert", Action: ActionMask, Prefix: true},
+	{Word: "ass", Action: ActionMask},
+	{Word: "fornax", Action: ActionReject},
+	{Word: "wombat", Action: ActionFlag},
+}
+
+func TestCheckFoldsLookAlikes(t *testing.T) {
+	filter := New(testRules)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"upper case", "what a KERFUFFLE", "what a ****"},
+		{"accents", "what a kérfüffle", "what a ****"},
+		{"compatibility forms", "what a ｋｅｒｆｕｆｆｌｅ", "what a ****"},
+		{"cyrillic letters", "what a k\u0435rfuffl\u0435", "what a ****"},
+		{"greek letters", "what a kerf\u03c5ffle", "what a ****"},
+		{"leetspeak", "what a k3rfuff1e", "what a ****"},
+		{"zero width space", "what a ker\u200bfuffle", "what a ****"},
+		{"surrounding symbols", "what a @kerfuffle!", "what a @****!"},
+		{"punctuation", "kerfuffle, kerfuffle.", "****, ****."},
+	}
+	for _, tt := range tests {
+		got := filter.Check(tt.text)
+		if got.Text != tt.want {
+			t.Errorf("%s: Check(%q) = %q, want %q", tt.name, tt.text, got.Text, tt.want)
+		}
+	}
+}
+
+func TestCheckMatchesWholeWords(t *testing.T) {
+	filter := New(testRules)
+	tests := []struct {
+		text  string
+		match bool
+	}{
+		{"a classic assassin passes", false},
+		{"kerfuffles", false},
+		{"sharbert", true},
+		{"sharberts", true},
+		{"unsharbert", false},
+		{"ass", true},
+		{"bad ass", true},
+	}
+	for _, tt := range tests {
+		got := filter.Check(tt.text)
+		if (len(got.Matches) > 0) != tt.match {
+			t.Errorf("Check(%q) matches = %v, want a match: %t", tt.text, got.Matches, tt.match)
+		}
+		if !tt.match && got.Text != tt.text {
+			t.Errorf("Check(%q) changed the text to %q", tt.text, got.Text)
+		}
+	}
+}
+
+func TestCheckActions(t *testing.T) {
+	filter := New(testRules)
+	tests := []struct {
+		name         string
+		text         string
+		wantText     string
+		wantRejected bool
+		wantFlagged  []string
+	}{
+		{"mask", "a kerfuffle", "a ****", false, []string{}},
+		{"reject", "a fornax", "a fornax", true, []string{}},
+		{"flag", "a wombat and a W0MBAT", "a wombat and a W0MBAT", false, []string{"wombat"}},
+		{"mixed", "kerfuffle wombat fornax", "**** wombat fornax", true, []string{"wombat"}},
+		{"clean", "hello", "hello", false, []string{}},
+	}
+	for _, tt := range tests {
+		got := filter.Check(tt.text)
+		if got.Text != tt.wantText {
+			t.Errorf("%s: text = %q, want %q", tt.name, got.Text, tt.wantText)
+		}
+		if got.Rejected() != tt.wantRejected {
+			t.Errorf("%s: Rejected = %t, want %t", tt.name, got.Rejected(), tt.wantRejected)
+		}
+		if flagged := got.FlaggedWords(); !reflect.DeepEqual(flagged, tt.wantFlagged) {
+			t.Errorf("%s: FlaggedWords = %q, want %q", tt.name, flagged, tt.wantFlagged)
+		}
+	}
+}
+
+func TestCheckReportsByteOffsets(t *testing.T) {
+	filter := New(testRules)
+	text := "é wombat"
+	got := filter.Check(text)
+	if len(got.Matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(got.Matches))
+	}
+	m := got.Matches[0]
+	if text[m.Start:m.End] != "wombat" {
+		t.Errorf("match covers %q, want %q", text[m.Start:m.End], "wombat")
+	}
+}
+
+func TestParseWordList(t *testing.T) {
+	list := `# comment
+
+Kerfuffle
+sharbert* mask
+fornax REJECT
+wombat flag
+`
+	rules, err := ParseWordList(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("ParseWordList: %s", err)
+	}
+	want := []Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "sharbert", Action: ActionMask, Prefix: true},
+		{Word: "fornax", Action: ActionReject},
+		{Word: "wombat", Action: ActionFlag},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	for _, invalid := range []string{"word shout", "two words mask", "not-one-word", "***"} {
+		_, err := ParseWordList(strings.NewReader(invalid))
+		if err == nil {
+			t.Errorf("ParseWordList(%q) accepted an invalid line", invalid)
+		}
+	}
+}
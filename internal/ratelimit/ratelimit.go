@@ -0,0 +1,77 @@
+// Package ratelimit counts actions per key in fixed time windows
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Result describes the window an action was counted in
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows up to a limit of actions per key in each window. The limit
+// is passed on every call so keys can have different limits. It is safe for
+// concurrent use
+type Limiter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	windows   map[string]window
+	lastSweep time.Time
+}
+
+func New(windowSize time.Duration) *Limiter {
+	return &Limiter{
+		window:  windowSize,
+		windows: map[string]window{},
+	}
+}
+
+// Allow counts an action for key and reports whether it is within limit.
+// Actions that aren't allowed aren't counted
+func (l *Limiter) Allow(key string, limit int, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.start.Add(l.window)) {
+		if now.Sub(l.lastSweep) >= l.window {
+			l.sweep(now)
+		}
+		w = window{start: now.Truncate(l.window)}
+	}
+
+	result := Result{
+		Limit:   limit,
+		ResetAt: w.start.Add(l.window),
+	}
+	if w.count >= limit {
+		result.Remaining = 0
+		return result
+	}
+
+	w.count++
+	l.windows[key] = w
+	result.Allowed = true
+	result.Remaining = limit - w.count
+	return result
+}
+
+// sweep forgets windows that have ended so the map doesn't keep growing
+func (l *Limiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if !now.Before(w.start.Add(l.window)) {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
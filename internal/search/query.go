@@ -0,0 +1,80 @@
+package search
+
+import "strings"
+
+// Query is a parsed search query. A document must match every term, prefix
+// and phrase, and be written by one of the authors if any are given
+type Query struct {
+	Terms     []string
+	Prefixes  []string
+	Phrases   [][]string
+	AuthorIds []int
+	// Authors holds the handles from from:handle clauses, which the caller
+	// has to resolve into AuthorIds
+	Authors []string
+}
+
+// IsEmpty reports whether the query has nothing to match text against
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Prefixes) == 0 && len(q.Phrases) == 0
+}
+
+// ParseQuery parses a query string. Words are required terms, text in double
+// quotes is a phrase, a word ending in * matches any term starting with it
+// and from:handle limits results to an author
+func ParseQuery(raw string) Query {
+	q := Query{}
+
+	for raw != "" {
+		raw = strings.TrimLeft(raw, " \t\n")
+		if raw == "" {
+			break
+		}
+
+		if raw[0] == '"' {
+			end := strings.IndexByte(raw[1:], '"')
+			var phrase string
+			if end == -1 {
+				phrase, raw = raw[1:], ""
+			} else {
+				phrase, raw = raw[1:end+1], raw[end+2:]
+			}
+			terms := Tokenize(phrase)
+			switch len(terms) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, terms[0])
+			default:
+				q.Phrases = append(q.Phrases, terms)
+			}
+			continue
+		}
+
+		word := raw
+		if end := strings.IndexAny(raw, " \t\n"); end != -1 {
+			word, raw = raw[:end], raw[end:]
+		} else {
+			raw = ""
+		}
+
+		if handle, ok := strings.CutPrefix(word, "from:"); ok {
+			if handle = strings.TrimPrefix(handle, "@"); handle != "" {
+				q.Authors = append(q.Authors, handle)
+			}
+			continue
+		}
+
+		if prefix, ok := strings.CutSuffix(word, "*"); ok {
+			terms := Tokenize(prefix)
+			if len(terms) > 0 {
+				q.Terms = append(q.Terms, terms[:len(terms)-1]...)
+				q.Prefixes = append(q.Prefixes, terms[len(terms)-1])
+			}
+			continue
+		}
+
+		q.Terms = append(q.Terms, Tokenize(word)...)
+	}
+
+	return q
+}
@@ -0,0 +1,280 @@
+// Package search keeps an in-memory inverted index of chirps and ranks
+// matches with BM25
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type document struct {
+	authorId int
+	length   int
+	terms    map[string][]int
+}
+
+// Index is an inverted index from terms to the documents containing them,
+// with the positions of each occurrence. It is safe for concurrent use
+type Index struct {
+	mu          sync.RWMutex
+	docs        map[int]document
+	postings    map[string]map[int][]int
+	totalLength int
+}
+
+// Result is a matching document and its relevance score
+type Result struct {
+	Id    int
+	Score float64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[int]document{},
+		postings: map[string]map[int][]int{},
+	}
+}
+
+// Tokenize splits text into lower case terms made of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Document is a piece of text to index
+type Document struct {
+	Id       int
+	AuthorId int
+	Text     string
+}
+
+// Rebuild replaces the contents of the index with the given documents.
+// Searches keep using the old contents until the new index is complete
+func (idx *Index) Rebuild(docs []Document) {
+	fresh := NewIndex()
+	for _, doc := range docs {
+		fresh.Add(doc.Id, doc.AuthorId, doc.Text)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.docs = fresh.docs
+	idx.postings = fresh.postings
+	idx.totalLength = fresh.totalLength
+}
+
+// Add indexes a document, replacing any earlier version with the same id
+func (idx *Index) Add(id, authorId int, text string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+
+	tokens := Tokenize(text)
+	doc := document{
+		authorId: authorId,
+		length:   len(tokens),
+		terms:    map[string][]int{},
+	}
+	for pos, token := range tokens {
+		doc.terms[token] = append(doc.terms[token], pos)
+	}
+
+	for term, positions := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int][]int{}
+		}
+		idx.postings[term][id] = positions
+	}
+
+	idx.docs[id] = doc
+	idx.totalLength += doc.length
+}
+
+// Remove drops a document from the index
+func (idx *Index) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+}
+
+func (idx *Index) remove(id int) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+
+	for term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	delete(idx.docs, id)
+	idx.totalLength -= doc.length
+}
+
+// Search returns the documents matching every clause of the query, best
+// match first. Documents with the same score are ordered newest (highest
+// id) first
+func (idx *Index) Search(q Query) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if q.IsEmpty() || len(idx.docs) == 0 {
+		return []Result{}
+	}
+
+	var candidates map[int]float64
+	// intersect keeps the candidates that also appear in scores, adding up
+	// their scores
+	intersect := func(scores map[int]float64) {
+		if candidates == nil {
+			candidates = scores
+			return
+		}
+		for id := range candidates {
+			score, ok := scores[id]
+			if !ok {
+				delete(candidates, id)
+				continue
+			}
+			candidates[id] += score
+		}
+	}
+
+	for _, term := range q.Terms {
+		intersect(idx.scoreTerm(term, nil))
+	}
+	for _, prefix := range q.Prefixes {
+		intersect(idx.scorePrefix(prefix))
+	}
+	for _, phrase := range q.Phrases {
+		intersect(idx.scorePhrase(phrase))
+	}
+
+	authors := map[int]bool{}
+	for _, authorId := range q.AuthorIds {
+		authors[authorId] = true
+	}
+
+	results := make([]Result, 0, len(candidates))
+	for id, score := range candidates {
+		if len(authors) > 0 && !authors[idx.docs[id].authorId] {
+			continue
+		}
+		results = append(results, Result{Id: id, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id > results[j].Id
+	})
+
+	return results
+}
+
+// scoreTerm returns the BM25 score of a term for every document containing
+// it. When only is set, documents not in it are skipped
+func (idx *Index) scoreTerm(term string, only map[int]bool) map[int]float64 {
+	scores := map[int]float64{}
+	postings := idx.postings[term]
+	if len(postings) == 0 {
+		return scores
+	}
+
+	n := float64(len(idx.docs))
+	df := float64(len(postings))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avgLength := float64(idx.totalLength) / n
+
+	for id, positions := range postings {
+		if only != nil && !only[id] {
+			continue
+		}
+		tf := float64(len(positions))
+		length := float64(idx.docs[id].length)
+		scores[id] = idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLength))
+	}
+
+	return scores
+}
+
+// scorePrefix scores documents containing any term starting with prefix,
+// using the best scoring expansion for each document
+func (idx *Index) scorePrefix(prefix string) map[int]float64 {
+	scores := map[int]float64{}
+	for term := range idx.postings {
+		if !strings.HasPrefix(term, prefix) {
+			continue
+		}
+		for id, score := range idx.scoreTerm(term, nil) {
+			if score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+	return scores
+}
+
+// scorePhrase scores documents containing the terms of the phrase next to
+// each other and in order
+func (idx *Index) scorePhrase(phrase []string) map[int]float64 {
+	if len(phrase) == 0 {
+		return map[int]float64{}
+	}
+
+	matching := map[int]bool{}
+	for id, starts := range idx.postings[phrase[0]] {
+		doc := idx.docs[id]
+		for _, start := range starts {
+			if containsPhraseAt(doc, phrase, start) {
+				matching[id] = true
+				break
+			}
+		}
+	}
+
+	scores := map[int]float64{}
+	for id := range matching {
+		scores[id] = 0
+	}
+	for _, term := range phrase {
+		for id, score := range idx.scoreTerm(term, matching) {
+			scores[id] += score
+		}
+	}
+	return scores
+}
+
+func containsPhraseAt(doc document, phrase []string, start int) bool {
+	for offset, term := range phrase[1:] {
+		if !containsInt(doc.terms[term], start+offset+1) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
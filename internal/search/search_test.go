@@ -0,0 +1,139 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestIndex(docs map[int]string) *Index {
+	idx := NewIndex()
+	for id, text := range docs {
+		idx.Add(id, id*10, text)
+	}
+	return idx
+}
+
+func resultIds(results []Result) []int {
+	ids := []int{}
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	return ids
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Query
+	}{
+		{"Hello, World", Query{Terms: []string{"hello", "world"}}},
+		{`"big red dog" cat`, Query{Terms: []string{"cat"}, Phrases: [][]string{{"big", "red", "dog"}}}},
+		{`"single"`, Query{Terms: []string{"single"}}},
+		{`"unterminated phrase`, Query{Phrases: [][]string{{"unterminated", "phrase"}}}},
+		{"chir*", Query{Prefixes: []string{"chir"}}},
+		{"from:alice from:@bob hi", Query{Terms: []string{"hi"}, Authors: []string{"alice", "bob"}}},
+		{"from: *", Query{}},
+	}
+	for _, tt := range tests {
+		if got := ParseQuery(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRanksWithBM25(t *testing.T) {
+	idx := newTestIndex(map[int]string{
+		1: "go go go",
+		2: "go to the shop and buy some milk and bread",
+		3: "go home",
+		4: "nothing here",
+	})
+
+	got := resultIds(idx.Search(ParseQuery("go")))
+	// more occurrences rank higher, and a shorter document beats a longer
+	// one with the same count
+	want := []int{1, 3, 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+
+	// every term must match
+	got = resultIds(idx.Search(ParseQuery("go home")))
+	if !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("results = %v, want [3]", got)
+	}
+}
+
+func TestSearchTiesOrderNewestFirst(t *testing.T) {
+	idx := newTestIndex(map[int]string{1: "hello", 2: "hello", 3: "hello"})
+	got := resultIds(idx.Search(ParseQuery("hello")))
+	if !reflect.DeepEqual(got, []int{3, 2, 1}) {
+		t.Errorf("results = %v, want [3 2 1]", got)
+	}
+}
+
+func TestSearchPhrases(t *testing.T) {
+	idx := newTestIndex(map[int]string{
+		1: "the big red dog",
+		2: "the red big dog",
+		3: "a big, red dog!",
+		4: "big red",
+	})
+	got := resultIds(idx.Search(ParseQuery(`"big red dog"`)))
+	if !reflect.DeepEqual(got, []int{3, 1}) && !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("results = %v, want 1 and 3", got)
+	}
+}
+
+func TestSearchPrefixes(t *testing.T) {
+	idx := newTestIndex(map[int]string{
+		1: "chirping along",
+		2: "a chirp",
+		3: "church",
+	})
+	got := resultIds(idx.Search(ParseQuery("chirp*")))
+	if len(got) != 2 || got[0] == 3 || got[1] == 3 {
+		t.Errorf("results = %v, want 1 and 2", got)
+	}
+	if got := idx.Search(ParseQuery("zzz*")); len(got) != 0 {
+		t.Errorf("prefix without matches found %v", resultIds(got))
+	}
+}
+
+func TestSearchAuthors(t *testing.T) {
+	idx := newTestIndex(map[int]string{1: "hello", 2: "hello", 3: "hello"})
+
+	q := ParseQuery("hello")
+	q.AuthorIds = []int{10, 30}
+	if got := resultIds(idx.Search(q)); !reflect.DeepEqual(got, []int{3, 1}) {
+		t.Errorf("results = %v, want [3 1]", got)
+	}
+
+	q = ParseQuery("goodbye")
+	q.AuthorIds = []int{10}
+	if got := idx.Search(q); len(got) != 0 {
+		t.Errorf("author filter matched without the term: %v", resultIds(got))
+	}
+}
+
+func TestIndexUpdates(t *testing.T) {
+	idx := newTestIndex(map[int]string{1: "hello world"})
+
+	idx.Add(1, 10, "goodbye world")
+	if got := idx.Search(ParseQuery("hello")); len(got) != 0 {
+		t.Errorf("old text still found: %v", resultIds(got))
+	}
+	if got := resultIds(idx.Search(ParseQuery("goodbye"))); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("new text: results = %v, want [1]", got)
+	}
+
+	idx.Remove(1)
+	if got := idx.Search(ParseQuery("world")); len(got) != 0 {
+		t.Errorf("removed document still found: %v", resultIds(got))
+	}
+
+	idx.Rebuild([]Document{{Id: 2, AuthorId: 20, Text: "fresh start"}})
+	if got := resultIds(idx.Search(ParseQuery("fresh"))); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("after rebuild: results = %v, want [2]", got)
+	}
+}
@@ -0,0 +1,152 @@
+// Package stream fans chirp events out to clients connected to the real-time
+// stream. Recent events are kept in a buffer so clients that reconnect can
+// resume from the last event they saw
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	ChirpCreated = "chirp.created"
+	ChirpDeleted = "chirp.deleted"
+)
+
+const subscriberBuffer = 64
+
+// Event is a change to a chirp. AuthorId and Hashtags are used to match
+// filters, Data is what is sent to clients
+type Event struct {
+	Id       int64
+	Type     string
+	AuthorId int
+	Hashtags []string
+	Data     json.RawMessage
+}
+
+// Filter selects the events a subscriber receives. A nil Authors or an
+// empty Hashtag matches every event
+type Filter struct {
+	Authors map[int]bool
+	Hashtag string
+}
+
+func (f Filter) matches(ev Event) bool {
+	if f.Authors != nil && !f.Authors[ev.AuthorId] {
+		return false
+	}
+	if f.Hashtag == "" {
+		return true
+	}
+	for _, tag := range ev.Hashtags {
+		if tag == f.Hashtag {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives matching events on Events. The channel is closed
+// when the subscription is cancelled or falls too far behind
+type Subscription struct {
+	Events <-chan Event
+	events chan Event
+	filter Filter
+	hub    *Hub
+	once   sync.Once
+}
+
+// Close cancels the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Hub is an in-process pub/sub hub for chirp events. It is safe for
+// concurrent use
+type Hub struct {
+	mu          sync.Mutex
+	lastId      int64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]bool
+}
+
+// NewHub returns a hub that keeps the last historySize events for resuming.
+// Event ids start from the current time in microseconds, so ids keep
+// increasing across restarts and ids from before a restart are never reused
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		lastId:      time.Now().UnixMicro(),
+		historySize: historySize,
+		subscribers: map[*Subscription]bool{},
+	}
+}
+
+// Publish gives an event the next id and sends it to every matching
+// subscriber. Subscribers that can't keep up are dropped rather than
+// blocking the publisher
+func (h *Hub) Publish(ev Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastId++
+	ev.Id = h.lastId
+	h.history = append(h.history, ev)
+	if len(h.history) > h.historySize {
+		h.history = h.history[len(h.history)-h.historySize:]
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(ev) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			h.remove(sub)
+		}
+	}
+
+	return ev
+}
+
+// Subscribe starts a subscription. When lastEventId is not 0 the matching
+// events published after it that are still buffered are returned, so they
+// can be sent before the live events
+func (h *Hub) Subscribe(filter Filter, lastEventId int64) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{
+		Events: events,
+		events: events,
+		filter: filter,
+		hub:    h,
+	}
+	h.subscribers[sub] = true
+
+	missed := []Event{}
+	if lastEventId != 0 {
+		for _, ev := range h.history {
+			if ev.Id > lastEventId && filter.matches(ev) {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	return sub, missed
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(sub *Subscription) {
+	sub.once.Do(func() {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	})
+}
@@ -0,0 +1,152 @@
+package stream
+
+import "testing"
+
+// received drains the events waiting on a subscription without blocking
+func received(sub *Subscription) []Event {
+	events := []Event{}
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func isClosed(sub *Subscription) bool {
+	for {
+		select {
+		case _, ok := <-sub.Events:
+			if !ok {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func TestPublishReachesSubscribers(t *testing.T) {
+	hub := NewHub(10)
+	first, _ := hub.Subscribe(Filter{}, 0)
+	second, _ := hub.Subscribe(Filter{}, 0)
+
+	published := hub.Publish(Event{Type: ChirpCreated, AuthorId: 1})
+	next := hub.Publish(Event{Type: ChirpDeleted, AuthorId: 1})
+	if next.Id <= published.Id {
+		t.Errorf("event ids %d then %d, want increasing ids", published.Id, next.Id)
+	}
+
+	for _, sub := range []*Subscription{first, second} {
+		events := received(sub)
+		if len(events) != 2 || events[0].Id != published.Id || events[1].Id != next.Id {
+			t.Errorf("subscriber got %+v, want events %d and %d", events, published.Id, next.Id)
+		}
+	}
+}
+
+func TestCloseUnsubscribes(t *testing.T) {
+	hub := NewHub(10)
+	sub, _ := hub.Subscribe(Filter{}, 0)
+	sub.Close()
+	// closing twice is harmless
+	sub.Close()
+
+	hub.Publish(Event{Type: ChirpCreated})
+	if !isClosed(sub) {
+		t.Error("channel of a closed subscription is still open")
+	}
+	if len(hub.subscribers) != 0 {
+		t.Errorf("hub has %d subscribers, want 0", len(hub.subscribers))
+	}
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	hub := NewHub(10)
+	slow, _ := hub.Subscribe(Filter{}, 0)
+	fast, _ := hub.Subscribe(Filter{}, 0)
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		hub.Publish(Event{Type: ChirpCreated})
+		received(fast)
+	}
+
+	if events := received(slow); len(events) != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", len(events), subscriberBuffer)
+	}
+	if !isClosed(slow) {
+		t.Error("slow subscriber wasn't dropped")
+	}
+	if isClosed(fast) {
+		t.Error("subscriber that kept up was dropped")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	events := []Event{
+		{Type: ChirpCreated, AuthorId: 1, Hashtags: []string{"go"}},
+		{Type: ChirpCreated, AuthorId: 2, Hashtags: []string{"go", "rust"}},
+		{Type: ChirpCreated, AuthorId: 3},
+	}
+	tests := []struct {
+		name    string
+		filter  Filter
+		authors []int
+	}{
+		{"everything", Filter{}, []int{1, 2, 3}},
+		{"authors", Filter{Authors: map[int]bool{1: true, 3: true}}, []int{1, 3}},
+		{"no authors", Filter{Authors: map[int]bool{}}, []int{}},
+		{"hashtag", Filter{Hashtag: "rust"}, []int{2}},
+		{"authors and hashtag", Filter{Authors: map[int]bool{1: true, 3: true}, Hashtag: "go"}, []int{1}},
+	}
+	for _, tt := range tests {
+		hub := NewHub(10)
+		sub, _ := hub.Subscribe(tt.filter, 0)
+		for _, ev := range events {
+			hub.Publish(ev)
+		}
+		got := []int{}
+		for _, ev := range received(sub) {
+			got = append(got, ev.AuthorId)
+		}
+		if len(got) != len(tt.authors) {
+			t.Errorf("%s: got events by %v, want %v", tt.name, got, tt.authors)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.authors[i] {
+				t.Errorf("%s: got events by %v, want %v", tt.name, got, tt.authors)
+				break
+			}
+		}
+	}
+}
+
+func TestSubscribeResumesFromHistory(t *testing.T) {
+	hub := NewHub(3)
+	published := []Event{}
+	for i := 1; i <= 5; i++ {
+		published = append(published, hub.Publish(Event{Type: ChirpCreated, AuthorId: i % 2}))
+	}
+
+	// only the last three events are kept
+	_, missed := hub.Subscribe(Filter{}, published[0].Id)
+	if len(missed) != 3 || missed[0].Id != published[2].Id {
+		t.Errorf("missed = %+v, want the last 3 events", missed)
+	}
+
+	_, missed = hub.Subscribe(Filter{Authors: map[int]bool{1: true}}, published[2].Id)
+	if len(missed) != 1 || missed[0].Id != published[4].Id {
+		t.Errorf("missed = %+v, want event %d", missed, published[4].Id)
+	}
+
+	_, missed = hub.Subscribe(Filter{}, 0)
+	if len(missed) != 0 {
+		t.Errorf("new subscription got %d missed events, want 0", len(missed))
+	}
+}
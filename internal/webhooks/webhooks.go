@@ -0,0 +1,264 @@
+// Package webhooks delivers events to the URLs of webhook subscriptions.
+// Events are queued in the database before they are sent, so deliveries
+// survive restarts, and failed deliveries are retried with exponential
+// backoff until they succeed or are moved to the dead-letter list
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const (
+	// MaxAttempts is the number of times a delivery is tried before it is
+	// moved to the dead-letter list
+	MaxAttempts = 8
+	// BaseBackoff is the wait before the first retry. Each retry waits twice
+	// as long as the one before, up to MaxBackoff
+	BaseBackoff = 30 * time.Second
+	MaxBackoff  = 6 * time.Hour
+
+	// DeliveredRetention and DeadRetention are how long delivered and dead
+	// deliveries are kept in the delivery log. Dead deliveries are kept
+	// longer so they can still be retried once the receiver is fixed
+	DeliveredRetention = 7 * 24 * time.Hour
+	DeadRetention      = 30 * 24 * time.Hour
+
+	// SignatureHeader holds the signature of the request body, made with
+	// auth.SignPayload and the secret of the subscription
+	SignatureHeader = "X-Chirpy-Signature"
+	EventHeader     = "X-Chirpy-Event"
+	DeliveryHeader  = "X-Chirpy-Delivery"
+
+	batchSize    = 50
+	maxDrainSize = 64 << 10
+)
+
+// Store is the part of the database the dispatcher needs
+type Store interface {
+	EnqueueWebhookDeliveries(event string, payload []byte) ([]jsonDB.WebhookDelivery, error)
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]jsonDB.WebhookDelivery, error)
+	GetWebhookSubscription(id int) (jsonDB.WebhookSubscription, error)
+	RecordWebhookAttempt(deliveryId int, attempt jsonDB.WebhookAttempt, status string, nextAttemptAt time.Time) (jsonDB.WebhookDelivery, error)
+	PruneWebhookDeliveries(deliveredBefore, deadBefore time.Time) (int, error)
+}
+
+// Envelope is the body posted to subscribers
+type Envelope struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher queues events and delivers them in the background
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewDispatcher returns a dispatcher that sends requests with client, which
+// should have a timeout
+func NewDispatcher(store Store, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Publish queues an event for every subscription to its type and wakes the
+// delivery loop
+func (d *Dispatcher) Publish(eventType string, data interface{}) error {
+	id, err := newEventId()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Envelope{
+		Id:        id,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't encode webhook event: %w", err)
+	}
+
+	deliveries, err := d.store.EnqueueWebhookDeliveries(eventType, payload)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) > 0 {
+		d.Wake()
+	}
+	return nil
+}
+
+// Wake makes the delivery loop look for due deliveries right away
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries whenever it is woken up and every
+// pollInterval, until ctx is cancelled. Every pollInterval it also prunes
+// old deliveries from the delivery log
+func (d *Dispatcher) Run(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			delivered, err := d.DeliverDue(ctx)
+			if err != nil {
+				log.Printf("Error delivering webhooks: %s", err)
+			}
+			if err != nil || delivered < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			_, err := d.store.PruneWebhookDeliveries(now.Add(-DeliveredRetention), now.Add(-DeadRetention))
+			if err != nil {
+				log.Printf("Error pruning webhook deliveries: %s", err)
+			}
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue tries a batch of due deliveries once and returns how many were
+// tried
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.store.GetDueWebhookDeliveries(time.Now().UTC(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		err = d.deliver(ctx, delivery)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery jsonDB.WebhookDelivery) error {
+	sub, err := d.store.GetWebhookSubscription(delivery.SubscriptionId)
+	if err != nil {
+		return err
+	}
+
+	attempt := d.send(ctx, sub, delivery)
+	status := jsonDB.DeliveryPending
+	next := time.Time{}
+	switch {
+	case attempt.Error == "":
+		status = jsonDB.DeliveryDelivered
+	case len(delivery.Attempts)+1 >= MaxAttempts:
+		status = jsonDB.DeliveryDead
+	default:
+		next = attempt.At.Add(Backoff(len(delivery.Attempts) + 1))
+	}
+
+	_, err = d.store.RecordWebhookAttempt(delivery.Id, attempt, status, next)
+	return err
+}
+
+// send posts a delivery to its subscription. The attempt has an error unless
+// the receiver answered with a 2xx status
+func (d *Dispatcher) send(ctx context.Context, sub jsonDB.WebhookSubscription, delivery jsonDB.WebhookDelivery) jsonDB.WebhookAttempt {
+	start := time.Now().UTC()
+	attempt := jsonDB.WebhookAttempt{At: start}
+	finish := func(err error) jsonDB.WebhookAttempt {
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		attempt.DurationMs = time.Since(start).Milliseconds()
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return finish(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.Id))
+	req.Header.Set(SignatureHeader, auth.SignPayload(delivery.Payload, sub.Secret, start))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return finish(err)
+	}
+	defer resp.Body.Close()
+
+	// the body is drained so the connection can be reused, but never kept:
+	// the delivery log is shown to admins and must not turn into a way to
+	// read responses from arbitrary URLs
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainSize))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return finish(fmt.Errorf("receiver responded with %s", resp.Status))
+	}
+	return finish(nil)
+}
+
+// Backoff returns how long to wait before retrying a delivery that has
+// failed attempts times
+func Backoff(attempts int) time.Duration {
+	backoff := BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= MaxBackoff {
+			return MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// NewSecret returns a random secret for signing a subscription's payloads
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+func newEventId() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,193 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+// laterStore makes every pending delivery due, so retries can be tested
+// without waiting out the backoff
+type laterStore struct {
+	*jsonDB.DB
+}
+
+func (s laterStore) GetDueWebhookDeliveries(now time.Time, limit int) ([]jsonDB.WebhookDelivery, error) {
+	return s.DB.GetDueWebhookDeliveries(now.Add(MaxBackoff), limit)
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// stubReceiver answers requests with the given statuses in turn, repeating
+// the last one, and records what it received
+func stubReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []received) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := []received{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, received{header: r.Header.Clone(), body: body})
+		status := statuses[len(statuses)-1]
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, "internal details that must not be stored")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), requests...)
+	}
+}
+
+func newTestStore(t *testing.T) laterStore {
+	t.Helper()
+	db, err := jsonDB.NewDB(filepath.Join(t.TempDir(), "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	return laterStore{db}
+}
+
+func TestDeliverySignsPayload(t *testing.T) {
+	store := newTestStore(t)
+	srv, requests := stubReceiver(t, http.StatusNoContent)
+	sub, err := store.CreateWebhookSubscription(srv.URL, []string{jsonDB.WebhookChirpCreated}, "test-secret-0123456789")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+
+	d := NewDispatcher(store, srv.Client())
+	err = d.Publish(jsonDB.WebhookChirpCreated, map[string]int{"id": 7})
+	if err != nil {
+		t.Fatalf("Publish: %s", err)
+	}
+	n, err := d.DeliverDue(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("DeliverDue = %d, %v, want 1 delivery", n, err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+	if got := req.header.Get(EventHeader); got != jsonDB.WebhookChirpCreated {
+		t.Errorf("%s = %q, want %q", EventHeader, got, jsonDB.WebhookChirpCreated)
+	}
+	err = auth.VerifySignature(req.header.Get(SignatureHeader), req.body, sub.Secret, time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("signature doesn't verify: %s", err)
+	}
+	err = auth.VerifySignature(req.header.Get(SignatureHeader), req.body, "another-secret-0123456789", time.Minute, time.Now())
+	if err == nil {
+		t.Errorf("signature verifies with the wrong secret")
+	}
+	if !strings.Contains(string(req.body), `"type":"chirp.created"`) {
+		t.Errorf("body = %s, want a chirp.created envelope", req.body)
+	}
+
+	deliveries, _ := store.GetWebhookDeliveries(sub.Id, "")
+	if len(deliveries) != 1 || deliveries[0].Status != jsonDB.DeliveryDelivered {
+		t.Errorf("deliveries = %+v, want one delivered", deliveries)
+	}
+}
+
+func TestFailedDeliveryIsRetriedWithBackoff(t *testing.T) {
+	store := newTestStore(t)
+	srv, requests := stubReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	sub, err := store.CreateWebhookSubscription(srv.URL, []string{jsonDB.WebhookChirpCreated}, "test-secret-0123456789")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+
+	d := NewDispatcher(store, srv.Client())
+	d.Publish(jsonDB.WebhookChirpCreated, nil)
+
+	for i := 1; i <= 2; i++ {
+		d.DeliverDue(context.Background())
+		deliveries, _ := store.GetWebhookDeliveries(sub.Id, "")
+		delivery := deliveries[0]
+		if delivery.Status != jsonDB.DeliveryPending || len(delivery.Attempts) != i {
+			t.Fatalf("after attempt %d: delivery = %+v, want pending with %d attempts", i, delivery, i)
+		}
+		attempt := delivery.Attempts[i-1]
+		if want := attempt.At.Add(Backoff(i)); !delivery.NextAttemptAt.Equal(want) {
+			t.Errorf("after attempt %d: next attempt at %s, want %s", i, delivery.NextAttemptAt, want)
+		}
+		if attempt.StatusCode < 500 || attempt.Error == "" {
+			t.Errorf("attempt %d = %+v, want a failed attempt with the status", i, attempt)
+		}
+		if strings.Contains(attempt.Error, "internal details") {
+			t.Errorf("attempt %d stored the response body: %q", i, attempt.Error)
+		}
+	}
+
+	d.DeliverDue(context.Background())
+	deliveries, _ := store.GetWebhookDeliveries(sub.Id, "")
+	if deliveries[0].Status != jsonDB.DeliveryDelivered {
+		t.Errorf("delivery = %+v, want delivered on the third attempt", deliveries[0])
+	}
+	if len(requests()) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(requests()))
+	}
+}
+
+func TestDeliveryDiesAfterMaxAttempts(t *testing.T) {
+	store := newTestStore(t)
+	srv, requests := stubReceiver(t, http.StatusServiceUnavailable)
+	sub, err := store.CreateWebhookSubscription(srv.URL, []string{jsonDB.WebhookChirpCreated}, "test-secret-0123456789")
+	if err != nil {
+		t.Fatalf("CreateWebhookSubscription: %s", err)
+	}
+
+	d := NewDispatcher(store, srv.Client())
+	d.Publish(jsonDB.WebhookChirpCreated, nil)
+	for i := 0; i < MaxAttempts+2; i++ {
+		d.DeliverDue(context.Background())
+	}
+
+	if got := len(requests()); got != MaxAttempts {
+		t.Errorf("receiver got %d requests, want %d", got, MaxAttempts)
+	}
+	dead, _ := store.GetWebhookDeliveries(sub.Id, jsonDB.DeliveryDead)
+	if len(dead) != 1 || dead[0].DeadAt == nil {
+		t.Errorf("dead deliveries = %+v, want one", dead)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, BaseBackoff},
+		{2, 2 * BaseBackoff},
+		{3, 4 * BaseBackoff},
+		{20, MaxBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			if got := Backoff(tt.attempts); got != tt.want {
+				t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,64 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emilmalmsten/chirpy/internal/activitypub"
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/blobstore"
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/events"
+	"github.com/emilmalmsten/chirpy/internal/graphql"
 	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/profanity"
+	"github.com/emilmalmsten/chirpy/internal/ratelimit"
+	"github.com/emilmalmsten/chirpy/internal/safehttp"
+	"github.com/emilmalmsten/chirpy/internal/search"
+	"github.com/emilmalmsten/chirpy/internal/stream"
+	"github.com/emilmalmsten/chirpy/internal/webhooks"
 	"github.com/go-chi/chi"
 	"github.com/joho/godotenv"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	DB             *jsonDB.DB
-	jwtSecret      string
-	polkaApiKey    string
+	fileserverHits      int
+	DB                  *jsonDB.DB
+	jwtSecret           string
+	polkaApiKey         string
+	polkaWebhookSecret  string
+	deletionGracePeriod time.Duration
+	searchIndex         *search.Index
+	chirpEditWindow     time.Duration
+	blobs               blobstore.BlobStore
+	profanityFilter     *profanity.Filter
+	moderatorEmails     map[string]bool
+	autoHideThreshold   int
+	rateLimiter         *ratelimit.Limiter
+	adminApiKey         string
+	webhooks            *webhooks.Dispatcher
+	streamHub           *stream.Hub
+	publicURL           string
+	federation          *activitypub.Client
+	activityDeliverer   *activitypub.Deliverer
+	graphqlSchema       *graphql.Schema
 }
 
+const serverAddr = "localhost:8080"
+
+var errAccountPendingDeletion = errors.New("account is scheduled for deletion")
+var errSessionRevoked = errors.New("session is revoked")
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -45,15 +83,94 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	})
 }
 
-func filterProfanity(message string) string {
-	//message = strings.ToLower(message)
-	bannedWords := []string{"kerfuffle", "sharbert", "fornax"}
-	const replacement = "****"
+// authenticateUser validates the bearer token of a request and returns the
+// id of the user it belongs to. Users whose account is scheduled for
+// deletion are rejected, and scheduling a deletion also ends all of their
+// sessions
+func (cfg *apiConfig) authenticateUser(r *http.Request) (int, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.userForToken(token)
+}
+
+// userForToken validates an access token and returns the id of the user it
+// belongs to, with the same checks as authenticateUser
+func (cfg *apiConfig) userForToken(token string) (int, error) {
+	user, err := cfg.userForTokenOfType(token, auth.TokenTypeAccess)
+	if err != nil {
+		return 0, err
+	}
+	return user.Id, nil
+}
+
+// userForRefreshToken validates a refresh token that hasn't been revoked and
+// returns the id of the user it belongs to, with the same checks as
+// authenticateUser
+func (cfg *apiConfig) userForRefreshToken(token string) (int, error) {
+	isRevoked, err := cfg.DB.IsTokenRevoked(token)
+	if err != nil {
+		return 0, err
+	}
+	if isRevoked {
+		return 0, errSessionRevoked
+	}
+
+	user, err := cfg.userForTokenOfType(token, auth.TokenTypeRefresh)
+	if err != nil {
+		return 0, err
+	}
+	return user.Id, nil
+}
+
+func (cfg *apiConfig) userForTokenOfType(token string, tokenType auth.TokenType) (jsonDB.User, error) {
+	userId, issuedAt, err := auth.ValidateToken(token, cfg.jwtSecret, tokenType)
+	if err != nil {
+		return jsonDB.User{}, err
+	}
+
+	userIDInt, err := strconv.Atoi(userId)
+	if err != nil {
+		return jsonDB.User{}, err
+	}
+
+	user, err := cfg.DB.GetUser(userIDInt)
+	if err != nil {
+		return jsonDB.User{}, err
+	}
+	if user.DeletionScheduledAt != nil {
+		return jsonDB.User{}, errAccountPendingDeletion
+	}
+	if user.SessionRevoked(issuedAt) {
+		return jsonDB.User{}, errSessionRevoked
+	}
 
-	for _, word := range bannedWords {
-		message = strings.Replace(message, word, replacement, -1)
+	return user, nil
+}
+
+// authenticateAdmin checks the ApiKey header of requests to the admin API.
+// Without ADMIN_API_KEY set every request is rejected
+func (cfg *apiConfig) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	err := auth.ValidateApiKey(r.Header, cfg.adminApiKey)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "could not verify api key")
+		return false
 	}
-	return message
+	return true
+}
+
+// viewerID returns the id of the authenticated user making the request, or
+// 0 for anonymous requests and requests with an invalid token
+func (cfg *apiConfig) viewerID(r *http.Request) int {
+	if r.Header.Get("Authorization") == "" {
+		return 0
+	}
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		return 0
+	}
+	return userId
 }
 
 func main() {
@@ -68,6 +185,29 @@ func main() {
 	if polkaApiKey == "" {
 		log.Fatal("POLKA_API_KEY environment variable is not set")
 	}
+	chirpEditWindow := 30 * time.Minute
+	if editWindowString := os.Getenv("CHIRP_EDIT_WINDOW"); editWindowString != "" {
+		var err error
+		chirpEditWindow, err = time.ParseDuration(editWindowString)
+		if err != nil {
+			log.Fatalf("CHIRP_EDIT_WINDOW is not a valid duration: %s", err)
+		}
+	}
+	moderatorEmails := map[string]bool{}
+	for _, email := range strings.Split(os.Getenv("MODERATOR_EMAILS"), ",") {
+		email = strings.TrimSpace(strings.ToLower(email))
+		if email != "" {
+			moderatorEmails[email] = true
+		}
+	}
+	autoHideThreshold := 3
+	if thresholdString := os.Getenv("MODERATION_AUTO_HIDE_THRESHOLD"); thresholdString != "" {
+		var err error
+		autoHideThreshold, err = strconv.Atoi(thresholdString)
+		if err != nil || autoHideThreshold < 0 {
+			log.Fatalf("MODERATION_AUTO_HIDE_THRESHOLD must be a non-negative number")
+		}
+	}
 	ex, err := os.Executable()
 	if err != nil {
 		panic(err)
@@ -79,44 +219,179 @@ func main() {
 		panic(err)
 	}
 
+	blobs, err := blobstore.NewLocalStore(exPath + "/media")
+	if err != nil {
+		panic(err)
+	}
+
+	profanityFilter := profanity.New(profanity.DefaultRules)
+	if wordListPath := os.Getenv("PROFANITY_WORDLIST"); wordListPath != "" {
+		profanityFilter, err = profanity.Load(wordListPath)
+		if err != nil {
+			log.Fatalf("couldn't load profanity word list: %s", err)
+		}
+	}
+
 	apiCfg := apiConfig{
-		fileserverHits: 0,
-		DB:             db,
-		jwtSecret:      jwtSecret,
-		polkaApiKey:    polkaApiKey,
+		fileserverHits:      0,
+		DB:                  db,
+		jwtSecret:           jwtSecret,
+		polkaApiKey:         polkaApiKey,
+		polkaWebhookSecret:  os.Getenv("POLKA_WEBHOOK_SECRET"),
+		deletionGracePeriod: 30 * 24 * time.Hour,
+		searchIndex:         search.NewIndex(),
+		chirpEditWindow:     chirpEditWindow,
+		blobs:               blobs,
+		profanityFilter:     profanityFilter,
+		moderatorEmails:     moderatorEmails,
+		autoHideThreshold:   autoHideThreshold,
+		rateLimiter:         ratelimit.New(entitlements.RateLimitWindow),
+		adminApiKey:         os.Getenv("ADMIN_API_KEY"),
+		webhooks:            webhooks.NewDispatcher(db, safehttp.NewClient(webhookTimeout)),
+		streamHub:           stream.NewHub(streamHistorySize),
+		publicURL:           strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+		federation: &activitypub.Client{
+			HTTP:      safehttp.NewClient(federationTimeout),
+			UserAgent: "Chirpy",
+		},
 	}
+	apiCfg.activityDeliverer = activitypub.NewDeliverer(db, apiCfg.federation, apiCfg.activitySigner)
+
+	apiCfg.graphqlSchema = apiCfg.newGraphQLSchema()
+
+	err = apiCfg.rebuildSearchIndex()
+	if err != nil {
+		panic(err)
+	}
+
+	bus := events.NewBus(db)
+	apiCfg.subscribeEvents(bus)
+	db.OnEventsRecorded(bus.Dispatch)
+
+	go bus.Run(context.Background(), eventPollInterval)
+	go apiCfg.purgeDeletedUsersLoop(time.Hour)
+	go apiCfg.expireMembershipsLoop(time.Hour)
+	go apiCfg.pruneUnattachedMediaLoop(time.Hour)
+	go apiCfg.webhooks.Run(context.Background(), webhookPollInterval)
+	go apiCfg.activityDeliverer.Run(context.Background(), activityPollInterval)
 
 	router := chi.NewRouter()
 
 	fileServer := apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))
 	router.Mount("/", fileServer)
+	router.Get("/.well-known/webfinger", apiCfg.handlerWebFinger)
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Get("/healthz", readinessHandler)
 
 	apiRouter.Post("/chirps", apiCfg.handlerPostChirp)
 	apiRouter.Get("/chirps", apiCfg.handlerGetChirps)
+	apiRouter.Get("/chirps/search", apiCfg.handlerSearchChirps)
 	apiRouter.Get("/chirps/{chirpID}", apiCfg.handlerGetChirpById)
+	apiRouter.Put("/chirps/{chirpID}", apiCfg.handlerEditChirp)
 	apiRouter.Delete("/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	apiRouter.Get("/chirps/{chirpID}/history", apiCfg.handlerGetChirpHistory)
+	apiRouter.Get("/chirps/{chirpID}/thread", apiCfg.handlerGetThread)
+	apiRouter.Post("/chirps/{chirpID}/like", apiCfg.handlerLikeChirp)
+	apiRouter.Delete("/chirps/{chirpID}/like", apiCfg.handlerUnlikeChirp)
+	apiRouter.Get("/chirps/{chirpID}/likes", apiCfg.handlerGetChirpLikes)
+	apiRouter.Post("/chirps/{chirpID}/rechirp", apiCfg.handlerRechirp)
+	apiRouter.Delete("/chirps/{chirpID}/rechirp", apiCfg.handlerUndoRechirp)
+	apiRouter.Get("/chirps/{chirpID}/rechirps", apiCfg.handlerGetRechirps)
+	apiRouter.Post("/chirps/{chirpID}/report", apiCfg.handlerReportChirp)
+
+	apiRouter.Post("/media", apiCfg.handlerUploadMedia)
+	apiRouter.Get("/media/{mediaID}", apiCfg.handlerGetMedia)
+	apiRouter.Get("/media/{mediaID}/thumbnail", apiCfg.handlerGetMediaThumbnail)
 
 	apiRouter.Post("/users", apiCfg.handlerUsersCreate)
 	apiRouter.Put("/users", apiCfg.handlerUsersUpdate)
+	apiRouter.Delete("/users", apiCfg.handlerUsersDelete)
+	apiRouter.Get("/users/me/export", apiCfg.handlerUsersExport)
+	apiRouter.Get("/users/me/membership", apiCfg.handlerGetMembership)
+	apiRouter.Put("/users/me/profile", apiCfg.handlerUpdateProfile)
+	apiRouter.Get("/users/{handle}", apiCfg.handlerGetProfile)
+	apiRouter.Post("/users/{handle}/follow", apiCfg.handlerFollow)
+	apiRouter.Delete("/users/{handle}/follow", apiCfg.handlerUnfollow)
+	apiRouter.Get("/users/{handle}/followers", apiCfg.handlerGetFollowers)
+	apiRouter.Get("/users/{handle}/following", apiCfg.handlerGetFollowing)
+	apiRouter.Get("/users/{handle}/feed.atom", apiCfg.handlerUserFeed(atomFeed))
+	apiRouter.Get("/users/{handle}/feed.rss", apiCfg.handlerUserFeed(rssFeed))
+	apiRouter.Get("/users/{handle}/feed.json", apiCfg.handlerUserFeed(jsonFeed))
+	apiRouter.Get("/timeline", apiCfg.handlerGetTimeline)
+	apiRouter.Get("/feed.atom", apiCfg.handlerGlobalFeed(atomFeed))
+	apiRouter.Get("/feed.rss", apiCfg.handlerGlobalFeed(rssFeed))
+	apiRouter.Get("/feed.json", apiCfg.handlerGlobalFeed(jsonFeed))
+	apiRouter.Get("/stream", apiCfg.handlerStream)
+	apiRouter.Get("/stream/ws", apiCfg.handlerStreamWebSocket)
+
+	apiRouter.Get("/notifications", apiCfg.handlerGetNotifications)
+	apiRouter.Get("/notifications/unread_count", apiCfg.handlerUnreadNotificationCount)
+	apiRouter.Post("/notifications/read", apiCfg.handlerMarkNotificationsRead)
+	apiRouter.Get("/notifications/preferences", apiCfg.handlerGetNotificationPreferences)
+	apiRouter.Put("/notifications/preferences", apiCfg.handlerUpdateNotificationPreferences)
+
+	apiRouter.Get("/moderation/cases", apiCfg.handlerGetModerationCases)
+	apiRouter.Get("/moderation/cases/{chirpID}", apiCfg.handlerGetModerationCase)
+	apiRouter.Post("/moderation/cases/{chirpID}/claim", apiCfg.handlerClaimModerationCase)
+	apiRouter.Post("/moderation/cases/{chirpID}/resolve", apiCfg.handlerResolveModerationCase)
+	apiRouter.Get("/moderation/log", apiCfg.handlerGetModerationLog)
+
+	apiRouter.Get("/tags/trending", apiCfg.handlerTrendingTags)
+	apiRouter.Get("/tags/{tag}", apiCfg.handlerGetTag)
 	apiRouter.Post("/login", apiCfg.handlerUsersLogin)
 	apiRouter.Post("/refresh", apiCfg.handlerRefresh)
 	apiRouter.Post("/revoke", apiCfg.handlerRevoke)
 
 	apiRouter.Post("/polka/webhooks", apiCfg.handlerUpgradeMembership)
 
+	apiRouter.Get("/graphql", apiCfg.handlerGraphQL)
+	apiRouter.Post("/graphql", apiCfg.handlerGraphQL)
+
 	router.Mount("/api", apiRouter)
 
 	adminRouter := chi.NewRouter()
 	adminRouter.Get("/metrics", apiCfg.metricsHandler)
+	adminRouter.Post("/profanity/reload", apiCfg.handlerReloadProfanity)
+	adminRouter.Post("/webhooks", apiCfg.handlerCreateWebhook)
+	adminRouter.Get("/webhooks", apiCfg.handlerGetWebhooks)
+	adminRouter.Get("/webhooks/dead-letters", apiCfg.handlerGetDeadWebhookDeliveries)
+	adminRouter.Post("/webhooks/deliveries/{deliveryID}/retry", apiCfg.handlerRetryWebhookDelivery)
+	adminRouter.Get("/webhooks/{subscriptionID}", apiCfg.handlerGetWebhook)
+	adminRouter.Put("/webhooks/{subscriptionID}", apiCfg.handlerUpdateWebhook)
+	adminRouter.Delete("/webhooks/{subscriptionID}", apiCfg.handlerDeleteWebhook)
+	adminRouter.Get("/webhooks/{subscriptionID}/deliveries", apiCfg.handlerGetWebhookDeliveries)
 	router.Mount("/admin", adminRouter)
 
+	apRouter := chi.NewRouter()
+	apRouter.Post("/inbox", apiCfg.handlerInbox)
+	apRouter.Get("/users/{userID}", apiCfg.handlerGetActor)
+	apRouter.Post("/users/{userID}/inbox", apiCfg.handlerInbox)
+	apRouter.Get("/users/{userID}/outbox", apiCfg.handlerGetOutbox)
+	apRouter.Get("/users/{userID}/followers", apiCfg.handlerGetFollowersCollection)
+	apRouter.Get("/chirps/{chirpID}", apiCfg.handlerGetNote)
+	router.Mount("/ap", apRouter)
+
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = defaultGRPCAddr
+	}
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		fmt.Printf("gRPC server running on: %s\n", grpcAddr)
+		err := apiCfg.newGRPCServer().Serve(grpcListener)
+		if err != nil {
+			log.Fatalf("gRPC server stopped: %s", err)
+		}
+	}()
+
 	corsMux := middlewareCors(router)
 
 	server := &http.Server{
-		Addr:    "localhost:8080",
+		Addr:    serverAddr,
 		Handler: corsMux,
 	}
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/activitypub"
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/events"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/profanity"
+	"github.com/emilmalmsten/chirpy/internal/ratelimit"
+	"github.com/emilmalmsten/chirpy/internal/search"
+	"github.com/emilmalmsten/chirpy/internal/stream"
+	"github.com/emilmalmsten/chirpy/internal/webhooks"
+)
+
+// newTestConfig returns a config backed by a fresh database, with the
+// synchronous event subscribers connected. Outgoing requests use plain
+// clients, so tests can talk to local servers
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := jsonDB.NewDB(filepath.Join(t.TempDir(), "db.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+
+	cfg := &apiConfig{
+		DB:                  db,
+		jwtSecret:           "test-secret",
+		adminApiKey:         "test-admin-key",
+		deletionGracePeriod: 30 * 24 * time.Hour,
+		searchIndex:         search.NewIndex(),
+		chirpEditWindow:     time.Hour,
+		profanityFilter:     profanity.New(profanity.DefaultRules),
+		moderatorEmails:     map[string]bool{},
+		rateLimiter:         ratelimit.New(entitlements.RateLimitWindow),
+		webhooks:            webhooks.NewDispatcher(db, &http.Client{Timeout: time.Second}),
+		streamHub:           stream.NewHub(streamHistorySize),
+		publicURL:           "https://chirpy.example",
+		federation: &activitypub.Client{
+			HTTP:      &http.Client{Timeout: time.Second},
+			UserAgent: "Chirpy",
+		},
+	}
+	cfg.activityDeliverer = activitypub.NewDeliverer(db, cfg.federation, cfg.activitySigner)
+
+	bus := events.NewBus(db)
+	cfg.subscribeEvents(bus)
+	db.OnEventsRecorded(bus.Dispatch)
+	return cfg
+}
+
+func mustCreateUser(t *testing.T, cfg *apiConfig, email, handle string) jsonDB.User {
+	t.Helper()
+	user, err := cfg.DB.CreateUser(email, "hash", handle)
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %s", email, err)
+	}
+	return user
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/blobstore"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/media"
+	"github.com/go-chi/chi"
+)
+
+const (
+	mediaCacheMaxAge = 365 * 24 * time.Hour
+	// unattachedMediaTTL is how long an upload can wait to be attached to a
+	// chirp before it is deleted
+	unattachedMediaTTL = 24 * time.Hour
+)
+
+type attachmentResponse struct {
+	Id           string `json:"id"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func newAttachmentResponse(m jsonDB.Media) attachmentResponse {
+	return attachmentResponse{
+		Id:           m.Id,
+		ContentType:  m.ContentType,
+		Size:         m.Size,
+		Width:        m.Width,
+		Height:       m.Height,
+		URL:          "/api/media/" + m.Id,
+		ThumbnailURL: "/api/media/" + m.Id + "/thumbnail",
+	}
+}
+
+func thumbnailKey(mediaId string) string {
+	return mediaId + "_thumb"
+}
+
+func newMediaId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (cfg *apiConfig) handlerUploadMedia(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	ent, err := cfg.entitlementsFor(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+
+	// leave some room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, ent.MaxUploadSize+64<<10)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "file is too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "expected an image in the file form field")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, ent.MaxUploadSize+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't read file")
+		return
+	}
+	if int64(len(data)) > ent.MaxUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+
+	usage, err := cfg.DB.GetMediaUsage(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to check media storage")
+		return
+	}
+
+	img, err := media.Process(data)
+	if err != nil {
+		if errors.Is(err, media.ErrUnsupportedType) {
+			respondWithError(w, http.StatusUnsupportedMediaType, "only jpeg, png and gif images are supported")
+			return
+		}
+		if errors.Is(err, media.ErrTooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "image dimensions are too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "couldn't process image")
+		return
+	}
+
+	// CreateMedia checks the quota again when it saves the upload, this
+	// only saves storing images that can't fit
+	if usage+int64(len(img.Data)) > ent.MediaStorageQuota {
+		respondWithError(w, http.StatusForbidden, "media storage quota exceeded")
+		return
+	}
+
+	// only valid uploads count against the rate limit
+	if !cfg.checkRateLimit(w, "upload", userId, ent.UploadsPerHour) {
+		return
+	}
+
+	mediaId, err := newMediaId()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't create media id")
+		return
+	}
+
+	err = cfg.blobs.Put(r.Context(), mediaId, bytes.NewReader(img.Data))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't store image")
+		return
+	}
+	err = cfg.blobs.Put(r.Context(), thumbnailKey(mediaId), bytes.NewReader(img.Thumbnail))
+	if err != nil {
+		cfg.deleteMediaBlobs([]string{mediaId})
+		respondWithError(w, http.StatusInternalServerError, "couldn't store thumbnail")
+		return
+	}
+
+	m, err := cfg.DB.CreateMedia(jsonDB.Media{
+		Id:                   mediaId,
+		OwnerId:              userId,
+		ContentType:          img.ContentType,
+		Size:                 int64(len(img.Data)),
+		Width:                img.Width,
+		Height:               img.Height,
+		ThumbnailContentType: img.ThumbnailContentType,
+	}, ent.MediaStorageQuota)
+	if err != nil {
+		cfg.deleteMediaBlobs([]string{mediaId})
+		if errors.Is(err, jsonDB.ErrQuotaExceeded) {
+			respondWithError(w, http.StatusForbidden, "media storage quota exceeded")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "couldn't save media")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newAttachmentResponse(m))
+}
+
+func (cfg *apiConfig) handlerGetMedia(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, false)
+}
+
+func (cfg *apiConfig) handlerGetMediaThumbnail(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, true)
+}
+
+// serveMedia writes a stored image or its thumbnail. Media never changes
+// once uploaded, so responses can be cached for a long time
+func (cfg *apiConfig) serveMedia(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	m, err := cfg.DB.GetMedia(chi.URLParam(r, "mediaID"))
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "media not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch media")
+		return
+	}
+
+	key, contentType := m.Id, m.ContentType
+	if thumbnail {
+		key, contentType = thumbnailKey(m.Id), m.ThumbnailContentType
+	}
+
+	blob, err := cfg.blobs.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "media not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to read media")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(mediaCacheMaxAge.Seconds())))
+	w.Header().Set("ETag", fmt.Sprintf("%q", key))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// ServeContent handles If-None-Match and range requests for seekable blobs
+	if seeker, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", m.CreatedAt, seeker)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == w.Header().Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// deleteMediaBlobs removes the images and thumbnails of media that no longer
+// exist in the database
+func (cfg *apiConfig) deleteMediaBlobs(mediaIds []string) {
+	ctx := context.Background()
+	for _, id := range mediaIds {
+		for _, key := range []string{id, thumbnailKey(id)} {
+			err := cfg.blobs.Delete(ctx, key)
+			if err != nil {
+				log.Printf("Error deleting blob %s: %s", key, err)
+			}
+		}
+	}
+}
+
+// pruneUnattachedMediaLoop deletes uploads that were never attached to a
+// chirp, so abandoned uploads don't take up storage and quota forever
+func (cfg *apiConfig) pruneUnattachedMediaLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pruned, err := cfg.DB.PruneUnattachedMedia(time.Now().UTC().Add(-unattachedMediaTTL))
+		if err != nil {
+			log.Printf("Error pruning unattached media: %s", err)
+			continue
+		}
+		cfg.deleteMediaBlobs(pruned)
+		if len(pruned) > 0 {
+			log.Printf("Pruned %d unattached uploads", len(pruned))
+		}
+	}
+}
+
+// attachmentResponses looks up the media attached to chirps in one batch
+func (cfg *apiConfig) attachmentResponses(chirps []jsonDB.Chirp) (map[string]attachmentResponse, error) {
+	ids := []string{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.MediaIds...)
+	}
+	if len(ids) == 0 {
+		return map[string]attachmentResponse{}, nil
+	}
+
+	mediaById, err := cfg.DB.GetMediaByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make(map[string]attachmentResponse, len(mediaById))
+	for id, m := range mediaById {
+		responses[id] = newAttachmentResponse(m)
+	}
+
+	return responses, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/ratelimit"
+)
+
+func (cfg *apiConfig) handlerGetMembership(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+
+	events, err := cfg.DB.GetMembershipEvents(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch membership history")
+		return
+	}
+
+	type response struct {
+		Is_chirpy_red bool                      `json:"is_chirpy_red"`
+		Membership    *jsonDB.Membership        `json:"membership"`
+		Entitlements  entitlements.Entitlements `json:"entitlements"`
+		History       []jsonDB.MembershipEvent  `json:"history"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Is_chirpy_red: user.IsChirpyRed(time.Now()),
+		Membership:    user.Membership,
+		Entitlements:  userEntitlements(user),
+		History:       events,
+	})
+}
+
+// expireMembershipsLoop ends memberships whose paid period has run out
+func (cfg *apiConfig) expireMembershipsLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := cfg.DB.ExpireMemberships(time.Now().UTC())
+		if err != nil {
+			log.Printf("Error expiring memberships: %s", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Expired %d memberships", expired)
+		}
+	}
+}
+
+// userEntitlements returns what a user can do with their current membership
+func userEntitlements(user jsonDB.User) entitlements.Entitlements {
+	if user.IsChirpyRed(time.Now()) {
+		return entitlements.ForTier(entitlements.TierChirpyRed)
+	}
+	return entitlements.Free()
+}
+
+// entitlementsFor looks up a user and returns their entitlements
+func (cfg *apiConfig) entitlementsFor(userId int) (entitlements.Entitlements, error) {
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	return userEntitlements(user), nil
+}
+
+// allowAction counts an action by a user against their limit for it
+func (cfg *apiConfig) allowAction(action string, userId, limit int, now time.Time) ratelimit.Result {
+	return cfg.rateLimiter.Allow(fmt.Sprintf("%s:%d", action, userId), limit, now)
+}
+
+// checkRateLimit counts an action by a user against their limit for it and
+// sets the rate limit headers. When the limit is reached it responds with
+// 429 and returns false
+func (cfg *apiConfig) checkRateLimit(w http.ResponseWriter, action string, userId, limit int) bool {
+	now := time.Now()
+	result := cfg.allowAction(action, userId, limit, now)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+
+	if !result.Allowed {
+		retryAfter := int(result.ResetAt.Sub(now).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+const maxReportDetailsLength = 500
+
+var errNotModerator = errors.New("user is not a moderator")
+
+// isModerator reports whether a user's email is one of the moderator emails
+// in the configuration
+func (cfg *apiConfig) isModerator(userId int) bool {
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		return false
+	}
+	return cfg.moderatorEmails[strings.ToLower(user.Email)]
+}
+
+// authenticateModerator is authenticateUser for endpoints that only
+// moderators can use
+func (cfg *apiConfig) authenticateModerator(r *http.Request) (int, error) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		return 0, err
+	}
+	if !cfg.isModerator(userId) {
+		return 0, errNotModerator
+	}
+	return userId, nil
+}
+
+// respondWithModeratorAuthError responds to a request that failed
+// authenticateModerator
+func respondWithModeratorAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotModerator) {
+		respondWithError(w, http.StatusForbidden, "only moderators can do this")
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+}
+
+// canViewChirp reports whether the viewer of a request can see a chirp.
+// Hidden chirps stay visible to their author and to moderators
+func (cfg *apiConfig) canViewChirp(r *http.Request, chirp jsonDB.Chirp) bool {
+	if !chirp.Hidden {
+		return true
+	}
+	return cfg.viewerCanSeeChirp(cfg.viewerID(r), chirp)
+}
+
+// viewerCanSeeChirp is canViewChirp for a viewer that is already known. 0
+// is an anonymous viewer
+func (cfg *apiConfig) viewerCanSeeChirp(viewerId int, chirp jsonDB.Chirp) bool {
+	if !chirp.Hidden {
+		return true
+	}
+	if viewerId == 0 {
+		return false
+	}
+	return viewerId == chirp.AuthorId || cfg.isModerator(viewerId)
+}
+
+func isReportReason(reason string) bool {
+	for _, r := range jsonDB.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	if !isReportReason(params.Reason) {
+		respondWithError(w, http.StatusBadRequest, "reason must be one of "+strings.Join(jsonDB.ReportReasons, ", "))
+		return
+	}
+	if utf8.RuneCountInString(params.Details) > maxReportDetailsLength {
+		respondWithError(w, http.StatusBadRequest, "details are too long")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil || !chirp.Visible() {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+	if chirp.AuthorId == userId {
+		respondWithError(w, http.StatusBadRequest, "you can't report your own chirp")
+		return
+	}
+
+	report, _, err := cfg.DB.ReportChirp(chirpID, userId, params.Reason, params.Details, cfg.autoHideThreshold)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		if errors.Is(err, jsonDB.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "you already reported this chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to report chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+type moderationCaseResponse struct {
+	jsonDB.ModerationCase
+	ReportCount int            `json:"report_count"`
+	Reasons     map[string]int `json:"reasons"`
+	Chirp       *chirpResponse `json:"chirp"`
+}
+
+// moderationCaseResponses adds the reported chirps and a summary of the
+// report reasons to cases
+func (cfg *apiConfig) moderationCaseResponses(r *http.Request, cases []jsonDB.ModerationCase) ([]moderationCaseResponse, error) {
+	chirpIds := make([]int, 0, len(cases))
+	reportIds := []int{}
+	for _, mc := range cases {
+		chirpIds = append(chirpIds, mc.ChirpId)
+		reportIds = append(reportIds, mc.ReportIds...)
+	}
+
+	chirpsById, err := cfg.DB.GetModeratedChirps(chirpIds)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := cfg.DB.GetReports(reportIds)
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := make([]jsonDB.Chirp, 0, len(chirpsById))
+	for _, mc := range cases {
+		if chirp, ok := chirpsById[mc.ChirpId]; ok {
+			chirps = append(chirps, chirp)
+		}
+	}
+	chirpResponses, err := cfg.chirpResponses(r, chirps)
+	if err != nil {
+		return nil, err
+	}
+	responsesById := make(map[int]*chirpResponse, len(chirpResponses))
+	for i := range chirpResponses {
+		responsesById[chirpResponses[i].Id] = &chirpResponses[i]
+	}
+
+	responses := make([]moderationCaseResponse, 0, len(cases))
+	for _, mc := range cases {
+		reasons := map[string]int{}
+		for _, id := range mc.ReportIds {
+			if report, ok := reports[id]; ok {
+				reasons[report.Reason]++
+			}
+		}
+		responses = append(responses, moderationCaseResponse{
+			ModerationCase: mc,
+			ReportCount:    len(mc.ReportIds),
+			Reasons:        reasons,
+			Chirp:          responsesById[mc.ChirpId],
+		})
+	}
+
+	return responses, nil
+}
+
+func (cfg *apiConfig) handlerGetModerationCases(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateModerator(r)
+	if err != nil {
+		respondWithModeratorAuthError(w, err)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != jsonDB.CaseOpen && status != jsonDB.CaseClaimed && status != jsonDB.CaseResolved {
+		respondWithError(w, http.StatusBadRequest, "status must be open, claimed or resolved")
+		return
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offset, err := decodeOffsetCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cases, err := cfg.DB.GetModerationCases(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch moderation cases")
+		return
+	}
+
+	nextCursor := ""
+	if offset > len(cases) {
+		offset = len(cases)
+	}
+	page := cases[offset:]
+	if len(page) > limit {
+		page = page[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	responses, err := cfg.moderationCaseResponses(r, page)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch reported chirps")
+		return
+	}
+
+	type response struct {
+		Total      int                      `json:"total"`
+		Cases      []moderationCaseResponse `json:"cases"`
+		NextCursor string                   `json:"next_cursor,omitempty"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Total:      len(cases),
+		Cases:      responses,
+		NextCursor: nextCursor,
+	})
+}
+
+func (cfg *apiConfig) handlerGetModerationCase(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateModerator(r)
+	if err != nil {
+		respondWithModeratorAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	mc, reports, err := cfg.DB.GetModerationCase(chirpID)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "moderation case not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch moderation case")
+		return
+	}
+
+	actions, err := cfg.DB.GetModerationLog(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch moderation log")
+		return
+	}
+
+	responses, err := cfg.moderationCaseResponses(r, []jsonDB.ModerationCase{mc})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch reported chirp")
+		return
+	}
+
+	type response struct {
+		moderationCaseResponse
+		Reports []jsonDB.Report           `json:"reports"`
+		Log     []jsonDB.ModerationAction `json:"log"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		moderationCaseResponse: responses[0],
+		Reports:                reports,
+		Log:                    actions,
+	})
+}
+
+func (cfg *apiConfig) handlerClaimModerationCase(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := cfg.authenticateModerator(r)
+	if err != nil {
+		respondWithModeratorAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	mc, err := cfg.DB.ClaimModerationCase(chirpID, moderatorId)
+	if err != nil {
+		respondWithModerationCaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mc)
+}
+
+func (cfg *apiConfig) handlerResolveModerationCase(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := cfg.authenticateModerator(r)
+	if err != nil {
+		respondWithModeratorAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	type parameters struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	switch params.Action {
+	case jsonDB.ResolutionHide, jsonDB.ResolutionDelete, jsonDB.ResolutionDismiss:
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be hide, delete or dismiss")
+		return
+	}
+
+	mc, chirp, err := cfg.DB.ResolveModerationCase(chirpID, moderatorId, params.Action, params.Note)
+	if err != nil {
+		respondWithModerationCaseError(w, err)
+		return
+	}
+
+	if params.Action == jsonDB.ResolutionDelete {
+		cfg.deleteMediaBlobs(chirp.MediaIds)
+	}
+
+	respondWithJSON(w, http.StatusOK, mc)
+}
+
+// respondWithModerationCaseError responds to a failed claim or resolution
+func respondWithModerationCaseError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jsonDB.ErrDoesNotExists):
+		respondWithError(w, http.StatusNotFound, "moderation case not found")
+	case errors.Is(err, jsonDB.ErrCaseClaimed):
+		respondWithError(w, http.StatusConflict, "case is claimed by another moderator")
+	case errors.Is(err, jsonDB.ErrCaseResolved):
+		respondWithError(w, http.StatusConflict, "case is already resolved")
+	default:
+		respondWithError(w, http.StatusInternalServerError, "failed to update moderation case")
+	}
+}
+
+func (cfg *apiConfig) handlerGetModerationLog(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateModerator(r)
+	if err != nil {
+		respondWithModeratorAuthError(w, err)
+		return
+	}
+
+	chirpID := 0
+	if chirpIdString := r.URL.Query().Get("chirp_id"); chirpIdString != "" {
+		chirpID, err = strconv.Atoi(chirpIdString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+			return
+		}
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offset, err := decodeOffsetCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	actions, err := cfg.DB.GetModerationLog(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch moderation log")
+		return
+	}
+
+	nextCursor := ""
+	if offset > len(actions) {
+		offset = len(actions)
+	}
+	page := actions[offset:]
+	if len(page) > limit {
+		page = page[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	type response struct {
+		Actions    []jsonDB.ModerationAction `json:"actions"`
+		NextCursor string                    `json:"next_cursor,omitempty"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Actions:    page,
+		NextCursor: nextCursor,
+	})
+}
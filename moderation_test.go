@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func TestModerationListsLinkOnlyToExistingPages(t *testing.T) {
+	cfg := newTestConfig(t)
+	moderator := mustCreateUser(t, cfg, "mod@example.com", "mod")
+	cfg.moderatorEmails["mod@example.com"] = true
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+	bob := mustCreateUser(t, cfg, "b@example.com", "bob")
+	for i := 0; i < 3; i++ {
+		chirp, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: alice.Id})
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+		_, _, err = cfg.DB.ReportChirp(chirp.Id, bob.Id, jsonDB.ReasonSpam, "", 0)
+		if err != nil {
+			t.Fatalf("ReportChirp: %s", err)
+		}
+	}
+	token := mustCreateToken(t, cfg, moderator.Id, auth.TokenTypeAccess)
+
+	get := func(handler http.HandlerFunc, params url.Values) (string, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/?"+params.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		resp := struct {
+			NextCursor string `json:"next_cursor"`
+		}{}
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("decoding %s: %s", rec.Body, err)
+		}
+		return resp.NextCursor, rec.Header().Get("Link")
+	}
+
+	cursor, link := get(cfg.handlerGetModerationCases, url.Values{"limit": {"2"}})
+	if cursor == "" || link == "" {
+		t.Fatalf("first page: next_cursor = %q, Link = %q, want both set", cursor, link)
+	}
+	cursor, link = get(cfg.handlerGetModerationCases, url.Values{"limit": {"2"}, "cursor": {cursor}})
+	if cursor != "" || link != "" {
+		t.Errorf("last page: next_cursor = %q, Link = %q, want neither", cursor, link)
+	}
+
+	cursor, link = get(cfg.handlerGetModerationLog, url.Values{})
+	if cursor != "" || link != "" {
+		t.Errorf("moderation log: next_cursor = %q, Link = %q, want neither", cursor, link)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+// notifyChirpCreated tells the author of the chirp being replied to and the
+// users mentioned in a new chirp about it. Failures are logged rather than
+// failing the request that created the chirp
+func (cfg *apiConfig) notifyChirpCreated(chirp jsonDB.Chirp) {
+	notifications := []jsonDB.Notification{}
+	notified := map[int]bool{}
+
+	if chirp.InReplyTo != 0 {
+		parent, err := cfg.DB.GetChirp(chirp.InReplyTo)
+		if err == nil {
+			notifications = append(notifications, jsonDB.Notification{
+				UserId:  parent.AuthorId,
+				Type:    jsonDB.NotificationReply,
+				ActorId: chirp.AuthorId,
+				ChirpId: chirp.Id,
+			})
+			notified[parent.AuthorId] = true
+		}
+	}
+
+	for _, mention := range chirp.Entities.Mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		notifications = append(notifications, jsonDB.Notification{
+			UserId:  mention.UserId,
+			Type:    jsonDB.NotificationMention,
+			ActorId: chirp.AuthorId,
+			ChirpId: chirp.Id,
+		})
+		notified[mention.UserId] = true
+	}
+
+	cfg.notify(notifications...)
+}
+
+// notifyEngagement tells the author of a chirp that it was liked or
+// rechirped
+func (cfg *apiConfig) notifyEngagement(notificationType string, e jsonDB.EngagementEvent) {
+	cfg.notify(jsonDB.Notification{
+		UserId:  e.AuthorId,
+		Type:    notificationType,
+		ActorId: e.UserId,
+		ChirpId: e.ChirpId,
+	})
+}
+
+// notifyChirpDeleted removes the notifications pointing at a deleted chirp
+func (cfg *apiConfig) notifyChirpDeleted(chirpId int) {
+	err := cfg.DB.DeleteChirpNotifications(chirpId)
+	if err != nil {
+		log.Printf("Error removing notifications of chirp %d: %s", chirpId, err)
+	}
+}
+
+func (cfg *apiConfig) notify(notifications ...jsonDB.Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	_, err := cfg.DB.CreateNotifications(notifications)
+	if err != nil {
+		log.Printf("Error creating notifications: %s", err)
+	}
+}
+
+type notificationResponse struct {
+	jsonDB.Notification
+	Actor *authorSummary `json:"actor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerGetNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	beforeId, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	unreadOnly := false
+	unreadString := r.URL.Query().Get("unread")
+	if unreadString != "" {
+		unreadOnly, err = strconv.ParseBool(unreadString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "unread must be true or false")
+			return
+		}
+	}
+
+	notifications, err := cfg.DB.GetNotifications(userId, beforeId, limit+1, unreadOnly)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch notifications")
+		return
+	}
+
+	nextCursor := ""
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		nextCursor = encodeCursor(notifications[len(notifications)-1].Id)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	actorIds := make([]int, 0, len(notifications))
+	for _, notification := range notifications {
+		actorIds = append(actorIds, notification.ActorId)
+	}
+	actors, err := cfg.DB.GetUsers(actorIds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch users")
+		return
+	}
+
+	responses := make([]notificationResponse, 0, len(notifications))
+	for _, notification := range notifications {
+		response := notificationResponse{Notification: notification}
+		if actor, ok := actors[notification.ActorId]; ok {
+			response.Actor = &authorSummary{
+				Id:          actor.Id,
+				Handle:      actor.Handle,
+				DisplayName: actor.DisplayName,
+				AvatarURL:   actor.AvatarURL,
+			}
+		}
+		responses = append(responses, response)
+	}
+
+	unread, err := cfg.DB.CountUnreadNotifications(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count notifications")
+		return
+	}
+
+	type response struct {
+		Notifications []notificationResponse `json:"notifications"`
+		UnreadCount   int                    `json:"unread_count"`
+		NextCursor    string                 `json:"next_cursor,omitempty"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Notifications: responses,
+		UnreadCount:   unread,
+		NextCursor:    nextCursor,
+	})
+}
+
+func (cfg *apiConfig) handlerUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	unread, err := cfg.DB.CountUnreadNotifications(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count notifications")
+		return
+	}
+
+	type response struct {
+		UnreadCount int `json:"unread_count"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UnreadCount: unread,
+	})
+}
+
+func (cfg *apiConfig) handlerMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	type parameters struct {
+		Ids []int `json:"ids"`
+		All bool  `json:"all"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	if len(params.Ids) == 0 && !params.All {
+		respondWithError(w, http.StatusBadRequest, "either ids or all must be set")
+		return
+	}
+	if params.All {
+		params.Ids = nil
+	}
+
+	marked, err := cfg.DB.MarkNotificationsRead(userId, params.Ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to mark notifications as read")
+		return
+	}
+
+	type response struct {
+		Marked int `json:"marked"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Marked: marked,
+	})
+}
+
+type notificationPreferences struct {
+	Muted []string `json:"muted"`
+}
+
+func (cfg *apiConfig) handlerGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+
+	muted := user.MutedNotificationTypes
+	if muted == nil {
+		muted = []string{}
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences{
+		Muted: muted,
+	})
+}
+
+func (cfg *apiConfig) handlerUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := notificationPreferences{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	muted := []string{}
+	seen := map[string]bool{}
+	for _, notificationType := range params.Muted {
+		if !isNotificationType(notificationType) {
+			respondWithError(w, http.StatusBadRequest, "unknown notification type: "+notificationType)
+			return
+		}
+		if !seen[notificationType] {
+			seen[notificationType] = true
+			muted = append(muted, notificationType)
+		}
+	}
+
+	_, err = cfg.DB.SetMutedNotificationTypes(userId, muted)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences{
+		Muted: muted,
+	})
+}
+
+func isNotificationType(notificationType string) bool {
+	for _, t := range jsonDB.NotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
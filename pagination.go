@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor turns the ID of the last item of a page into an opaque cursor
+func encodeCursor(lastId int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(lastId)))
+}
+
+// decodeCursor returns the ID stored in a cursor made by encodeCursor. An
+// empty cursor decodes to 0
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+
+	idString, ok := strings.CutPrefix(string(dat), "id:")
+	if !ok {
+		return 0, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil || id < 1 {
+		return 0, errInvalidCursor
+	}
+
+	return id, nil
+}
+
+// encodeOffsetCursor turns the position of the next item of a ranked list
+// into an opaque cursor
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeOffsetCursor returns the offset stored in a cursor made by
+// encodeOffsetCursor. An empty cursor decodes to 0
+func decodeOffsetCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+
+	offsetString, ok := strings.CutPrefix(string(dat), "offset:")
+	if !ok {
+		return 0, errInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(offsetString)
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+
+	return offset, nil
+}
+
+// parsePageLimit reads the limit query parameter, falling back to the
+// default page size and capping it at the maximum page size
+func parsePageLimit(r *http.Request) (int, error) {
+	limitString := r.URL.Query().Get("limit")
+	if limitString == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(limitString)
+	if err != nil || limit < 1 {
+		return 0, errors.New("invalid limit")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return limit, nil
+}
+
+// setNextPageLink points the client to the next page of a list with a Link
+// header, keeping the other query parameters of the request
+func setNextPageLink(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", nextCursor)
+	next := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	w.Header().Set("X-Next-Cursor", nextCursor)
+}
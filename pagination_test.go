@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id, err := decodeCursor(encodeCursor(42))
+	if err != nil || id != 42 {
+		t.Errorf("decodeCursor(encodeCursor(42)) = %d, %v", id, err)
+	}
+	offset, err := decodeOffsetCursor(encodeOffsetCursor(0))
+	if err != nil || offset != 0 {
+		t.Errorf("decodeOffsetCursor(encodeOffsetCursor(0)) = %d, %v", offset, err)
+	}
+	id, err = decodeCursor("")
+	if err != nil || id != 0 {
+		t.Errorf("decodeCursor(\"\") = %d, %v", id, err)
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	cursors := map[string]string{
+		"not base64":       "!!!",
+		"padded encoding":  base64.URLEncoding.EncodeToString([]byte("id:1")),
+		"offset cursor":    encodeOffsetCursor(3),
+		"missing prefix":   encode("12"),
+		"zero id":          encode("id:0"),
+		"negative id":      encode("id:-4"),
+		"non numeric id":   encode("id:abc"),
+		"numeric overflow": encode("id:99999999999999999999"),
+	}
+	for name, cursor := range cursors {
+		_, err := decodeCursor(cursor)
+		if !errors.Is(err, errInvalidCursor) {
+			t.Errorf("%s: err = %v, want %v", name, err, errInvalidCursor)
+		}
+	}
+
+	offsetCursors := map[string]string{
+		"id cursor":       encodeCursor(2),
+		"negative offset": encode("offset:-1"),
+	}
+	for name, cursor := range offsetCursors {
+		_, err := decodeOffsetCursor(cursor)
+		if !errors.Is(err, errInvalidCursor) {
+			t.Errorf("%s: err = %v, want %v", name, err, errInvalidCursor)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const (
+	maxWebhookBodySize        = 1 << 20
+	webhookSignatureTolerance = 5 * time.Minute
+	// webhookProcessingTimeout is how long an event can be processing before
+	// a retried delivery takes it over
+	webhookProcessingTimeout = 5 * time.Minute
+)
+
+// polkaEvent is a webhook sent by Polka, our payment provider. Id identifies
+// the event across retried deliveries
+type polkaEvent struct {
+	Id    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID           int       `json:"user_id"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+func (cfg *apiConfig) handlerUpgradeMembership(w http.ResponseWriter, r *http.Request) {
+	err := auth.ValidateApiKey(r.Header, cfg.polkaApiKey)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "could not verify api key")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't read body")
+		return
+	}
+
+	if cfg.polkaWebhookSecret != "" {
+		err = auth.VerifySignature(r.Header.Get("X-Polka-Signature"), body, cfg.polkaWebhookSecret, webhookSignatureTolerance, time.Now())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "invalid webhook signature")
+			return
+		}
+	}
+
+	params := polkaEvent{}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	eventId := polkaEventId(params)
+	_, process, err := cfg.DB.BeginWebhookEvent(jsonDB.WebhookEvent{
+		Id:      eventId,
+		Source:  "polka",
+		Event:   params.Event,
+		Payload: body,
+	}, webhookProcessingTimeout)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to record webhook event")
+		return
+	}
+	if !process {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	status, err := cfg.processPolkaEvent(params)
+	if errors.Is(err, jsonDB.ErrNoMembership) {
+		// retrying won't make a membership appear, so don't ask for a retry
+		cfg.finishWebhookEvent(eventId, jsonDB.WebhookIgnored, err.Error())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		cfg.finishWebhookEvent(eventId, jsonDB.WebhookFailed, err.Error())
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to process webhook event")
+		return
+	}
+
+	cfg.finishWebhookEvent(eventId, status, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// polkaEventId returns the key that deliveries of the same event share.
+// Events without an id from Polka are keyed by what they change: the event,
+// the user and the end of the membership period. Polka signs every delivery
+// again, so the signature can't tell retries apart, while renewals differ
+// in the period they extend the membership to
+func polkaEventId(event polkaEvent) string {
+	if event.Id != "" {
+		return event.Id
+	}
+	key := fmt.Sprintf("%s\n%d\n%s", event.Event, event.Data.UserID, event.Data.CurrentPeriodEnd.UTC().Format(time.RFC3339Nano))
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// processPolkaEvent applies a Polka event and returns the status to record
+// for it. Events we don't handle are ignored
+func (cfg *apiConfig) processPolkaEvent(event polkaEvent) (string, error) {
+	switch event.Event {
+	case jsonDB.EventUserUpgraded, jsonDB.EventUserDowngraded,
+		jsonDB.EventSubscriptionRenewed, jsonDB.EventSubscriptionCancelled:
+		_, err := cfg.DB.ApplyMembershipEvent(event.Data.UserID, event.Event, event.Data.CurrentPeriodEnd)
+		if err != nil {
+			return "", err
+		}
+		return jsonDB.WebhookProcessed, nil
+	default:
+		return jsonDB.WebhookIgnored, nil
+	}
+}
+
+func (cfg *apiConfig) finishWebhookEvent(id, status, errorMessage string) {
+	err := cfg.DB.FinishWebhookEvent(id, status, errorMessage)
+	if err != nil {
+		log.Printf("Error recording outcome of webhook event %s: %s", id, err)
+	}
+}
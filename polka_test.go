@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/auth"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func sendPolkaEvent(t *testing.T, cfg *apiConfig, body string, signedAt time.Time) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	req.Header.Set("Authorization", "ApiKey "+cfg.polkaApiKey)
+	if cfg.polkaWebhookSecret != "" {
+		req.Header.Set("X-Polka-Signature", auth.SignPayload([]byte(body), cfg.polkaWebhookSecret, signedAt))
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerUpgradeMembership(rec, req)
+	return rec.Code
+}
+
+func countMembershipEvents(t *testing.T, cfg *apiConfig, userId int) int {
+	t.Helper()
+	events, err := cfg.DB.GetMembershipEvents(userId)
+	if err != nil {
+		t.Fatalf("GetMembershipEvents: %s", err)
+	}
+	return len(events)
+}
+
+func TestPolkaWebhookDedupesById(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.polkaApiKey = "polka-key"
+	user := mustCreateUser(t, cfg, "a@example.com", "alice")
+
+	body := `{"id":"evt_1","event":"` + jsonDB.EventUserUpgraded + `","data":{"user_id":` + strconv.Itoa(user.Id) + `}}`
+	for i := 0; i < 2; i++ {
+		if code := sendPolkaEvent(t, cfg, body, time.Now()); code != http.StatusNoContent {
+			t.Fatalf("delivery %d: status = %d, want %d", i, code, http.StatusNoContent)
+		}
+	}
+	if n := countMembershipEvents(t, cfg, user.Id); n != 1 {
+		t.Errorf("got %d membership events, want 1", n)
+	}
+}
+
+func TestPolkaWebhookWithoutIdDedupesRetries(t *testing.T) {
+	for _, secret := range []string{"", "polka-secret"} {
+		cfg := newTestConfig(t)
+		cfg.polkaApiKey = "polka-key"
+		cfg.polkaWebhookSecret = secret
+		user := mustCreateUser(t, cfg, "a@example.com", "alice")
+
+		event := func(name string, periodEnd time.Time) string {
+			return `{"event":"` + name + `","data":{"user_id":` + strconv.Itoa(user.Id) +
+				`,"current_period_end":"` + periodEnd.Format(time.RFC3339) + `"}}`
+		}
+		periodEnd := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+		upgrade := event(jsonDB.EventUserUpgraded, periodEnd)
+		renewal := event(jsonDB.EventSubscriptionRenewed, periodEnd.Add(30*24*time.Hour))
+
+		now := time.Now()
+		sendPolkaEvent(t, cfg, upgrade, now)
+		// retries are signed again when they are sent
+		sendPolkaEvent(t, cfg, upgrade, now.Add(time.Minute))
+		sendPolkaEvent(t, cfg, renewal, now.Add(time.Minute))
+		sendPolkaEvent(t, cfg, renewal, now.Add(2*time.Minute))
+
+		if n := countMembershipEvents(t, cfg, user.Id); n != 2 {
+			t.Errorf("secret %q: got %d membership events, want 2", secret, n)
+		}
+	}
+}
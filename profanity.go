@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/emilmalmsten/chirpy/internal/profanity"
+)
+
+// handlerReloadProfanity reads the profanity word list file again so it can
+// be changed without restarting the server
+func (cfg *apiConfig) handlerReloadProfanity(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	count, err := cfg.profanityFilter.Reload()
+	if err != nil {
+		if errors.Is(err, profanity.ErrNoWordList) {
+			respondWithError(w, http.StatusConflict, "no profanity word list file is configured")
+			return
+		}
+		// the error names the file, which stays in the log
+		log.Printf("Error reloading profanity word list: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to reload profanity word list")
+		return
+	}
+
+	type response struct {
+		Rules int `json:"rules"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Rules: count,
+	})
+}
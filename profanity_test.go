@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/profanity"
+)
+
+func TestReloadProfanity(t *testing.T) {
+	cfg := newTestConfig(t)
+	path := filepath.Join(t.TempDir(), "words.txt")
+	err := os.WriteFile(path, []byte("darn\nheck\n"), 0o600)
+	if err != nil {
+		t.Fatalf("writing word list: %s", err)
+	}
+	cfg.profanityFilter, err = profanity.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	reload := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/profanity/reload", nil)
+		if apiKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+apiKey)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerReloadProfanity(rec, req)
+		return rec
+	}
+
+	if rec := reload(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without an api key: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := reload("wrong-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with the wrong api key: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec := reload(cfg.adminApiKey)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"rules":2`) {
+		t.Errorf("reload: %d %s, want 2 rules", rec.Code, rec.Body)
+	}
+
+	os.Remove(path)
+	rec = reload(cfg.adminApiKey)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("reload of a missing file: status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rec.Body.String(), path) {
+		t.Errorf("response leaks the word list path: %s", rec.Body)
+	}
+}
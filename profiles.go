@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+
+// reservedHandles can't be registered because they clash with routes
+var reservedHandles = map[string]bool{
+	"me": true,
+}
+
+type publicProfile struct {
+	Id             int       `json:"id"`
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	CreatedAt      time.Time `json:"created_at"`
+	FollowersCount *int      `json:"followers_count,omitempty"`
+	FollowingCount *int      `json:"following_count,omitempty"`
+}
+
+func newPublicProfile(user jsonDB.User) publicProfile {
+	return publicProfile{
+		Id:          user.Id,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+type userListResponse struct {
+	Count int             `json:"count"`
+	Users []publicProfile `json:"users"`
+}
+
+// respondWithUserList responds with the public profiles of the given users,
+// keeping their order
+func (cfg *apiConfig) respondWithUserList(w http.ResponseWriter, userIds []int) {
+	users, err := cfg.DB.GetUsers(userIds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch users")
+		return
+	}
+
+	profiles := make([]publicProfile, 0, len(userIds))
+	for _, id := range userIds {
+		user, ok := users[id]
+		if !ok {
+			continue
+		}
+		profiles = append(profiles, newPublicProfile(user))
+	}
+
+	respondWithJSON(w, http.StatusOK, userListResponse{
+		Count: len(profiles),
+		Users: profiles,
+	})
+}
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("handle must be 1-15 letters, digits or underscores")
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return errors.New("handle is reserved")
+	}
+	return nil
+}
+
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return errors.New("avatar url is too long")
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("avatar url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// validateProfileUpdate checks the fields a profile update changes
+func validateProfileUpdate(update jsonDB.ProfileUpdate) error {
+	if update.Handle != nil {
+		err := validateHandle(*update.Handle)
+		if err != nil {
+			return err
+		}
+	}
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLength {
+		return errors.New("display name is too long")
+	}
+	if update.Bio != nil && utf8.RuneCountInString(*update.Bio) > maxBioLength {
+		return errors.New("bio is too long")
+	}
+	if update.AvatarURL != nil {
+		return validateAvatarURL(*update.AvatarURL)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerGetProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.userFromHandleParam(w, r)
+	if !ok {
+		return
+	}
+
+	followers, following, err := cfg.DB.CountFollows(user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count follows")
+		return
+	}
+
+	profile := newPublicProfile(user)
+	profile.FollowersCount = &followers
+	profile.FollowingCount = &following
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (cfg *apiConfig) handlerUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	type parameters struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	update := jsonDB.ProfileUpdate{
+		Handle:      params.Handle,
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		AvatarURL:   params.AvatarURL,
+	}
+	err = validateProfileUpdate(update)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := cfg.DB.UpdateProfile(userId, update)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrHandleTaken) {
+			respondWithError(w, http.StatusConflict, "handle already taken")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to update profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newPublicProfile(user))
+}
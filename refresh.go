@@ -1,11 +1,10 @@
 package main
 
 import (
-	"errors"
 	"net/http"
+	"time"
 
 	"github.com/emilmalmsten/chirpy/internal/auth"
-	"github.com/emilmalmsten/chirpy/internal/jsonDB"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -15,27 +14,19 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		if errors.Is(err, jsonDB.ErrAlreadyExists) {
-			respondWithError(w, http.StatusInternalServerError, "auth header missing")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "malformed auth header")
+		respondWithError(w, http.StatusUnauthorized, "malformed auth header")
 		return
 	}
 
-	isRevoked, err := cfg.DB.IsTokenRevoked(token)
+	userId, err := cfg.userForRefreshToken(token)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't check session")
-		return
-	}
-	if isRevoked {
-		respondWithError(w, http.StatusUnauthorized, "refresh token is revoked")
+		respondWithError(w, http.StatusUnauthorized, "couldn't validate JWT")
 		return
 	}
 
-	newToken, err := auth.RefreshToken(token, cfg.jwtSecret)
+	newToken, err := auth.CreateJWT(userId, []byte(cfg.jwtSecret), time.Hour, auth.TokenTypeAccess)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "couldn't validate JWT")
+		respondWithError(w, http.StatusInternalServerError, "jwt accessToken error")
 		return
 	}
 
@@ -51,7 +42,13 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.DB.RevokeToken(refreshToken)
+	userId, err := cfg.userForRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "couldn't validate JWT")
+		return
+	}
+
+	err = cfg.DB.RevokeToken(refreshToken, userId)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
 		return
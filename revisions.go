@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/emilmalmsten/chirpy/internal/chirptext"
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+var errEditingRequiresRed = errors.New("editing chirps requires Chirpy Red")
+
+// editChirp replaces the body of a chirp by its author, within the edit
+// window and the limits of their entitlements. Besides a
+// *chirptext.ValidationError for a rejected body, the errors caused by the
+// request are errEditingRequiresRed, jsonDB.ErrDoesNotExists,
+// jsonDB.ErrNotAuthorized and jsonDB.ErrEditWindowExpired
+func (cfg *apiConfig) editChirp(chirpId, userId int, ent entitlements.Entitlements, body string) (jsonDB.Chirp, error) {
+	if !ent.CanEditChirps {
+		return jsonDB.Chirp{}, errEditingRequiresRed
+	}
+
+	cleanChirp, flaggedWords, err := cfg.cleanChirpBody(body, ent.MaxChirpLength)
+	if err != nil {
+		return jsonDB.Chirp{}, err
+	}
+
+	ents, err := cfg.extractEntities(cleanChirp)
+	if err != nil {
+		return jsonDB.Chirp{}, fmt.Errorf("failed to resolve mentions: %w", err)
+	}
+
+	chirp, err := cfg.DB.EditChirp(chirpId, userId, jsonDB.ChirpEdit{
+		Body:         cleanChirp,
+		Entities:     ents,
+		FlaggedWords: flaggedWords,
+	}, cfg.chirpEditWindow)
+	if err != nil {
+		return jsonDB.Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+func (cfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	ent, err := cfg.entitlementsFor(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+		return
+	}
+	if !ent.CanEditChirps {
+		respondWithError(w, http.StatusForbidden, errEditingRequiresRed.Error())
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	chirp, err := cfg.editChirp(chirpID, userId, ent, params.Body)
+	if err != nil {
+		var validationErr *chirptext.ValidationError
+		if errors.As(err, &validationErr) {
+			respondWithChirpError(w, err)
+			return
+		} else if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		} else if errors.Is(err, jsonDB.ErrNotAuthorized) {
+			respondWithError(w, http.StatusForbidden, "unauthorized to edit chirp")
+			return
+		} else if errors.Is(err, jsonDB.ErrEditWindowExpired) {
+			respondWithError(w, http.StatusForbidden, "chirp can no longer be edited")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to edit chirp")
+		return
+	}
+
+	responses, err := cfg.chirpResponses(r, []jsonDB.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, responses[0])
+}
+
+func (cfg *apiConfig) handlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
+		return
+	}
+	if !cfg.canViewChirp(r, chirp) {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+
+	revisions, err := cfg.DB.GetChirpRevisions(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp history")
+		return
+	}
+
+	responses, err := cfg.chirpResponses(r, []jsonDB.Chirp{chirp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp")
+		return
+	}
+
+	type response struct {
+		Chirp     chirpResponse          `json:"chirp"`
+		Revisions []jsonDB.ChirpRevision `json:"revisions"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Chirp:     responses[0],
+		Revisions: revisions,
+	})
+}
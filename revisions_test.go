@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/entitlements"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func TestFreeUsersCanEditChirps(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+	chirp, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "helo", AuthorId: alice.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+
+	edited, err := cfg.editChirp(chirp.Id, alice.Id, entitlements.Free(), "hello")
+	if err != nil {
+		t.Fatalf("editChirp: %s", err)
+	}
+	if edited.Body != "hello" {
+		t.Errorf("body = %q, want %q", edited.Body, "hello")
+	}
+}
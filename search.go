@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/search"
+)
+
+// rebuildSearchIndex indexes every chirp in the database
+func (cfg *apiConfig) rebuildSearchIndex() error {
+	chirps, err := cfg.DB.GetChirps()
+	if err != nil {
+		return err
+	}
+
+	docs := make([]search.Document, 0, len(chirps))
+	for _, chirp := range chirps {
+		docs = append(docs, search.Document{
+			Id:       chirp.Id,
+			AuthorId: chirp.AuthorId,
+			Text:     chirp.Body,
+		})
+	}
+	cfg.searchIndex.Rebuild(docs)
+
+	return nil
+}
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := search.ParseQuery(r.URL.Query().Get("q"))
+	if query.IsEmpty() {
+		respondWithError(w, http.StatusBadRequest, "q must contain at least one search term")
+		return
+	}
+
+	// a chirp can be by any of the from: authors, so handles nobody has are
+	// left out, and only when none of them exist nothing can match
+	for _, handle := range query.Authors {
+		user, err := cfg.DB.GetUserByHandle(handle)
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			continue
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error retrieving user")
+			return
+		}
+		query.AuthorIds = append(query.AuthorIds, user.Id)
+	}
+	if len(query.Authors) > 0 && len(query.AuthorIds) == 0 {
+		respondWithJSON(w, http.StatusOK, searchResponse{Chirps: []searchResult{}})
+		return
+	}
+
+	authorIdString := r.URL.Query().Get("author_id")
+	if authorIdString != "" {
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid author id")
+			return
+		}
+		// author_id narrows the search further, so with from: clauses it
+		// keeps only the chirps matching both
+		matches := len(query.AuthorIds) == 0
+		for _, id := range query.AuthorIds {
+			if id == authorId {
+				matches = true
+			}
+		}
+		if !matches {
+			respondWithJSON(w, http.StatusOK, searchResponse{Chirps: []searchResult{}})
+			return
+		}
+		query.AuthorIds = []int{authorId}
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offset, err := decodeOffsetCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := cfg.searchIndex.Search(query)
+	total := len(results)
+
+	nextCursor := ""
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+		setNextPageLink(w, r, nextCursor)
+	}
+
+	ids := make([]int, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+
+	dbChirps, err := cfg.DB.GetChirpsByIds(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
+		return
+	}
+
+	chirps := make([]jsonDB.Chirp, 0, len(results))
+	scores := make([]float64, 0, len(results))
+	for _, result := range results {
+		chirp, ok := dbChirps[result.Id]
+		if !ok {
+			continue
+		}
+		chirps = append(chirps, chirp)
+		scores = append(scores, result.Score)
+	}
+
+	responses, err := cfg.chirpResponses(r, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp authors")
+		return
+	}
+
+	searchResults := make([]searchResult, 0, len(responses))
+	for i, response := range responses {
+		searchResults = append(searchResults, searchResult{
+			chirpResponse: response,
+			Score:         scores[i],
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, searchResponse{
+		Total:      total,
+		Chirps:     searchResults,
+		NextCursor: nextCursor,
+	})
+}
+
+type searchResult struct {
+	chirpResponse
+	Score float64 `json:"score"`
+}
+
+type searchResponse struct {
+	Total      int            `json:"total"`
+	Chirps     []searchResult `json:"chirps"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+)
+
+func searchChirps(t *testing.T, cfg *apiConfig, params url.Values) []int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/search?"+params.Encode(), nil)
+	rec := httptest.NewRecorder()
+	cfg.handlerSearchChirps(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	resp := searchResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("decoding %s: %s", rec.Body, err)
+	}
+	ids := []int{}
+	for _, chirp := range resp.Chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
+
+func TestSearchFollowsChirpChanges(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+
+	chirp, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello world", AuthorId: alice.Id})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	if ids := searchChirps(t, cfg, url.Values{"q": {"hello"}}); len(ids) != 1 {
+		t.Fatalf("found %v after creating, want chirp %d", ids, chirp.Id)
+	}
+
+	err = cfg.DB.DeleteChirp(chirp.Id, alice.Id)
+	if err != nil {
+		t.Fatalf("DeleteChirp: %s", err)
+	}
+	if ids := searchChirps(t, cfg, url.Values{"q": {"hello"}}); len(ids) != 0 {
+		t.Errorf("found %v after deleting, want nothing", ids)
+	}
+}
+
+func TestSearchAuthorFilters(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := mustCreateUser(t, cfg, "a@example.com", "alice")
+	bob := mustCreateUser(t, cfg, "b@example.com", "bob")
+	for _, author := range []jsonDB.User{alice, bob} {
+		_, err := cfg.DB.CreateChirp(jsonDB.NewChirp{Body: "hello", AuthorId: author.Id})
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		params url.Values
+		want   int
+	}{
+		{"no filter", url.Values{"q": {"hello"}}, 2},
+		{"from", url.Values{"q": {"hello from:alice"}}, 1},
+		{"either from", url.Values{"q": {"hello from:alice from:bob"}}, 2},
+		{"author_id", url.Values{"q": {"hello"}, "author_id": {strconv.Itoa(bob.Id)}}, 1},
+		{"from and same author_id", url.Values{"q": {"hello from:alice"}, "author_id": {strconv.Itoa(alice.Id)}}, 1},
+		{"from and other author_id", url.Values{"q": {"hello from:alice"}, "author_id": {strconv.Itoa(bob.Id)}}, 0},
+		{"unknown handle", url.Values{"q": {"hello from:carol"}}, 0},
+		{"unknown handle and other term", url.Values{"q": {"from:carol goodbye"}}, 0},
+		{"known or unknown handle", url.Values{"q": {"hello from:alice from:carol"}}, 1},
+		{"unknown handle and author_id", url.Values{"q": {"hello from:carol"}, "author_id": {strconv.Itoa(alice.Id)}}, 0},
+	}
+	for _, tt := range tests {
+		if ids := searchChirps(t, cfg, tt.params); len(ids) != tt.want {
+			t.Errorf("%s: found %v, want %d chirps", tt.name, ids, tt.want)
+		}
+	}
+}
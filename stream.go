@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entities"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/stream"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamHistorySize     = 1000
+	streamHeartbeat       = 15 * time.Second
+	streamRetry           = 3 * time.Second
+	websocketWriteTimeout = 10 * time.Second
+)
+
+var errStreamUnauthorized = errors.New("following=true requires authentication")
+
+var websocketUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// the API allows requests from any origin, see middlewareCors
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type streamMessage struct {
+	Id   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newStreamMessage(ev stream.Event) streamMessage {
+	return streamMessage{
+		Id:   strconv.FormatInt(ev.Id, 10),
+		Type: ev.Type,
+		Data: ev.Data,
+	}
+}
+
+// streamFilter reads the filters of a stream request: ?author_id= for one
+// author, ?following=true for the users the viewer follows at the time of
+// connecting and ?tag= for a hashtag
+func (cfg *apiConfig) streamFilter(r *http.Request) (stream.Filter, error) {
+	filter := stream.Filter{}
+	query := r.URL.Query()
+
+	if authorIdString := query.Get("author_id"); authorIdString != "" {
+		authorId, err := strconv.Atoi(authorIdString)
+		if err != nil {
+			return stream.Filter{}, errors.New("invalid author ID")
+		}
+		filter.Authors = map[int]bool{authorId: true}
+	}
+
+	if query.Get("following") == "true" {
+		userId, err := cfg.authenticateUser(r)
+		if err != nil {
+			return stream.Filter{}, errStreamUnauthorized
+		}
+		follows, err := cfg.DB.GetFollowing(userId)
+		if err != nil {
+			return stream.Filter{}, err
+		}
+
+		following := map[int]bool{}
+		for _, follow := range follows {
+			if filter.Authors == nil || filter.Authors[follow.FolloweeId] {
+				following[follow.FolloweeId] = true
+			}
+		}
+		filter.Authors = following
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		filter.Hashtag = entities.NormalizeTag(tag)
+		if filter.Hashtag == "" {
+			return stream.Filter{}, errors.New("invalid tag")
+		}
+	}
+
+	return filter, nil
+}
+
+// lastEventID returns the id of the last event a reconnecting client saw,
+// from the Last-Event-ID header browsers send for Server-Sent Events or
+// ?last_event_id= for clients that can't set headers
+func lastEventID(r *http.Request) (int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid last event ID")
+	}
+	return id, nil
+}
+
+// subscribeStream starts a stream subscription for a request, writing an
+// error response when the request is invalid
+func (cfg *apiConfig) subscribeStream(w http.ResponseWriter, r *http.Request) (*stream.Subscription, []stream.Event, bool) {
+	filter, err := cfg.streamFilter(r)
+	if err != nil {
+		if errors.Is(err, errStreamUnauthorized) {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return nil, nil, false
+		}
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	lastId, err := lastEventID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	sub, missed := cfg.streamHub.Subscribe(filter, lastId)
+	return sub, missed, true
+}
+
+// handlerStream pushes chirp events to the client as Server-Sent Events. A
+// comment is sent as a heartbeat when nothing else has been sent for a while
+func (cfg *apiConfig) handlerStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	sub, missed, ok := cfg.subscribeStream(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	for _, ev := range missed {
+		writeServerSentEvent(w, ev)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.Events:
+			if !ok {
+				// dropped for falling behind, the client reconnects and
+				// resumes from the last event it got
+				return
+			}
+			writeServerSentEvent(w, ev)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, ev stream.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Id, ev.Type, ev.Data)
+}
+
+// handlerStreamWebSocket pushes chirp events to the client over a WebSocket
+// as JSON messages. Pings are sent as heartbeats, and the connection is
+// closed when the client stops answering them
+func (cfg *apiConfig) handlerStreamWebSocket(w http.ResponseWriter, r *http.Request) {
+	sub, missed, ok := cfg.subscribeStream(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := websocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already responded
+		return
+	}
+	defer conn.Close()
+
+	// read until the client goes away, so pongs and close frames are
+	// handled, and stop writing when it does
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(ev stream.Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+		return conn.WriteJSON(newStreamMessage(ev)) == nil
+	}
+
+	for _, ev := range missed {
+		if !send(ev) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case ev, ok := <-sub.Events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind"),
+					time.Now().Add(websocketWriteTimeout))
+				return
+			}
+			if !send(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamChirpCreated publishes a new chirp to the real-time stream
+func (cfg *apiConfig) streamChirpCreated(chirp jsonDB.Chirp) {
+	data, err := json.Marshal(cfg.publicChirpResponse(chirp))
+	if err != nil {
+		log.Printf("Error encoding chirp %d for the stream: %s", chirp.Id, err)
+		return
+	}
+
+	cfg.streamHub.Publish(stream.Event{
+		Type:     stream.ChirpCreated,
+		AuthorId: chirp.AuthorId,
+		Hashtags: chirpHashtags(chirp),
+		Data:     data,
+	})
+}
+
+// streamChirpDeleted tells stream clients to remove a chirp, which is also
+// done when a moderator hides it
+func (cfg *apiConfig) streamChirpDeleted(chirp jsonDB.Chirp) {
+	type data struct {
+		Id       int `json:"id"`
+		AuthorId int `json:"author_id"`
+	}
+
+	dat, err := json.Marshal(data{
+		Id:       chirp.Id,
+		AuthorId: chirp.AuthorId,
+	})
+	if err != nil {
+		log.Printf("Error encoding chirp %d for the stream: %s", chirp.Id, err)
+		return
+	}
+
+	cfg.streamHub.Publish(stream.Event{
+		Type:     stream.ChirpDeleted,
+		AuthorId: chirp.AuthorId,
+		Hashtags: chirpHashtags(chirp),
+		Data:     dat,
+	})
+}
+
+func chirpHashtags(chirp jsonDB.Chirp) []string {
+	tags := make([]string, 0, len(chirp.Entities.Hashtags))
+	for _, hashtag := range chirp.Entities.Hashtags {
+		tags = append(tags, hashtag.Tag)
+	}
+	return tags
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/entities"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+)
+
+// extractEntities finds the hashtags and mentions in a chirp body. Mentions
+// are matched to users by handle, and mentions of unknown handles dropped
+func (cfg *apiConfig) extractEntities(body string) (entities.Entities, error) {
+	ents := entities.Extract(body)
+	if len(ents.Mentions) == 0 {
+		return ents, nil
+	}
+
+	handles := make([]string, 0, len(ents.Mentions))
+	for _, mention := range ents.Mentions {
+		handles = append(handles, mention.Handle)
+	}
+
+	users, err := cfg.DB.GetUsersByHandles(handles)
+	if err != nil {
+		return entities.Entities{}, err
+	}
+
+	mentions := []entities.Mention{}
+	for _, mention := range ents.Mentions {
+		user, ok := users[strings.ToLower(mention.Handle)]
+		if !ok {
+			continue
+		}
+		mention.Handle = user.Handle
+		mention.UserId = user.Id
+		mentions = append(mentions, mention)
+	}
+	ents.Mentions = mentions
+
+	return ents, nil
+}
+
+func (cfg *apiConfig) handlerGetTag(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeTag(chi.URLParam(r, "tag"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "invalid tag")
+		return
+	}
+
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	beforeId, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpsPage(jsonDB.ChirpQuery{
+		Hashtag:    tag,
+		AfterId:    beforeId,
+		Descending: true,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirps")
+		return
+	}
+
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		setNextPageLink(w, r, encodeCursor(chirps[len(chirps)-1].Id))
+	}
+
+	responses, err := cfg.chirpResponses(r, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp authors")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+func (cfg *apiConfig) handlerTrendingTags(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendingWindow
+	windowString := r.URL.Query().Get("window")
+	if windowString != "" {
+		var err error
+		window, err = time.ParseDuration(windowString)
+		if err != nil || window <= 0 || window > maxTrendingWindow {
+			respondWithError(w, http.StatusBadRequest, "window must be a duration between 0 and 168h")
+			return
+		}
+	}
+
+	limit := defaultTrendingLimit
+	limitString := r.URL.Query().Get("limit")
+	if limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			respondWithError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	counts, err := cfg.DB.CountHashtags(time.Now().UTC().Add(-window))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count tags")
+		return
+	}
+
+	type trendingTag struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+
+	tags := make([]trendingTag, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, trendingTag{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	type response struct {
+		Window string        `json:"window"`
+		Tags   []trendingTag `json:"tags"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Window: window.String(),
+		Tags:   tags,
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/go-chi/chi"
+)
+
+type threadNode struct {
+	chirpResponse
+	Replies []threadNode `json:"replies"`
+}
+
+func (cfg *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid chirp ID")
+		return
+	}
+
+	chirp, ancestors, descendants, err := cfg.DB.GetThread(chirpID)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch thread")
+		return
+	}
+	if !cfg.canViewChirp(r, chirp) {
+		respondWithError(w, http.StatusNotFound, "chirp not found")
+		return
+	}
+
+	all := append([]jsonDB.Chirp{chirp}, ancestors...)
+	all = append(all, descendants...)
+	responses, err := cfg.chirpResponses(r, all)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch chirp authors")
+		return
+	}
+
+	ancestorResponses := responses[1 : 1+len(ancestors)]
+	replies := map[int][]chirpResponse{}
+	for _, reply := range responses[1+len(ancestors):] {
+		replies[reply.InReplyTo] = append(replies[reply.InReplyTo], reply)
+	}
+
+	type response struct {
+		Ancestors []chirpResponse `json:"ancestors"`
+		Chirp     threadNode      `json:"chirp"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Ancestors: ancestorResponses,
+		Chirp:     buildThreadNode(responses[0], replies),
+	})
+}
+
+// buildThreadNode nests the replies to a chirp below it, in the order
+// GetThread returned them, which is oldest first
+func buildThreadNode(chirp chirpResponse, replies map[int][]chirpResponse) threadNode {
+	children := replies[chirp.Id]
+
+	node := threadNode{
+		chirpResponse: chirp,
+		Replies:       make([]threadNode, 0, len(children)),
+	}
+	for _, child := range children {
+		node.Replies = append(node.Replies, buildThreadNode(child, replies))
+	}
+
+	return node
+}
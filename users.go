@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/emilmalmsten/chirpy/internal/auth"
@@ -15,6 +14,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -25,32 +25,50 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if params.Handle != "" {
+		err = validateHandle(params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	storedHash, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
 	}
 
-	user, err := cfg.DB.CreateUser(params.Email, storedHash)
+	user, err := cfg.DB.CreateUser(params.Email, storedHash, params.Handle)
 	if err != nil {
 		if errors.Is(err, jsonDB.ErrAlreadyExists) {
 			respondWithError(w, http.StatusConflict, "user already exists")
 			return
 		}
+		if errors.Is(err, jsonDB.ErrHandleTaken) {
+			respondWithError(w, http.StatusConflict, "handle already taken")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "failed to create user")
 		return
 	}
 
 	type returnUser struct {
-		Id            int    `json:"id"`
-		Email         string `json:"email"`
-		Is_chirpy_red bool   `json:"is_chirpy_red"`
+		Id            int       `json:"id"`
+		Email         string    `json:"email"`
+		Handle        string    `json:"handle"`
+		Is_chirpy_red bool      `json:"is_chirpy_red"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
 	}
 
 	respondWithJSON(w, http.StatusCreated, returnUser{
 		Id:            user.Id,
 		Email:         user.Email,
+		Handle:        user.Handle,
 		Is_chirpy_red: false,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	})
 
 }
@@ -65,6 +83,7 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 	type response struct {
 		Id            int    `json:"id"`
 		Email         string `json:"email"`
+		Handle        string `json:"handle"`
 		Is_chirpy_red bool   `json:"is_chirpy_red"`
 		Token         string `json:"token"`
 		RefreshToken  string `json:"refresh_token"`
@@ -94,13 +113,22 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// logging in during the grace period reactivates the account
+	if user.DeletionScheduledAt != nil {
+		user, err = cfg.DB.CancelUserDeletion(user.Id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "couldn't cancel account deletion")
+			return
+		}
+	}
+
 	accessToken, err := auth.CreateJWT(user.Id, []byte(cfg.jwtSecret), time.Hour, auth.TokenTypeAccess)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "jwt accessToken error")
 		return
 	}
 
-	refreshToken, err := auth.CreateJWT(user.Id, []byte(cfg.jwtSecret), time.Hour*24*60, auth.TokenTypeAccess)
+	refreshToken, err := auth.CreateJWT(user.Id, []byte(cfg.jwtSecret), time.Hour*24*60, auth.TokenTypeRefresh)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "jwt refreshToken error")
 		return
@@ -109,24 +137,15 @@ func (cfg *apiConfig) handlerUsersLogin(w http.ResponseWriter, r *http.Request)
 	respondWithJSON(w, http.StatusOK, response{
 		Id:            user.Id,
 		Email:         user.Email,
-		Is_chirpy_red: user.Is_chirpy_red,
+		Handle:        user.Handle,
+		Is_chirpy_red: user.IsChirpyRed(time.Now()),
 		Token:         accessToken,
 		RefreshToken:  refreshToken,
 	})
 }
 
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		if errors.Is(err, jsonDB.ErrAlreadyExists) {
-			respondWithError(w, http.StatusInternalServerError, "auth header missing")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "malformed auth header")
-		return
-	}
-
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userIDInt, err := cfg.authenticateUser(r)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "invalid jwt token")
 		return
@@ -151,12 +170,6 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(userId)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't parse user ID")
-		return
-	}
-
 	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to update user info")
@@ -164,59 +177,21 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 	}
 
 	type returnUser struct {
-		Id            int    `json:"id"`
-		Email         string `json:"email"`
-		Is_chirpy_red bool   `json:"is_chirpy_red"`
+		Id            int       `json:"id"`
+		Email         string    `json:"email"`
+		Handle        string    `json:"handle"`
+		Is_chirpy_red bool      `json:"is_chirpy_red"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
 	}
 
 	respondWithJSON(w, http.StatusOK, returnUser{
 		Id:            user.Id,
 		Email:         user.Email,
-		Is_chirpy_red: user.Is_chirpy_red,
+		Handle:        user.Handle,
+		Is_chirpy_red: user.IsChirpyRed(time.Now()),
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	})
 
 }
-
-func (cfg *apiConfig) handlerUpgradeMembership(w http.ResponseWriter, r *http.Request) {
-
-	requestKey, err := auth.GetApiKey(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "could not verify api key")
-	}
-
-	if requestKey != cfg.polkaApiKey {
-		respondWithError(w, http.StatusUnauthorized, "wrong api key")
-	}
-
-	type parameters struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID int `json:"user_id"`
-		} `json:"data"`
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't decode parameters")
-		return
-	}
-
-	if params.Event != "user.upgraded" {
-		respondWithJSON(w, http.StatusOK, struct{}{})
-		return
-	}
-
-	user, err := cfg.DB.UpgradeUser(params.Data.UserID)
-	if err != nil {
-		if errors.Is(err, jsonDB.ErrDoesNotExists) {
-			respondWithError(w, http.StatusNotFound, "user not found")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "failed to upgrade user")
-		return
-	}
-
-	respondWithJSON(w, http.StatusOK, user)
-}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emilmalmsten/chirpy/internal/events"
+	"github.com/emilmalmsten/chirpy/internal/jsonDB"
+	"github.com/emilmalmsten/chirpy/internal/safehttp"
+	"github.com/emilmalmsten/chirpy/internal/webhooks"
+	"github.com/go-chi/chi"
+)
+
+const (
+	webhookTimeout      = 10 * time.Second
+	webhookPollInterval = 5 * time.Second
+	minWebhookSecretLen = 16
+)
+
+type webhookSubscriptionResponse struct {
+	Id        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// newWebhookSubscriptionResponse leaves out the signing secret, which is
+// only shown when the subscription is created
+func newWebhookSubscriptionResponse(sub jsonDB.WebhookSubscription) webhookSubscriptionResponse {
+	return webhookSubscriptionResponse{
+		Id:        sub.Id,
+		URL:       sub.URL,
+		Events:    sub.Events,
+		Active:    sub.Active,
+		CreatedAt: sub.CreatedAt,
+		UpdatedAt: sub.UpdatedAt,
+	}
+}
+
+// validateWebhookURL checks that a URL is an absolute http or https URL on
+// the public internet, so subscriptions can't be used to reach internal
+// services
+func validateWebhookURL(ctx context.Context, rawURL string) error {
+	err := safehttp.CheckURL(ctx, rawURL)
+	if errors.Is(err, safehttp.ErrForbiddenAddress) {
+		return errors.New("url must not point to a private or local address")
+	}
+	return err
+}
+
+// validateWebhookEvents checks that events lists at least one known event
+// type and returns it without duplicates
+func validateWebhookEvents(events []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, event := range jsonDB.WebhookEventTypes {
+		known[event] = true
+	}
+
+	seen := map[string]bool{}
+	cleaned := []string{}
+	for _, event := range events {
+		if !known[event] {
+			return nil, errors.New("unknown event type: " + event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			cleaned = append(cleaned, event)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, errors.New("events must list at least one event type")
+	}
+	return cleaned, nil
+}
+
+func (cfg *apiConfig) handlerCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	err = validateWebhookURL(r.Context(), params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := validateWebhookEvents(params.Events)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret := params.Secret
+	if secret == "" {
+		secret, err = webhooks.NewSecret()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to generate secret")
+			return
+		}
+	} else if len(secret) < minWebhookSecretLen {
+		respondWithError(w, http.StatusBadRequest, "secret must be at least 16 characters")
+		return
+	}
+
+	sub, err := cfg.DB.CreateWebhookSubscription(params.URL, events, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+
+	response := newWebhookSubscriptionResponse(sub)
+	response.Secret = sub.Secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	subs, err := cfg.DB.GetWebhookSubscriptions()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch webhooks")
+		return
+	}
+
+	responses := make([]webhookSubscriptionResponse, 0, len(subs))
+	for _, sub := range subs {
+		responses = append(responses, newWebhookSubscriptionResponse(sub))
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+func (cfg *apiConfig) handlerGetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	subID, err := strconv.Atoi(chi.URLParam(r, "subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook ID")
+		return
+	}
+
+	sub, err := cfg.DB.GetWebhookSubscription(subID)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookSubscriptionResponse(sub))
+}
+
+func (cfg *apiConfig) handlerUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	subID, err := strconv.Atoi(chi.URLParam(r, "subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook ID")
+		return
+	}
+
+	type parameters struct {
+		URL    *string   `json:"url"`
+		Events *[]string `json:"events"`
+		Active *bool     `json:"active"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "couldn't decode parameters")
+		return
+	}
+
+	if params.URL != nil {
+		err = validateWebhookURL(r.Context(), *params.URL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if params.Events != nil {
+		events, err := validateWebhookEvents(*params.Events)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Events = &events
+	}
+
+	sub, err := cfg.DB.UpdateWebhookSubscription(subID, jsonDB.WebhookSubscriptionUpdate{
+		URL:    params.URL,
+		Events: params.Events,
+		Active: params.Active,
+	})
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookSubscriptionResponse(sub))
+}
+
+func (cfg *apiConfig) handlerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	subID, err := strconv.Atoi(chi.URLParam(r, "subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook ID")
+		return
+	}
+
+	err = cfg.DB.DeleteWebhookSubscription(subID)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerGetWebhookDeliveries responds with the delivery log of a
+// subscription, optionally filtered by ?status=
+func (cfg *apiConfig) handlerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	subID, err := strconv.Atoi(chi.URLParam(r, "subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook ID")
+		return
+	}
+
+	_, err = cfg.DB.GetWebhookSubscription(subID)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != jsonDB.DeliveryPending && status != jsonDB.DeliveryDelivered && status != jsonDB.DeliveryDead {
+		respondWithError(w, http.StatusBadRequest, "status must be pending, delivered or dead")
+		return
+	}
+
+	cfg.respondWithWebhookDeliveries(w, r, subID, status)
+}
+
+// handlerGetDeadWebhookDeliveries responds with the deliveries of every
+// subscription that ran out of attempts
+func (cfg *apiConfig) handlerGetDeadWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	cfg.respondWithWebhookDeliveries(w, r, 0, jsonDB.DeliveryDead)
+}
+
+func (cfg *apiConfig) respondWithWebhookDeliveries(w http.ResponseWriter, r *http.Request, subID int, status string) {
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offset, err := decodeOffsetCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deliveries, err := cfg.DB.GetWebhookDeliveries(subID, status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to fetch webhook deliveries")
+		return
+	}
+
+	nextCursor := ""
+	if offset > len(deliveries) {
+		offset = len(deliveries)
+	}
+	page := deliveries[offset:]
+	if len(page) > limit {
+		page = page[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+	}
+
+	type response struct {
+		Total      int                      `json:"total"`
+		Deliveries []jsonDB.WebhookDelivery `json:"deliveries"`
+		NextCursor string                   `json:"next_cursor,omitempty"`
+	}
+
+	setNextPageLink(w, r, nextCursor)
+	respondWithJSON(w, http.StatusOK, response{
+		Total:      len(deliveries),
+		Deliveries: page,
+		NextCursor: nextCursor,
+	})
+}
+
+// handlerRetryWebhookDelivery queues a failed or dead delivery to be sent
+// again right away
+func (cfg *apiConfig) handlerRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authenticateAdmin(w, r) {
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid delivery ID")
+		return
+	}
+
+	delivery, err := cfg.DB.RetryWebhookDelivery(deliveryID)
+	if err != nil {
+		if errors.Is(err, jsonDB.ErrDoesNotExists) {
+			respondWithError(w, http.StatusNotFound, "delivery not found")
+			return
+		}
+		if errors.Is(err, jsonDB.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "delivery already succeeded")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "failed to retry delivery")
+		return
+	}
+	cfg.webhooks.Wake()
+
+	respondWithJSON(w, http.StatusAccepted, delivery)
+}
+
+func respondWithWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, jsonDB.ErrDoesNotExists) {
+		respondWithError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "failed to fetch webhook")
+}
+
+// webhookChirpCreated queues a chirp.created webhook
+func (cfg *apiConfig) webhookChirpCreated(e events.ChirpCreated) error {
+	return cfg.webhooks.Publish(jsonDB.WebhookChirpCreated, cfg.publicChirpResponse(e.Chirp))
+}
+
+// webhookChirpDeleted queues a chirp.deleted webhook
+func (cfg *apiConfig) webhookChirpDeleted(e events.ChirpDeleted) error {
+	type data struct {
+		Id       int `json:"id"`
+		AuthorId int `json:"author_id"`
+	}
+
+	return cfg.webhooks.Publish(jsonDB.WebhookChirpDeleted, data{
+		Id:       e.Chirp.Id,
+		AuthorId: e.Chirp.AuthorId,
+	})
+}
+
+// webhookUserCreated queues a user.created webhook
+func (cfg *apiConfig) webhookUserCreated(e events.UserCreated) error {
+	return cfg.webhooks.Publish(jsonDB.WebhookUserCreated, eventUserProfile(e.User))
+}
+
+// webhookUserUpgraded queues a user.upgraded webhook
+func (cfg *apiConfig) webhookUserUpgraded(e events.UserUpgraded) error {
+	return cfg.webhooks.Publish(jsonDB.WebhookUserUpgraded, eventUserProfile(e.User))
+}
+
+// webhookSessionRevoked queues a session.revoked webhook, so integrations
+// can keep an audit trail of sign-outs
+func (cfg *apiConfig) webhookSessionRevoked(e events.TokenRevoked) error {
+	type data struct {
+		UserId    int       `json:"user_id"`
+		RevokedAt time.Time `json:"revoked_at"`
+	}
+
+	return cfg.webhooks.Publish(jsonDB.WebhookSessionRevoked, data{
+		UserId:    e.Revocation.UserId,
+		RevokedAt: e.Revocation.RevokedAt,
+	})
+}
+
+// eventUserProfile returns the public profile of the user in a user event
+func eventUserProfile(user jsonDB.EventUser) publicProfile {
+	return publicProfile{
+		Id:          user.Id,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt,
+	}
+}